@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/decentraland/content-service/config"
+	"github.com/decentraland/content-service/data"
+	"github.com/decentraland/content-service/internal/gc"
+	"github.com/decentraland/content-service/metrics"
+	"github.com/decentraland/content-service/storage"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	conf := config.GetConfig("config")
+
+	dryRun := flag.Bool("dry-run", false, "only report the files that would be deleted")
+	grace := flag.Int64("grace", conf.GC.GracePeriod, "seconds a file must be unreferenced before being deleted")
+	flag.Parse()
+
+	agent, _ := metrics.Make(config.Metrics{AnalyticsKey: "", Enabled: false, AppName: ""})
+	client, err := data.NewIndexClient(conf, agent)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sto := storage.NewStorage(&conf.Storage, agent)
+
+	l := logrus.New()
+	collector := gc.NewCollector(client, sto, time.Duration(*grace)*time.Second, l)
+	report, err := collector.Run(context.Background(), *dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/decentraland/content-service/config"
+	"github.com/decentraland/content-service/data"
+	"github.com/go-redis/redis"
+)
+
+func main() {
+	conf := config.GetConfig("config")
+
+	batch := flag.Int64("batch", 1000, "keys requested on every scan")
+	verify := flag.Bool("verify", false, "compare every old key with its copy")
+	commit := flag.Bool("commit", false, "verify the copies and mark the dataset as migrated, the old service must be stopped")
+	cleanup := flag.Bool("cleanup", false, "delete the old keys of a migrated dataset")
+	flag.Parse()
+
+	// data.NewRedisClient refuses to connect to a dataset in the old layout
+	client := redis.NewClient(&redis.Options{
+		Addr:     conf.Redis.Address,
+		Password: conf.Redis.Password,
+		DB:       conf.Redis.DB,
+	})
+	defer client.Close()
+
+	report, err := data.Migrate(client, data.MigrationOptions{
+		BatchSize: *batch,
+		Verify:    *verify,
+		Commit:    *commit,
+		Cleanup:   *cleanup,
+	})
+	if report != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -11,23 +12,16 @@ import (
 	"github.com/decentraland/content-service/metrics"
 
 	"github.com/decentraland/content-service/config"
+	"github.com/decentraland/content-service/data"
 	"github.com/decentraland/content-service/internal/handlers"
 	"github.com/decentraland/content-service/storage"
 	"github.com/fatih/structs"
-	"github.com/go-redis/redis"
 )
 
 var conf *config.Configuration
-var client *redis.Client
 
 func init() {
 	conf = config.GetConfig("config")
-
-	client = redis.NewClient(&redis.Options{
-		Addr:     conf.Redis.Address,
-		Password: conf.Redis.Password,
-		DB:       conf.Redis.DB,
-	})
 }
 
 func main() {
@@ -50,6 +44,10 @@ func main() {
 
 	agent, _ := metrics.Make(config.Metrics{AnalyticsKey: "", Enabled: false, AppName: ""})
 	sto := storage.NewStorage(&conf.Storage, agent)
+	client, err := data.NewIndexClient(conf, agent)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	mappingsURL := fmt.Sprintf("%smappings?nw=%s,%s&se=%s,%s", url, x1, y1, x2, y2)
 	resp, err := http.Get(mappingsURL)
@@ -64,6 +62,7 @@ func main() {
 		log.Fatal(err)
 	}
 
+	sceneParcels := make(map[string][]string)
 	for _, parcel := range parcelContents {
 		xy := strings.Split(parcel.ParcelID, ",")
 		validateURL := fmt.Sprintf("%svalidate?x=%s&y=%s", url, xy[0], xy[1])
@@ -79,33 +78,42 @@ func main() {
 			log.Fatal(err)
 		}
 
-		err = client.Set(parcel.ParcelID, parcelMetadata.RootCid, 0).Err()
-		if err != nil {
-			log.Fatal(err)
-		}
+		sceneParcels[parcelMetadata.RootCid] = append(sceneParcels[parcelMetadata.RootCid], parcel.ParcelID)
 
-		err = client.HMSet("metadata_"+parcelMetadata.RootCid, structs.Map(parcelMetadata)).Err()
+		err = client.StoreMetadata(parcelMetadata.RootCid, structs.Map(parcelMetadata))
 		if err != nil {
 			log.Fatal(err)
 		}
 
 		for _, element := range parcel.Contents {
-			downloadURL := fmt.Sprintf("%scontents?%s", url, element.Cid)
-			resp, err := http.Get(downloadURL)
+			exists, err := sto.Exists(context.Background(), element.Cid)
 			if err != nil {
 				log.Fatal(err)
 			}
-			defer resp.Body.Close()
-
-			_, err = sto.SaveFile(element.Cid, resp.Body, resp.Header.Get("Content-Type"))
-			if err != nil {
-				log.Fatal(err)
+			if !exists {
+				downloadURL := fmt.Sprintf("%scontents?%s", url, element.Cid)
+				resp, err := http.Get(downloadURL)
+				if err != nil {
+					log.Fatal(err)
+				}
+				defer resp.Body.Close()
+
+				_, err = sto.SaveFile(element.Cid, resp.Body, resp.Header.Get("Content-Type"))
+				if err != nil {
+					log.Fatal(err)
+				}
 			}
 
-			err = client.HSet("content_"+parcelMetadata.RootCid, element.File, element.Cid).Err()
+			err = client.StoreContent(parcelMetadata.RootCid, element.File, element.Cid)
 			if err != nil {
 				log.Fatal(err)
 			}
 		}
 	}
+
+	for rootCid, parcels := range sceneParcels {
+		if err := client.SetSceneParcels(rootCid, parcels); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
@@ -14,6 +14,7 @@ type Configuration struct {
 	Server              Server
 	Storage             Storage
 	Redis               Redis
+	Index               Index
 	DecentralandApi     DecentralandApi
 	LogLevel            string
 	Metrics             Metrics
@@ -21,7 +22,10 @@ type Configuration struct {
 	Limits              Limits
 	Workdir             string
 	UploadRequestTTL    int64
+	DeploymentTTL       int64
 	RPCConnection       RPCConnection
+	GC                  GC
+	Events              Events
 }
 
 type DecentralandApi struct {
@@ -34,15 +38,97 @@ type Redis struct {
 	DB       int
 }
 
+// Backend of the index of scenes and contents
+type Index struct {
+	// REDIS or LEVELDB
+	Type string
+	// Directory of the LEVELDB index
+	Path  string
+	Cache IndexCache
+}
+
+// In-process cache of the parcel and scene lookups, in front of the index
+type IndexCache struct {
+	Enabled bool
+	// Seconds an entry is kept, bounds how stale a lookup can be if an invalidation is lost
+	TTL        int64
+	MaxEntries int
+}
+
+type IndexType string
+
+const (
+	REDIS IndexType = "REDIS"
+	// Embedded database, no server needed
+	LEVELDB IndexType = "LEVELDB"
+)
+
 type Storage struct {
 	StorageType  string
 	RemoteConfig RemoteStorage
+	HTTPConfig   HTTPStorage
 	LocalPath    string
+	// Hash the local files before serving them
+	VerifyIntegrity bool
+	// Hash every local file on startup, removing the ones not matching their CID
+	VerifyOnStartup bool
+	Cache           StorageCache
+	Replication     Replication
+}
+
+// Replicas of the REPLICATED storage, each one configured as a storage of its own
+type Replication struct {
+	Replicas []Storage
+	// Replicas that must store a file before the write succeeds, 0 means a majority
+	WriteQuorum int
+	// Seconds between repairs of the replicas missing files, 0 disables them
+	RepairInterval int64
+	// Stored files checked by each repair besides the ones with failed writes, 0 only checks those
+	RepairBatch int
+	// Directory of the files buffered while they are copied to the replicas
+	Workdir string
+}
+
+// Local disk cache in front of the storage
+type StorageCache struct {
+	Enabled bool
+	Dir     string
+	// Bytes
+	MaxSize int64
+	// LRU or FIFO
+	Eviction string
 }
 
 type Limits struct {
 	ParcelSizeLimit   int64
 	ParcelAssetsLimit int
+	// Files hashed, downloaded or stored at the same time by an upload
+	UploadConcurrency int
+	// Asynchronous deployments processed at the same time
+	AsyncWorkers int
+	// Asynchronous deployments waiting for a worker, the next ones are rejected
+	AsyncQueueSize int
+	// Upload sessions open at the same time, 0 for no limit
+	UploadSessions int
+	// Upload sessions an address can have open at the same time, 0 for no limit
+	AddressUploadSessions int
+}
+
+// Garbage collection of the files no longer referenced by any scene
+type GC struct {
+	Enabled bool
+	// Seconds between collections
+	Interval int64
+	// Seconds a file must be unreferenced before being deleted
+	GracePeriod int64
+	// Only report what would be deleted
+	DryRun bool
+}
+
+// Stream of deployment events served at /events
+type Events struct {
+	// Last events kept, subscribers can resume from any of them
+	History int
 }
 
 type StorageType string
@@ -52,14 +138,43 @@ type RPCConnection struct {
 }
 
 const (
-	REMOTE StorageType = "REMOTE"
-	LOCAL  StorageType = "LOCAL"
+	REMOTE  StorageType = "REMOTE"
+	LOCAL   StorageType = "LOCAL"
+	SHARDED StorageType = "SHARDED"
+	HTTP    StorageType = "HTTP"
+	// Several storages, each one with a copy of the files
+	REPLICATED StorageType = "REPLICATED"
 )
 
 type RemoteStorage struct {
 	Bucket string
 	ACL    string
 	URL    string
+	// Stream the content through the service instead of redirecting to the bucket
+	Proxy bool
+	// Uses the AWS_REGION env variable when empty
+	Region string
+	// Custom S3 endpoint, e.g. a MinIO server
+	Endpoint string
+	// Bucket in the path instead of in the host name, required by most S3-compatible servers
+	PathStyle bool
+	// 0 keeps the SDK default
+	MaxRetries int
+	// Seconds to connect and to receive the response headers, 0 means no timeout
+	Timeout int64
+	// Bytes of each part in multipart uploads and downloads, 0 keeps the SDK default (5MB)
+	PartSize int64
+}
+
+// Generic HTTP object store, objects are reached at <URL>/<cid>
+type HTTPStorage struct {
+	URL string
+	// Sent as a bearer token when set
+	Token string
+	// Seconds, 0 means no timeout
+	Timeout int64
+	// Stream the content through the service instead of redirecting to the store
+	Proxy bool
 }
 
 type Server struct {
@@ -105,11 +220,38 @@ func readEnvVariables(v *viper.Viper) {
 	v.BindEnv("storage.remoteConfig.bucket", "AWS_S3_BUCKET")
 	v.BindEnv("storage.remoteConfig.url", "AWS_S3_URL")
 	v.BindEnv("storage.remoteConfig.acl", "AWS_S3_ACL")
+	v.BindEnv("storage.remoteConfig.proxy", "AWS_S3_PROXY")
+	v.BindEnv("storage.remoteConfig.region", "AWS_S3_REGION")
+	v.BindEnv("storage.remoteConfig.endpoint", "AWS_S3_ENDPOINT")
+	v.BindEnv("storage.remoteConfig.pathStyle", "AWS_S3_PATH_STYLE")
+	v.BindEnv("storage.remoteConfig.maxRetries", "AWS_S3_MAX_RETRIES")
+	v.BindEnv("storage.remoteConfig.timeout", "AWS_S3_TIMEOUT")
+	v.BindEnv("storage.remoteConfig.partSize", "AWS_S3_PART_SIZE")
+	v.BindEnv("storage.httpConfig.url", "HTTP_STORAGE_URL")
+	v.BindEnv("storage.httpConfig.token", "HTTP_STORAGE_TOKEN")
+	v.BindEnv("storage.httpConfig.timeout", "HTTP_STORAGE_TIMEOUT")
+	v.BindEnv("storage.httpConfig.proxy", "HTTP_STORAGE_PROXY")
 	v.BindEnv("storage.localPath", "LOCAL_STORAGE_PATH")
+	v.BindEnv("storage.verifyIntegrity", "STORAGE_VERIFY_INTEGRITY")
+	v.BindEnv("storage.verifyOnStartup", "STORAGE_VERIFY_ON_STARTUP")
+	v.BindEnv("storage.cache.enabled", "STORAGE_CACHE_ENABLED")
+	v.BindEnv("storage.cache.dir", "STORAGE_CACHE_DIR")
+	v.BindEnv("storage.cache.maxSize", "STORAGE_CACHE_MAX_SIZE")
+	v.BindEnv("storage.cache.eviction", "STORAGE_CACHE_EVICTION")
+	v.BindEnv("storage.replication.writeQuorum", "STORAGE_WRITE_QUORUM")
+	v.BindEnv("storage.replication.repairInterval", "STORAGE_REPAIR_INTERVAL")
+	v.BindEnv("storage.replication.repairBatch", "STORAGE_REPAIR_BATCH")
+	v.BindEnv("storage.replication.workdir", "STORAGE_REPLICATION_WORKDIR")
 	// Redis Configuration
 	v.BindEnv("redis.address", "REDIS_ADDRESS")
 	v.BindEnv("redis.password", "REDIS_PASSWORD")
 	v.BindEnv("redis.db", "REDIS_DB")
+	// Index Configuration
+	v.BindEnv("index.type", "INDEX_TYPE")
+	v.BindEnv("index.path", "INDEX_PATH")
+	v.BindEnv("index.cache.enabled", "INDEX_CACHE_ENABLED")
+	v.BindEnv("index.cache.ttl", "INDEX_CACHE_TTL")
+	v.BindEnv("index.cache.maxEntries", "INDEX_CACHE_MAX_ENTRIES")
 	// DCL API
 	v.BindEnv("decentralandapi.landurl", "DCL_API")
 	// LOG LEVEL
@@ -122,13 +264,28 @@ func readEnvVariables(v *viper.Viper) {
 	//Limits
 	v.BindEnv("limits.parcelSizeLimit", "LIMIT_PARCEL_SIZE")
 	v.BindEnv("limits.parcelAssetsLimit", "LIMIT_PARCEL_ASSETS")
+	v.BindEnv("limits.uploadConcurrency", "LIMIT_UPLOAD_CONCURRENCY")
+	v.BindEnv("limits.asyncWorkers", "LIMIT_ASYNC_WORKERS")
+	v.BindEnv("limits.asyncQueueSize", "LIMIT_ASYNC_QUEUE_SIZE")
+	v.BindEnv("limits.uploadSessions", "LIMIT_UPLOAD_SESSIONS")
+	v.BindEnv("limits.addressUploadSessions", "LIMIT_ADDRESS_UPLOAD_SESSIONS")
 
 	v.BindEnv("workdir", "WORK_DIR")
 
 	v.BindEnv("uploadRequestTTL", "UPLOAD_TTL")
+	v.BindEnv("deploymentTTL", "DEPLOYMENT_TTL")
 
 	v.BindEnv("rpcconnection.url", "RPCCONNECTION_URL")
 
+	// GC
+	v.BindEnv("gc.enabled", "GC_ENABLED")
+	v.BindEnv("gc.interval", "GC_INTERVAL")
+	v.BindEnv("gc.gracePeriod", "GC_GRACE_PERIOD")
+	v.BindEnv("gc.dryRun", "GC_DRY_RUN")
+
+	// Events
+	v.BindEnv("events.history", "EVENTS_HISTORY")
+
 	//Allowed content types
 	contentEnv := os.Getenv("ALLOWED_TYPES")
 	if len(contentEnv) > 0 {
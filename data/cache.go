@@ -0,0 +1,336 @@
+package data
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/decentraland/content-service/metrics"
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+)
+
+// Broadcasts the index keys changed by a deployment to every replica of the service
+type Invalidations interface {
+	PublishInvalidation(keys []string) error
+	// Calls f with the keys published by any replica, until the returned function is called
+	SubscribeInvalidations(f func(keys []string)) (func() error, error)
+}
+
+// Read-through cache of the parcel -> root cid, root cid -> [parcels] and root cid <-> scene cid lookups
+// Entries expire after the TTL, and the least recently used ones are evicted beyond maxEntries
+// Every write through the cache drops the entries it changes, locally and in the other replicas through
+// Invalidations. The rest of the methods go straight to the index
+type SceneCache struct {
+	RedisClient
+	Invalidations Invalidations
+	Agent         *metrics.Agent
+
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	// Incremented on every invalidation, a lookup only fills the cache if no invalidation happened meanwhile
+	generation  uint64
+	unsubscribe func() error
+}
+
+type cacheEntry struct {
+	key string
+	// string or []string
+	value interface{}
+	// false if the key does not exist in the index
+	found   bool
+	expires time.Time
+}
+
+// inv may be nil when the index is not shared with other replicas
+func NewSceneCache(client RedisClient, ttl time.Duration, maxEntries int, inv Invalidations, agent *metrics.Agent) (*SceneCache, error) {
+	c := &SceneCache{
+		RedisClient:   client,
+		Invalidations: inv,
+		Agent:         agent,
+		ttl:           ttl,
+		maxEntries:    maxEntries,
+		entries:       make(map[string]*list.Element),
+		lru:           list.New(),
+	}
+	if inv != nil {
+		unsubscribe, err := inv.SubscribeInvalidations(c.invalidate)
+		if err != nil {
+			return nil, err
+		}
+		c.unsubscribe = unsubscribe
+	}
+	return c, nil
+}
+
+// Stops receiving the invalidations of the other replicas
+func (c *SceneCache) Close() error {
+	if c.unsubscribe == nil {
+		return nil
+	}
+	return c.unsubscribe()
+}
+
+func (c *SceneCache) GetParcelCID(pid string) (string, error) {
+	if e, ok := c.lookup(parcelPrefix + pid); ok {
+		return e.value.(string), nil
+	}
+	gen := c.currentGeneration()
+	cid, err := c.RedisClient.GetParcelCID(pid)
+	if err != nil {
+		return "", err
+	}
+	c.fill(gen, parcelPrefix+pid, cid, cid != "")
+	return cid, nil
+}
+
+func (c *SceneCache) GetParcelCIDs(pids []string) (map[string]string, error) {
+	ret := make(map[string]string, len(pids))
+	var missing []string
+	for _, pid := range pids {
+		if e, ok := c.lookup(parcelPrefix + pid); !ok {
+			missing = append(missing, pid)
+		} else if e.found {
+			ret[pid] = e.value.(string)
+		}
+	}
+	if len(missing) == 0 {
+		return ret, nil
+	}
+	gen := c.currentGeneration()
+	cids, err := c.RedisClient.GetParcelCIDs(missing)
+	if err != nil {
+		return nil, err
+	}
+	for _, pid := range missing {
+		cid, ok := cids[pid]
+		c.fill(gen, parcelPrefix+pid, cid, ok)
+		if ok {
+			ret[pid] = cid
+		}
+	}
+	return ret, nil
+}
+
+func (c *SceneCache) GetSceneParcels(cid string) ([]string, error) {
+	if e, ok := c.lookup(sceneParcelsPrefix + cid); ok {
+		return e.value.([]string), nil
+	}
+	gen := c.currentGeneration()
+	parcels, err := c.RedisClient.GetSceneParcels(cid)
+	if err != nil {
+		return nil, err
+	}
+	if parcels == nil {
+		parcels = []string{}
+	}
+	c.fill(gen, sceneParcelsPrefix+cid, parcels, len(parcels) > 0)
+	return parcels, nil
+}
+
+func (c *SceneCache) GetScenesParcels(cids []string) (map[string][]string, error) {
+	ret := make(map[string][]string, len(cids))
+	var missing []string
+	for _, cid := range cids {
+		if e, ok := c.lookup(sceneParcelsPrefix + cid); !ok {
+			missing = append(missing, cid)
+		} else if e.found {
+			ret[cid] = e.value.([]string)
+		}
+	}
+	if len(missing) == 0 {
+		return ret, nil
+	}
+	gen := c.currentGeneration()
+	parcels, err := c.RedisClient.GetScenesParcels(missing)
+	if err != nil {
+		return nil, err
+	}
+	for _, cid := range missing {
+		ps, ok := parcels[cid]
+		if !ok {
+			ps = []string{}
+		}
+		c.fill(gen, sceneParcelsPrefix+cid, ps, ok)
+		if ok {
+			ret[cid] = ps
+		}
+	}
+	return ret, nil
+}
+
+func (c *SceneCache) GetSceneCid(rootCID string) (string, error) {
+	return c.getSceneMapping(rootCID, c.RedisClient.GetSceneCid)
+}
+
+func (c *SceneCache) GetRootCid(sceneCID string) (string, error) {
+	return c.getSceneMapping(sceneCID, c.RedisClient.GetRootCid)
+}
+
+// Both directions share the keys, as in the index
+func (c *SceneCache) getSceneMapping(cid string, get func(string) (string, error)) (string, error) {
+	if e, ok := c.lookup(rootScenePrefix + cid); ok {
+		if !e.found {
+			return "", redis.Nil
+		}
+		return e.value.(string), nil
+	}
+	gen := c.currentGeneration()
+	v, err := get(cid)
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	c.fill(gen, rootScenePrefix+cid, v, err == nil)
+	return v, err
+}
+
+// Only the scene cids found are cached, the index does not tell the missing ones from the empty ones
+func (c *SceneCache) GetSceneCids(rootCIDs []string) (map[string]string, error) {
+	ret := make(map[string]string, len(rootCIDs))
+	var missing []string
+	for _, cid := range rootCIDs {
+		if e, ok := c.lookup(rootScenePrefix + cid); !ok {
+			missing = append(missing, cid)
+		} else if e.found && e.value.(string) != "" {
+			ret[cid] = e.value.(string)
+		}
+	}
+	if len(missing) == 0 {
+		return ret, nil
+	}
+	gen := c.currentGeneration()
+	cids, err := c.RedisClient.GetSceneCids(missing)
+	if err != nil {
+		return nil, err
+	}
+	for cid, sceneCid := range cids {
+		c.fill(gen, rootScenePrefix+cid, sceneCid, true)
+		ret[cid] = sceneCid
+	}
+	return ret, nil
+}
+
+// The old scenes of the parcels lose their parcels too, they are read before the commit
+func (c *SceneCache) CommitScene(s *SceneIndex) error {
+	old, err := c.RedisClient.GetParcelCIDs(s.Parcels)
+	if err != nil {
+		return err
+	}
+	err = c.RedisClient.CommitScene(s)
+	keys := []string{sceneParcelsPrefix + s.RootCid, rootScenePrefix + s.RootCid}
+	if s.SceneCid != "" {
+		keys = append(keys, rootScenePrefix+s.SceneCid)
+	}
+	for _, p := range s.Parcels {
+		keys = append(keys, parcelPrefix+p)
+	}
+	for _, cid := range old {
+		keys = append(keys, sceneParcelsPrefix+cid)
+	}
+	// A failed commit may still have been applied, e.g. on a timeout
+	c.changed(keys)
+	return err
+}
+
+func (c *SceneCache) SetSceneParcels(cid string, pids []string) error {
+	old, err := c.RedisClient.GetParcelCIDs(pids)
+	if err != nil {
+		return err
+	}
+	err = c.RedisClient.SetSceneParcels(cid, pids)
+	keys := []string{sceneParcelsPrefix + cid}
+	for _, p := range pids {
+		keys = append(keys, parcelPrefix+p)
+	}
+	for _, oldCid := range old {
+		keys = append(keys, sceneParcelsPrefix+oldCid)
+	}
+	c.changed(keys)
+	return err
+}
+
+func (c *SceneCache) ClearScene(cid string) error {
+	err := c.RedisClient.ClearScene(cid)
+	c.changed([]string{sceneParcelsPrefix + cid})
+	return err
+}
+
+func (c *SceneCache) SaveRootCidSceneCid(rootCID, sceneCID string) error {
+	err := c.RedisClient.SaveRootCidSceneCid(rootCID, sceneCID)
+	c.changed([]string{rootScenePrefix + rootCID, rootScenePrefix + sceneCID})
+	return err
+}
+
+func (c *SceneCache) changed(keys []string) {
+	c.invalidate(keys)
+	if c.Invalidations == nil {
+		return
+	}
+	if err := c.Invalidations.PublishInvalidation(keys); err != nil {
+		// The other replicas keep the old entries until they expire
+		logrus.Errorf("Failed to publish the invalidation of %d index keys: %s", len(keys), err)
+	}
+}
+
+func (c *SceneCache) invalidate(keys []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, k := range keys {
+		if el, ok := c.entries[k]; ok {
+			c.lru.Remove(el)
+			delete(c.entries, k)
+		}
+	}
+}
+
+func (c *SceneCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+func (c *SceneCache) lookup(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.Agent.RecordIndexCacheMiss()
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if time.Now().After(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		c.Agent.RecordIndexCacheMiss()
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	c.Agent.RecordIndexCacheHit()
+	return e, true
+}
+
+// Values read before an invalidation may be outdated, they are not cached
+func (c *SceneCache) fill(generation uint64, key string, value interface{}, found bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation || c.maxEntries <= 0 {
+		return
+	}
+	e := &cacheEntry{key: key, value: value, found: found, expires: time.Now().Add(c.ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
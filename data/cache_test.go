@@ -0,0 +1,156 @@
+package data_test
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/decentraland/content-service/config"
+	"github.com/decentraland/content-service/data"
+	"github.com/decentraland/content-service/metrics"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// Counts the lookups reaching the index
+type countingIndex struct {
+	data.RedisClient
+	reads int
+}
+
+func (c *countingIndex) GetParcelCIDs(pids []string) (map[string]string, error) {
+	c.reads++
+	return c.RedisClient.GetParcelCIDs(pids)
+}
+
+func (c *countingIndex) GetScenesParcels(cids []string) (map[string][]string, error) {
+	c.reads++
+	return c.RedisClient.GetScenesParcels(cids)
+}
+
+func (c *countingIndex) GetSceneCid(rootCID string) (string, error) {
+	c.reads++
+	return c.RedisClient.GetSceneCid(rootCID)
+}
+
+// Invalidations delivered synchronously to every subscriber
+type localBus struct {
+	mu          sync.Mutex
+	subscribers []func(keys []string)
+}
+
+func (b *localBus) PublishInvalidation(keys []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, f := range b.subscribers {
+		f(keys)
+	}
+	return nil
+}
+
+func (b *localBus) SubscribeInvalidations(f func(keys []string)) (func() error, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, f)
+	return func() error { return nil }, nil
+}
+
+func newSceneCache(t *testing.T, index data.RedisClient, ttl time.Duration, maxEntries int, bus data.Invalidations) *data.SceneCache {
+	agent, _ := metrics.Make(config.Metrics{AppName: "", Enabled: false, AnalyticsKey: ""})
+	cache, err := data.NewSceneCache(index, ttl, maxEntries, bus, agent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+func TestSceneCacheReadThrough(t *testing.T) {
+	db, dir := newLevelDB(t)
+	defer os.RemoveAll(dir)
+	defer db.Close()
+	assert.Nil(t, db.SetSceneParcels("QmA", []string{"0,0", "0,1"}))
+
+	index := &countingIndex{RedisClient: db}
+	cache := newSceneCache(t, index, time.Minute, 100, nil)
+
+	for i := 0; i < 3; i++ {
+		cids, err := cache.GetParcelCIDs([]string{"0,0", "5,5"})
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"0,0": "QmA"}, cids)
+		parcels, err := cache.GetScenesParcels([]string{"QmA"})
+		assert.Nil(t, err)
+		assert.Equal(t, map[string][]string{"QmA": {"0,1", "0,0"}}, parcels)
+	}
+	// Missing parcels are cached too
+	assert.Equal(t, 2, index.reads)
+
+	cid, err := cache.GetParcelCID("5,5")
+	assert.Nil(t, err)
+	assert.Equal(t, "", cid)
+	_, err = cache.GetSceneCid("QmA")
+	assert.Equal(t, redis.Nil, err)
+	_, err = cache.GetSceneCid("QmA")
+	assert.Equal(t, redis.Nil, err)
+	assert.Equal(t, 3, index.reads)
+}
+
+func TestSceneCacheBounds(t *testing.T) {
+	db, dir := newLevelDB(t)
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	index := &countingIndex{RedisClient: db}
+	cache := newSceneCache(t, index, 50*time.Millisecond, 2, nil)
+
+	_, _ = cache.GetParcelCIDs([]string{"0,0", "0,1", "0,2"})
+	// Only the last two parcels fit
+	_, _ = cache.GetParcelCIDs([]string{"0,1", "0,2"})
+	assert.Equal(t, 1, index.reads)
+	_, _ = cache.GetParcelCIDs([]string{"0,0"})
+	assert.Equal(t, 2, index.reads)
+
+	time.Sleep(60 * time.Millisecond)
+	_, _ = cache.GetParcelCIDs([]string{"0,0"})
+	assert.Equal(t, 3, index.reads)
+}
+
+func TestSceneCacheInvalidation(t *testing.T) {
+	db, dir := newLevelDB(t)
+	defer os.RemoveAll(dir)
+	defer db.Close()
+	assert.Nil(t, db.SetSceneParcels("QmA", []string{"0,0", "0,1"}))
+
+	bus := &localBus{}
+	writer := newSceneCache(t, db, time.Hour, 100, bus)
+	reader := newSceneCache(t, db, time.Hour, 100, bus)
+
+	for _, c := range []*data.SceneCache{writer, reader} {
+		cid, err := c.GetParcelCID("0,1")
+		assert.Nil(t, err)
+		assert.Equal(t, "QmA", cid)
+		parcels, err := c.GetSceneParcels("QmA")
+		assert.Nil(t, err)
+		assert.Len(t, parcels, 2)
+	}
+
+	err := writer.CommitScene(&data.SceneIndex{
+		RootCid:  "QmB",
+		SceneCid: "QmBScene",
+		Parcels:  []string{"0,1"},
+		Metadata: map[string]interface{}{"root_cid": "QmB"},
+	})
+	assert.Nil(t, err)
+
+	for _, c := range []*data.SceneCache{writer, reader} {
+		cid, err := c.GetParcelCID("0,1")
+		assert.Nil(t, err)
+		assert.Equal(t, "QmB", cid)
+		parcels, err := c.GetSceneParcels("QmA")
+		assert.Nil(t, err)
+		assert.Empty(t, parcels)
+		scene, err := c.GetSceneCid("QmB")
+		assert.Nil(t, err)
+		assert.Equal(t, "QmBScene", scene)
+	}
+}
@@ -0,0 +1,183 @@
+package data
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+)
+
+// A scene deployed, or rolled back, on a set of parcels
+type DeploymentEvent struct {
+	ID        int64    `json:"id"`
+	RootCid   string   `json:"root_cid"`
+	SceneCid  string   `json:"scene_cid"`
+	Parcels   []string `json:"parcels"`
+	Publisher string   `json:"publisher"`
+	Timestamp int64    `json:"timestamp"`
+	Origin    string   `json:"origin"`
+	Kind      string   `json:"kind"`
+}
+
+// Stream of deployment events shared by every replica of the service
+// The last events are kept, so subscribers can resume from the last one they received
+type DeploymentEvents interface {
+	// Assigns the event its id and sends it to every subscriber
+	Publish(e *DeploymentEvent) error
+	// Retrieves the kept events with an id greater than the given one, oldest first
+	Since(id int64) ([]*DeploymentEvent, error)
+	// Calls f with every event published from now on, until the returned function is called
+	// f must not block
+	Subscribe(f func(e *DeploymentEvent)) (func() error, error)
+}
+
+const (
+	eventsSequenceKey = "events:sequence"
+	eventsLogKey      = "events:log"
+	eventsChannel     = "events:deployments"
+)
+
+// Events kept in Redis and broadcast through pub/sub
+type RedisEvents struct {
+	Client *redis.Client
+	// Events kept to resume from
+	History int64
+}
+
+// The id is assigned, the event kept and published in a single step, so every replica sees the events in id order
+var publishEvent = redis.NewScript(`
+local id = redis.call('INCR', KEYS[1])
+local event = cjson.decode(ARGV[1])
+event['id'] = id
+local msg = cjson.encode(event)
+redis.call('RPUSH', KEYS[2], msg)
+redis.call('LTRIM', KEYS[2], -tonumber(ARGV[2]), -1)
+redis.call('PUBLISH', ARGV[3], msg)
+return id
+`)
+
+func NewRedisEvents(address string, password string, db int, history int64) *RedisEvents {
+	client := redis.NewClient(&redis.Options{
+		Addr:     address,
+		Password: password,
+		DB:       db,
+	})
+	return &RedisEvents{Client: client, History: history}
+}
+
+func (r *RedisEvents) Publish(e *DeploymentEvent) error {
+	msg, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	id, err := publishEvent.Run(r.Client, []string{eventsSequenceKey, eventsLogKey}, msg, r.History, eventsChannel).Int64()
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+func (r *RedisEvents) Since(id int64) ([]*DeploymentEvent, error) {
+	entries, err := r.Client.LRange(eventsLogKey, 0, -1).Result()
+	if err != nil {
+		logrus.Errorf("Redis error: %s", err.Error())
+		return nil, err
+	}
+	var events []*DeploymentEvent
+	for _, entry := range entries {
+		e, err := decodeEvent(entry)
+		if err != nil {
+			return nil, err
+		}
+		if e.ID > id {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// Events published while the connection is down are lost, subscribers resume from the kept ones
+func (r *RedisEvents) Subscribe(f func(e *DeploymentEvent)) (func() error, error) {
+	sub := r.Client.Subscribe(eventsChannel)
+	if _, err := sub.Receive(); err != nil {
+		sub.Close()
+		return nil, err
+	}
+	go func() {
+		for msg := range sub.Channel() {
+			e, err := decodeEvent(msg.Payload)
+			if err != nil {
+				logrus.Errorf("Invalid deployment event: %s", err)
+				continue
+			}
+			f(e)
+		}
+	}()
+	return sub.Close, nil
+}
+
+func decodeEvent(msg string) (*DeploymentEvent, error) {
+	var e DeploymentEvent
+	if err := json.Unmarshal([]byte(msg), &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Events kept in memory, for a service that does not share its index with other replicas
+type LocalEvents struct {
+	history int
+
+	mu          sync.Mutex
+	sequence    int64
+	events      []*DeploymentEvent
+	subscribers map[int]func(e *DeploymentEvent)
+	nextSub     int
+}
+
+func NewLocalEvents(history int) *LocalEvents {
+	return &LocalEvents{history: history, subscribers: make(map[int]func(e *DeploymentEvent))}
+}
+
+func (l *LocalEvents) Publish(e *DeploymentEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sequence++
+	e.ID = l.sequence
+	l.events = append(l.events, e)
+	if len(l.events) > l.history {
+		l.events = l.events[len(l.events)-l.history:]
+	}
+	for _, f := range l.subscribers {
+		f(e)
+	}
+	return nil
+}
+
+func (l *LocalEvents) Since(id int64) ([]*DeploymentEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var events []*DeploymentEvent
+	for _, e := range l.events {
+		if e.ID > id {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (l *LocalEvents) Subscribe(f func(e *DeploymentEvent)) (func() error, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	id := l.nextSub
+	l.nextSub++
+	l.subscribers[id] = f
+	return func() error {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.subscribers, id)
+		return nil
+	}, nil
+}
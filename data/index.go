@@ -0,0 +1,51 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/decentraland/content-service/config"
+	"github.com/decentraland/content-service/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// Builds the index configured in conf.Index, Redis when no type is set
+func NewIndexClient(conf *config.Configuration, agent *metrics.Agent) (RedisClient, error) {
+	var client RedisClient
+	// Replicas sharing a Redis index invalidate each other caches, a LevelDB one is never shared
+	var inv Invalidations
+	switch config.IndexType(strings.ToUpper(conf.Index.Type)) {
+	case "", config.REDIS:
+		log.Info("Index: Redis")
+		r, err := NewRedisClient(conf.Redis.Address, conf.Redis.Password, conf.Redis.DB, agent)
+		if err != nil {
+			return nil, err
+		}
+		client, inv = r, r
+	case config.LEVELDB:
+		log.Infof("Index: LevelDB at %s", conf.Index.Path)
+		l, err := NewLevelDBClient(conf.Index.Path, agent)
+		if err != nil {
+			return nil, err
+		}
+		client = l
+	default:
+		return nil, fmt.Errorf("invalid index type: %s. Available index types: %s, %s", conf.Index.Type, config.REDIS, config.LEVELDB)
+	}
+
+	cache := conf.Index.Cache
+	if !cache.Enabled {
+		return client, nil
+	}
+	log.Infof("Index cache: %d entries for %d seconds", cache.MaxEntries, cache.TTL)
+	return NewSceneCache(client, time.Duration(cache.TTL)*time.Second, cache.MaxEntries, inv, agent)
+}
+
+// Builds the stream of deployment events, shared through Redis unless the index is a LevelDB one
+func NewDeploymentEvents(conf *config.Configuration) DeploymentEvents {
+	if config.IndexType(strings.ToUpper(conf.Index.Type)) == config.LEVELDB {
+		return NewLocalEvents(conf.Events.History)
+	}
+	return NewRedisEvents(conf.Redis.Address, conf.Redis.Password, conf.Redis.DB, int64(conf.Events.History))
+}
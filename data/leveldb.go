@@ -0,0 +1,821 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/decentraland/content-service/metrics"
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Index stored in an embedded LevelDB database, for deployments without a Redis server
+// It keeps the key layout of Redis:
+// - strings are stored under their key
+// - every field of a hash, and every member of a set, is stored under <key>\x00<field>
+// - lists are stored under their key as a JSON array
+// Missing values are reported as redis.Nil where the Redis client does, so both are interchangeable
+type LevelDB struct {
+	DB    *leveldb.DB
+	Agent *metrics.Agent
+	// Serializes the updates that read before writing, as Redis does with transactions
+	mu sync.Mutex
+}
+
+const fieldSeparator = "\x00"
+
+// Every write is flushed to disk before returning
+var syncWrite = &opt.WriteOptions{Sync: true}
+
+func NewLevelDBClient(path string, agent *metrics.Agent) (*LevelDB, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	l := &LevelDB{DB: db, Agent: agent}
+	if err := l.checkSchema(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := l.purgeDeployments(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *LevelDB) Close() error {
+	return l.DB.Close()
+}
+
+func (l *LevelDB) checkSchema() error {
+	v, ok, err := l.get(schemaVersionKey)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return l.DB.Put([]byte(schemaVersionKey), []byte(strconv.Itoa(SchemaVersion)), syncWrite)
+	}
+	if v != strconv.Itoa(SchemaVersion) {
+		return fmt.Errorf("index schema version %s is not supported, expected %d", v, SchemaVersion)
+	}
+	return nil
+}
+
+func (l *LevelDB) GetParcelMetadata(parcelID string) (map[string]interface{}, error) {
+	t := time.Now()
+	cid, ok, err := l.get(parcelPrefix + parcelID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		logrus.Debugf("Parcel[%s] Metadata not found", parcelID)
+		return nil, nil
+	}
+	fields, err := l.hgetall(metadataKeyPrefix + cid)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := parseMetadata(fields)
+	if err != nil {
+		return nil, err
+	}
+	l.Agent.RecordGetParcelMetadata(time.Since(t))
+	return metadata, nil
+}
+
+func (l *LevelDB) GetParcelContent(parcelID string) (map[string]string, error) {
+	t := time.Now()
+	defer func() { l.Agent.RecordGetParcelContent(time.Since(t)) }()
+	cid, ok, err := l.get(parcelPrefix + parcelID)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return l.hgetall(contentKeyPrefix + cid)
+}
+
+func (l *LevelDB) StoreContent(key string, field string, value string) error {
+	t := time.Now()
+	b := new(leveldb.Batch)
+	hset(b, contentKeyPrefix+key, field, value)
+	err := l.DB.Write(b, syncWrite)
+	l.Agent.RecordStoreContent(time.Since(t))
+	return err
+}
+
+func (l *LevelDB) StoreMetadata(key string, fields map[string]interface{}) error {
+	t := time.Now()
+	b := new(leveldb.Batch)
+	for f, v := range fields {
+		hset(b, metadataKeyPrefix+key, f, formatValue(v))
+	}
+	err := l.DB.Write(b, syncWrite)
+	l.Agent.RecordStoreMetadata(time.Since(t))
+	return err
+}
+
+func (l *LevelDB) AddCID(cid string) error {
+	b := new(leveldb.Batch)
+	hset(b, uploadedElementsKey, cid, "")
+	return l.DB.Write(b, syncWrite)
+}
+
+func (l *LevelDB) IsContentMember(value string) (bool, error) {
+	t := time.Now()
+	member, err := l.isMember(uploadedElementsKey, value)
+	l.Agent.RecordIsMemberTime(time.Since(t))
+	return member, err
+}
+
+func (l *LevelDB) GetParcelCID(pid string) (string, error) {
+	cid, _, err := l.get(parcelPrefix + pid)
+	return cid, err
+}
+
+func (l *LevelDB) GetParcelCIDs(pids []string) (map[string]string, error) {
+	return l.mget(parcelPrefix, pids)
+}
+
+func (l *LevelDB) ProcessedParcels(pids []string) (map[string]bool, error) {
+	ret := make(map[string]bool, len(pids))
+	for _, pid := range pids {
+		member, err := l.isMember(proccessedSet, pid)
+		if err != nil {
+			return nil, err
+		}
+		ret[pid] = member
+	}
+	return ret, nil
+}
+
+func (l *LevelDB) ClearScene(cid string) error {
+	return l.DB.Delete([]byte(sceneParcelsPrefix+cid), syncWrite)
+}
+
+// Same semantics as the Redis index: the scenes of the given parcels lose their parcel list and each parcel
+// points to the new scene
+func (l *LevelDB) SetSceneParcels(scene string, parcels []string) error {
+	if len(parcels) == 0 {
+		return fmt.Errorf("Trying to push empty parcels list for scene %s", scene)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := new(leveldb.Batch)
+	cleared := false
+	for _, p := range parcels {
+		cid, _, err := l.get(parcelPrefix + p)
+		if err != nil {
+			return err
+		}
+		b.Delete([]byte(sceneParcelsPrefix + cid))
+		cleared = cleared || cid == scene
+	}
+	// The new parcels are pushed in front of the ones the scene already had, as LPUSH does
+	var current []string
+	if !cleared {
+		var err error
+		if current, err = l.getList(sceneParcelsPrefix + scene); err != nil {
+			return err
+		}
+	}
+	if err := setList(b, sceneParcelsPrefix+scene, pushFront(current, parcels)); err != nil {
+		return err
+	}
+	for _, p := range parcels {
+		b.Put([]byte(parcelPrefix+p), []byte(scene))
+	}
+	return l.DB.Write(b, syncWrite)
+}
+
+func (l *LevelDB) GetSceneParcels(cid string) ([]string, error) {
+	return l.getList(sceneParcelsPrefix + cid)
+}
+
+func (l *LevelDB) GetScenesParcels(cids []string) (map[string][]string, error) {
+	ret := make(map[string][]string, len(cids))
+	for _, cid := range cids {
+		parcels, err := l.getList(sceneParcelsPrefix + cid)
+		if err != nil {
+			return nil, err
+		}
+		if len(parcels) > 0 {
+			ret[cid] = parcels
+		}
+	}
+	return ret, nil
+}
+
+func (l *LevelDB) SetProcessedParcel(pid string) error {
+	b := new(leveldb.Batch)
+	hset(b, proccessedSet, pid, "")
+	return l.DB.Write(b, syncWrite)
+}
+
+func (l *LevelDB) ProcessedParcel(pid string) (bool, error) {
+	return l.isMember(proccessedSet, pid)
+}
+
+// Both directions are written at once
+func (l *LevelDB) SaveRootCidSceneCid(rootCID, sceneCID string) error {
+	b := new(leveldb.Batch)
+	b.Put([]byte(rootScenePrefix+rootCID), []byte(sceneCID))
+	b.Put([]byte(rootScenePrefix+sceneCID), []byte(rootCID))
+	return l.DB.Write(b, syncWrite)
+}
+
+func (l *LevelDB) GetSceneCid(rootCID string) (string, error) {
+	return l.getOrNil(rootScenePrefix + rootCID)
+}
+
+func (l *LevelDB) GetSceneCids(rootCIDs []string) (map[string]string, error) {
+	return l.mget(rootScenePrefix, rootCIDs)
+}
+
+func (l *LevelDB) GetRootCid(sceneCID string) (string, error) {
+	return l.getOrNil(rootScenePrefix + sceneCID)
+}
+
+// Applies all the index updates of a new scene in a single batch, written after reading the old scenes of the
+// parcels while holding the lock
+func (l *LevelDB) CommitScene(s *SceneIndex) error {
+	if len(s.Parcels) == 0 {
+		return fmt.Errorf("Trying to push empty parcels list for scene %s", s.RootCid)
+	}
+	t := time.Now()
+	defer func() { l.Agent.RecordCommitScene(time.Since(t)) }()
+
+	record, err := json.Marshal(&DeploymentRecord{
+		RootCid:   s.RootCid,
+		SceneCid:  s.SceneCid,
+		Parcels:   s.Parcels,
+		Publisher: s.Publisher,
+		Signature: s.Signature,
+		Origin:    s.Origin,
+		Kind:      s.Kind,
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	oldScenes := make(map[string]bool, len(s.Parcels))
+	for _, p := range s.Parcels {
+		cid, _, err := l.get(parcelPrefix + p)
+		if err != nil {
+			return err
+		}
+		if cid != "" {
+			oldScenes[cid] = true
+		}
+	}
+
+	b := new(leveldb.Batch)
+	for cid := range oldScenes {
+		b.Delete([]byte(sceneParcelsPrefix + cid))
+		if cid == s.RootCid {
+			continue
+		}
+		// The replaced scenes no longer reference their files
+		files, err := l.hgetall(contentKeyPrefix + cid)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			b.Delete(fieldKey(contentReferencesPrefix+f, cid))
+		}
+	}
+	if err := setList(b, sceneParcelsPrefix+s.RootCid, pushFront(nil, s.Parcels)); err != nil {
+		return err
+	}
+	for _, p := range s.Parcels {
+		b.Put([]byte(parcelPrefix+p), []byte(s.RootCid))
+		hset(b, proccessedSet, p, "")
+	}
+	for path, cid := range s.Content {
+		hset(b, contentKeyPrefix+s.RootCid, path, cid)
+		hset(b, uploadedElementsKey, cid, "")
+		hset(b, contentReferencesPrefix+cid, s.RootCid, "")
+	}
+	for f, v := range s.Metadata {
+		hset(b, metadataKeyPrefix+s.RootCid, f, formatValue(v))
+	}
+	b.Put([]byte(rootScenePrefix+s.RootCid), []byte(s.SceneCid))
+	if s.SceneCid != "" {
+		b.Put([]byte(rootScenePrefix+s.SceneCid), []byte(s.RootCid))
+	}
+
+	history := make([]string, 0, len(s.Parcels)+1)
+	for _, p := range s.Parcels {
+		history = append(history, parcelHistoryPrefix+p)
+	}
+	history = append(history, sceneHistoryPrefix+s.RootCid)
+	for _, key := range history {
+		entries, err := l.getList(key)
+		if err != nil {
+			return err
+		}
+		if err := setList(b, key, append(entries, string(record))); err != nil {
+			return err
+		}
+	}
+
+	if s.Usage != nil {
+		usageKeys := []string{publisherUsagePrefix + strings.ToLower(s.Publisher)}
+		for _, p := range s.Parcels {
+			usageKeys = append(usageKeys, parcelUsagePrefix+p)
+		}
+		for _, key := range usageKeys {
+			if err := l.incrUsage(b, key, s.Usage); err != nil {
+				return err
+			}
+		}
+	}
+	return l.DB.Write(b, syncWrite)
+}
+
+func (l *LevelDB) GetSceneContent(rootCID string) (map[string]string, error) {
+	t := time.Now()
+	res, err := l.hgetall(contentKeyPrefix + rootCID)
+	l.Agent.RecordGetParcelContent(time.Since(t))
+	return res, err
+}
+
+func (l *LevelDB) GetScenesIndex(rootCIDs []string) (map[string]*StoredScene, error) {
+	ret := make(map[string]*StoredScene, len(rootCIDs))
+	for _, cid := range rootCIDs {
+		fields, err := l.hgetall(metadataKeyPrefix + cid)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		metadata, err := parseMetadata(fields)
+		if err != nil {
+			return nil, err
+		}
+		content, err := l.hgetall(contentKeyPrefix + cid)
+		if err != nil {
+			return nil, err
+		}
+		ret[cid] = &StoredScene{Content: content, Metadata: metadata}
+	}
+	return ret, nil
+}
+
+func (l *LevelDB) GetParcelHistory(pid string) ([]*DeploymentRecord, error) {
+	return l.getHistory(parcelHistoryPrefix + pid)
+}
+
+func (l *LevelDB) GetSceneHistory(rootCID string) ([]*DeploymentRecord, error) {
+	return l.getHistory(sceneHistoryPrefix + rootCID)
+}
+
+func (l *LevelDB) getHistory(key string) ([]*DeploymentRecord, error) {
+	entries, err := l.getList(key)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]*DeploymentRecord, 0, len(entries))
+	for _, e := range entries {
+		var record DeploymentRecord
+		if err := json.Unmarshal([]byte(e), &record); err != nil {
+			return nil, err
+		}
+		history = append(history, &record)
+	}
+	return history, nil
+}
+
+func (l *LevelDB) GetCumulativeSizes(cids []string) (map[string]uint64, error) {
+	sizes := make(map[string]uint64, len(cids))
+	for _, cid := range cids {
+		v, ok, err := l.get(string(fieldKey(cumulativeSizesKey, cid)))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		size, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		sizes[cid] = size
+	}
+	return sizes, nil
+}
+
+func (l *LevelDB) SetCumulativeSizes(sizes map[string]uint64) error {
+	if len(sizes) == 0 {
+		return nil
+	}
+	b := new(leveldb.Batch)
+	for cid, size := range sizes {
+		hset(b, cumulativeSizesKey, cid, strconv.FormatUint(size, 10))
+	}
+	return l.DB.Write(b, syncWrite)
+}
+
+func (l *LevelDB) GetVariants(cid string) ([]string, error) {
+	v, ok, err := l.get(string(fieldKey(variantsKey, cid)))
+	if err != nil || !ok {
+		return nil, err
+	}
+	return strings.Split(v, ","), nil
+}
+
+func (l *LevelDB) SetVariants(cid string, encodings []string) error {
+	if len(encodings) == 0 {
+		return l.DB.Delete(fieldKey(variantsKey, cid), syncWrite)
+	}
+	return l.DB.Put(fieldKey(variantsKey, cid), []byte(strings.Join(encodings, ",")), syncWrite)
+}
+
+func (l *LevelDB) GetContentReferences(cid string) ([]string, error) {
+	return l.members(contentReferencesPrefix + cid)
+}
+
+func (l *LevelDB) GetLiveRootCids() ([]string, error) {
+	parcels, err := l.members(proccessedSet)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var roots []string
+	for _, p := range parcels {
+		cid, ok, err := l.get(parcelPrefix + p)
+		if err != nil {
+			return nil, err
+		}
+		if ok && !seen[cid] {
+			seen[cid] = true
+			roots = append(roots, cid)
+		}
+	}
+	return roots, nil
+}
+
+func (l *LevelDB) GetUploadedContent() ([]string, error) {
+	return l.members(uploadedElementsKey)
+}
+
+func (l *LevelDB) GetOrphans() (map[string]int64, error) {
+	res, err := l.hgetall(orphansKey)
+	if err != nil {
+		return nil, err
+	}
+	orphans := make(map[string]int64, len(res))
+	for cid, v := range res {
+		ts, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		orphans[cid] = ts
+	}
+	return orphans, nil
+}
+
+func (l *LevelDB) SetOrphans(orphans map[string]int64) error {
+	if len(orphans) == 0 {
+		return nil
+	}
+	b := new(leveldb.Batch)
+	for cid, ts := range orphans {
+		hset(b, orphansKey, cid, strconv.FormatInt(ts, 10))
+	}
+	return l.DB.Write(b, syncWrite)
+}
+
+func (l *LevelDB) ClearOrphans(cids []string) error {
+	if len(cids) == 0 {
+		return nil
+	}
+	b := new(leveldb.Batch)
+	for _, cid := range cids {
+		b.Delete(fieldKey(orphansKey, cid))
+	}
+	return l.DB.Write(b, syncWrite)
+}
+
+func (l *LevelDB) RemoveContent(cids []string) error {
+	if len(cids) == 0 {
+		return nil
+	}
+	b := new(leveldb.Batch)
+	for _, cid := range cids {
+		b.Delete(fieldKey(uploadedElementsKey, cid))
+		b.Delete(fieldKey(orphansKey, cid))
+		b.Delete(fieldKey(variantsKey, cid))
+	}
+	return l.DB.Write(b, syncWrite)
+}
+
+func (l *LevelDB) ClaimContent(cid string) (ContentState, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.DB.Delete(fieldKey(orphansKey, cid), syncWrite); err != nil {
+		return ContentMissing, err
+	}
+	if ok, err := l.isMember(uploadedElementsKey, cid); err != nil || ok {
+		return ContentUploaded, err
+	}
+	if ok, err := l.isMember(deletingKey, cid); err != nil || ok {
+		return ContentDeleting, err
+	}
+	return ContentMissing, nil
+}
+
+func (l *LevelDB) TakeOrphan(cid string, firstSeen int64) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ts, ok, err := l.get(string(fieldKey(orphansKey, cid)))
+	if err != nil || !ok || ts != strconv.FormatInt(firstSeen, 10) {
+		return false, err
+	}
+	refs, err := l.members(contentReferencesPrefix + cid)
+	if err != nil || len(refs) > 0 {
+		return false, err
+	}
+	uploaded, err := l.isMember(uploadedElementsKey, cid)
+	if err != nil || !uploaded {
+		return false, err
+	}
+	b := new(leveldb.Batch)
+	b.Delete(fieldKey(uploadedElementsKey, cid))
+	b.Delete(fieldKey(orphansKey, cid))
+	hset(b, deletingKey, cid, "")
+	return true, l.DB.Write(b, syncWrite)
+}
+
+func (l *LevelDB) FinishDeletion(cid string, deleted bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := new(leveldb.Batch)
+	b.Delete(fieldKey(deletingKey, cid))
+	if deleted {
+		b.Delete(fieldKey(variantsKey, cid))
+	} else {
+		hset(b, uploadedElementsKey, cid, "")
+	}
+	return l.DB.Write(b, syncWrite)
+}
+
+func (l *LevelDB) GetPublisherUsage(address string) (*Usage, error) {
+	return l.getUsage(publisherUsagePrefix + strings.ToLower(address))
+}
+
+func (l *LevelDB) GetParcelUsage(pid string) (*Usage, error) {
+	return l.getUsage(parcelUsagePrefix + pid)
+}
+
+// Missing counters are retrieved as 0
+func (l *LevelDB) getUsage(key string) (*Usage, error) {
+	fields, err := l.hgetall(key)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]int64, len(usageFields))
+	for i, f := range usageFields {
+		v, ok := fields[f]
+		if !ok {
+			continue
+		}
+		values[i], err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s usage in %s: %v", f, key, v)
+		}
+	}
+	return &Usage{
+		Deployments: values[0],
+		Files:       values[1],
+		Bytes:       values[2],
+		SharedFiles: values[3],
+		SharedBytes: values[4],
+	}, nil
+}
+
+// Must be called holding the lock
+func (l *LevelDB) incrUsage(b *leveldb.Batch, key string, u *Usage) error {
+	current, err := l.getUsage(key)
+	if err != nil {
+		return err
+	}
+	totals := current.values()
+	for i, v := range u.values() {
+		hset(b, key, usageFields[i], strconv.FormatInt(totals[i]+v, 10))
+	}
+	return nil
+}
+
+// The state of a deployment with the time it expires
+type storedDeployment struct {
+	Expires int64             `json:"expires"`
+	Fields  map[string]string `json:"fields"`
+}
+
+func (l *LevelDB) StoreDeployment(id string, fields map[string]interface{}) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	d, err := l.getDeployment(id)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		d = &storedDeployment{Fields: make(map[string]string, len(fields))}
+	}
+	for f, v := range fields {
+		d.Fields[f] = formatValue(v)
+	}
+	d.Expires = time.Now().Add(deploymentRetention).Unix()
+	value, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return l.DB.Put([]byte(deploymentPrefix+id), value, syncWrite)
+}
+
+func (l *LevelDB) GetDeployment(id string) (map[string]string, error) {
+	d, err := l.getDeployment(id)
+	if err != nil || d == nil {
+		return nil, err
+	}
+	return d.Fields, nil
+}
+
+// Expired deployments are retrieved as nil
+func (l *LevelDB) getDeployment(id string) (*storedDeployment, error) {
+	v, ok, err := l.get(deploymentPrefix + id)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var d storedDeployment
+	if err := json.Unmarshal([]byte(v), &d); err != nil {
+		return nil, err
+	}
+	if d.Expires < time.Now().Unix() {
+		return nil, nil
+	}
+	return &d, nil
+}
+
+// LevelDB has no expiration, the expired deployments are removed on startup
+func (l *LevelDB) purgeDeployments() error {
+	b := new(leveldb.Batch)
+	iter := l.DB.NewIterator(util.BytesPrefix([]byte(deploymentPrefix)), nil)
+	for iter.Next() {
+		var d storedDeployment
+		if err := json.Unmarshal(iter.Value(), &d); err != nil || d.Expires < time.Now().Unix() {
+			b.Delete(append([]byte(nil), iter.Key()...))
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	logrus.Debugf("Removing %d expired deployments from the index", b.Len())
+	return l.DB.Write(b, syncWrite)
+}
+
+func (l *LevelDB) get(key string) (string, bool, error) {
+	v, err := l.DB.Get([]byte(key), nil)
+	if err == leveldb.ErrNotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		logrus.Errorf("LevelDB error: %s", err.Error())
+		return "", false, err
+	}
+	return string(v), true, nil
+}
+
+// Missing keys are reported as redis.Nil, as GET does
+func (l *LevelDB) getOrNil(key string) (string, error) {
+	v, ok, err := l.get(key)
+	if err == nil && !ok {
+		return "", redis.Nil
+	}
+	return v, err
+}
+
+// Retrieves the value of each prefix+key, the missing ones are left out
+func (l *LevelDB) mget(prefix string, keys []string) (map[string]string, error) {
+	ret := make(map[string]string, len(keys))
+	for _, k := range keys {
+		v, _, err := l.get(prefix + k)
+		if err != nil {
+			return nil, err
+		}
+		if v != "" {
+			ret[k] = v
+		}
+	}
+	return ret, nil
+}
+
+func (l *LevelDB) hgetall(key string) (map[string]string, error) {
+	ret := make(map[string]string)
+	prefix := key + fieldSeparator
+	iter := l.DB.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	for iter.Next() {
+		ret[strings.TrimPrefix(string(iter.Key()), prefix)] = string(iter.Value())
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		logrus.Errorf("LevelDB error: %s", err.Error())
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (l *LevelDB) members(key string) ([]string, error) {
+	fields, err := l.hgetall(key)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]string, 0, len(fields))
+	for m := range fields {
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+func (l *LevelDB) isMember(key string, member string) (bool, error) {
+	return l.DB.Has(fieldKey(key, member), nil)
+}
+
+func (l *LevelDB) getList(key string) ([]string, error) {
+	v, ok, err := l.get(key)
+	if err != nil || !ok {
+		return []string{}, err
+	}
+	var list []string
+	if err := json.Unmarshal([]byte(v), &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func setList(b *leveldb.Batch, key string, list []string) error {
+	v, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	b.Put([]byte(key), v)
+	return nil
+}
+
+// Same order LPUSH leaves: the last value first
+func pushFront(list []string, values []string) []string {
+	ret := make([]string, 0, len(list)+len(values))
+	for i := len(values) - 1; i >= 0; i-- {
+		ret = append(ret, values[i])
+	}
+	return append(ret, list...)
+}
+
+func fieldKey(key string, field string) []byte {
+	return []byte(key + fieldSeparator + field)
+}
+
+func hset(b *leveldb.Batch, key string, field string, value string) {
+	b.Put(fieldKey(key, field), []byte(value))
+}
+
+// Formats the value as Redis stores it
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
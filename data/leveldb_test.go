@@ -0,0 +1,186 @@
+package data_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/decentraland/content-service/config"
+	"github.com/decentraland/content-service/data"
+	"github.com/decentraland/content-service/metrics"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func newLevelDB(t *testing.T) (*data.LevelDB, string) {
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent, _ := metrics.Make(config.Metrics{AppName: "", Enabled: false, AnalyticsKey: ""})
+	client, err := data.NewLevelDBClient(dir, agent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, dir
+}
+
+func TestLevelDBSceneParcels(t *testing.T) {
+	client, dir := newLevelDB(t)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	assert.Nil(t, client.SetSceneParcels("QmA", []string{"0,0", "0,1"}))
+	parcels, err := client.GetSceneParcels("QmA")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"0,1", "0,0"}, parcels)
+
+	// A new scene on one of the parcels clears the parcels of the old one
+	assert.Nil(t, client.SetSceneParcels("QmB", []string{"0,1", "0,2"}))
+	parcels, err = client.GetSceneParcels("QmA")
+	assert.Nil(t, err)
+	assert.Empty(t, parcels)
+	cids, err := client.GetParcelCIDs([]string{"0,0", "0,1", "0,2", "0,3"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"0,0": "QmA", "0,1": "QmB", "0,2": "QmB"}, cids)
+
+	// Parcels without a previous scene are pushed to the ones the scene has
+	assert.Nil(t, client.SetSceneParcels("QmB", []string{"0,3"}))
+	parcels, err = client.GetSceneParcels("QmB")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"0,3", "0,2", "0,1"}, parcels)
+
+	assert.Error(t, client.SetSceneParcels("QmC", nil))
+}
+
+func TestLevelDBProcessedParcel(t *testing.T) {
+	client, dir := newLevelDB(t)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	processed, err := client.ProcessedParcel("0,0")
+	assert.Nil(t, err)
+	assert.False(t, processed)
+
+	assert.Nil(t, client.SetProcessedParcel("0,0"))
+	processed, err = client.ProcessedParcel("0,0")
+	assert.Nil(t, err)
+	assert.True(t, processed)
+
+	all, err := client.ProcessedParcels([]string{"0,0", "0,1"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"0,0": true, "0,1": false}, all)
+}
+
+func TestLevelDBVariants(t *testing.T) {
+	client, dir := newLevelDB(t)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	variants, err := client.GetVariants("QmFile")
+	assert.Nil(t, err)
+	assert.Empty(t, variants)
+
+	assert.Nil(t, client.SetVariants("QmFile", []string{"br", "gzip"}))
+	assert.Nil(t, client.SetVariants("QmOther", []string{"gzip"}))
+	variants, err = client.GetVariants("QmFile")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"br", "gzip"}, variants)
+
+	// Dropped along with the file
+	assert.Nil(t, client.RemoveContent([]string{"QmFile"}))
+	variants, _ = client.GetVariants("QmFile")
+	assert.Empty(t, variants)
+	assert.Nil(t, client.FinishDeletion("QmOther", true))
+	variants, _ = client.GetVariants("QmOther")
+	assert.Empty(t, variants)
+}
+
+func TestLevelDBRootCidSceneCid(t *testing.T) {
+	client, dir := newLevelDB(t)
+	defer os.RemoveAll(dir)
+	defer client.Close()
+
+	_, err := client.GetSceneCid("QmRoot")
+	assert.Equal(t, redis.Nil, err)
+
+	assert.Nil(t, client.SaveRootCidSceneCid("QmRoot", "QmScene"))
+	scene, err := client.GetSceneCid("QmRoot")
+	assert.Nil(t, err)
+	assert.Equal(t, "QmScene", scene)
+	root, err := client.GetRootCid("QmScene")
+	assert.Nil(t, err)
+	assert.Equal(t, "QmRoot", root)
+}
+
+func TestLevelDBCommitScene(t *testing.T) {
+	client, dir := newLevelDB(t)
+	defer os.RemoveAll(dir)
+
+	first := &data.SceneIndex{
+		RootCid:   "QmOld",
+		SceneCid:  "QmOldScene",
+		Parcels:   []string{"0,0", "0,1"},
+		Content:   map[string]string{"scene.json": "QmFile", "a.png": "QmA"},
+		Metadata:  map[string]interface{}{"root_cid": "QmOld", "pubkey": "0xABC", "timestamp": 10},
+		Publisher: "0xABC",
+		Kind:      data.KindDeploy,
+		Usage:     &data.Usage{Deployments: 1, Files: 2, Bytes: 20},
+	}
+	second := &data.SceneIndex{
+		RootCid:   "QmNew",
+		Parcels:   []string{"0,1"},
+		Content:   map[string]string{"scene.json": "QmFile"},
+		Metadata:  map[string]interface{}{"root_cid": "QmNew", "pubkey": "0xabc", "timestamp": 20},
+		Publisher: "0xabc",
+		Kind:      data.KindDeploy,
+		Usage:     &data.Usage{Deployments: 1, SharedFiles: 1, SharedBytes: 10},
+	}
+	assert.Nil(t, client.CommitScene(first))
+	assert.Nil(t, client.CommitScene(second))
+	assert.Nil(t, client.Close())
+
+	// Everything is read back from disk
+	agent, _ := metrics.Make(config.Metrics{AppName: "", Enabled: false, AnalyticsKey: ""})
+	client, err := data.NewLevelDBClient(dir, agent)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer client.Close()
+
+	metadata, err := client.GetParcelMetadata("0,1")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"root_cid": "QmNew", "pubkey": "0xabc", "timestamp": 20}, metadata)
+	content, err := client.GetParcelContent("0,0")
+	assert.Nil(t, err)
+	assert.Equal(t, first.Content, content)
+
+	refs, err := client.GetContentReferences("QmFile")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"QmNew"}, refs)
+	roots, err := client.GetLiveRootCids()
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"QmOld", "QmNew"}, roots)
+	uploaded, err := client.GetUploadedContent()
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"QmFile", "QmA"}, uploaded)
+
+	history, err := client.GetParcelHistory("0,1")
+	assert.Nil(t, err)
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, "QmOld", history[0].RootCid)
+		assert.Equal(t, "QmNew", history[1].RootCid)
+	}
+	usage, err := client.GetPublisherUsage("0xAbc")
+	assert.Nil(t, err)
+	assert.Equal(t, &data.Usage{Deployments: 2, Files: 2, Bytes: 20, SharedFiles: 1, SharedBytes: 10}, usage)
+
+	assert.Nil(t, client.StoreDeployment("d1", map[string]interface{}{"status": "pending"}))
+	assert.Nil(t, client.StoreDeployment("d1", map[string]interface{}{"root_cid": "QmNew"}))
+	deployment, err := client.GetDeployment("d1")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"status": "pending", "root_cid": "QmNew"}, deployment)
+	deployment, err = client.GetDeployment("d2")
+	assert.Nil(t, err)
+	assert.Nil(t, deployment)
+}
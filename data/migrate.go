@@ -0,0 +1,240 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/go-redis/redis"
+)
+
+type MigrationOptions struct {
+	// Keys requested on every SCAN call
+	BatchSize int64
+	// Compares every key of the old layout with its copy
+	Verify bool
+	// Verifies the copies and marks the dataset with the current version. Writers of the old layout must be stopped
+	Commit bool
+	// Deletes the keys of the old layout, only once the dataset is marked with the current version
+	Cleanup bool
+}
+
+type MigrationReport struct {
+	FromVersion int      `json:"from_version"`
+	Version     int      `json:"version"`
+	Scanned     int      `json:"scanned"`
+	Copied      int      `json:"copied"`
+	Mismatched  []string `json:"mismatched"`
+	Stale       int      `json:"stale"`
+	Removed     int      `json:"removed"`
+}
+
+// Rewrites the keys of the original layout into the current one
+// The old keys are copied, not renamed, so the service running on the old layout keeps working during the migration
+// and the copy can be repeated until the dataset is committed
+func Migrate(client *redis.Client, opts MigrationOptions) (*MigrationReport, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	version, err := ReadSchemaVersion(client)
+	if err != nil {
+		return nil, err
+	}
+	report := &MigrationReport{FromVersion: version, Version: version, Mismatched: []string{}}
+	if version > SchemaVersion {
+		return nil, fmt.Errorf("redis schema version %d is newer than the supported one, %d", version, SchemaVersion)
+	}
+
+	if version < SchemaVersion {
+		if err := copyLegacyKeys(client, opts.BatchSize, report); err != nil {
+			return report, err
+		}
+	}
+	if opts.Commit && version < SchemaVersion {
+		if err := removeStaleCopies(client, opts.BatchSize, report); err != nil {
+			return report, err
+		}
+	}
+	if opts.Verify || (opts.Commit && version < SchemaVersion) {
+		if err := verifyLegacyKeys(client, opts.BatchSize, report); err != nil {
+			return report, err
+		}
+		if len(report.Mismatched) > 0 {
+			return report, fmt.Errorf("%d keys differ from their copy, run the migration again", len(report.Mismatched))
+		}
+	}
+	if opts.Commit && version < SchemaVersion {
+		if err := client.Set(schemaVersionKey, SchemaVersion, 0).Err(); err != nil {
+			return report, err
+		}
+		report.Version = SchemaVersion
+	}
+
+	if opts.Cleanup {
+		if report.Version < SchemaVersion {
+			return report, fmt.Errorf("the old keys can only be removed once the migration is committed")
+		}
+		if err := removeLegacyKeys(client, opts.BatchSize, report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// Copies every key of the old layout into its new name
+func copyLegacyKeys(client *redis.Client, batch int64, report *MigrationReport) error {
+	return scanLegacyKeys(client, batch, report, func(legacyKey string, key string) error {
+		dump, err := client.Dump(legacyKey).Result()
+		if err == redis.Nil {
+			// Deleted after the scan, a previous copy is stale
+			return client.Del(key).Err()
+		}
+		if err != nil {
+			return err
+		}
+		ttl, err := client.PTTL(legacyKey).Result()
+		if err != nil {
+			return err
+		}
+		if ttl < 0 {
+			ttl = 0
+		}
+		if err := client.RestoreReplace(key, ttl, dump).Err(); err != nil {
+			return err
+		}
+		report.Copied++
+		return nil
+	})
+}
+
+// Deletes the copies of the keys the old layout deleted after they were copied, e.g. the parcels of a cleared scene
+// The copies are only found by scanning the current layout, the scan of the old one no longer sees their source
+func removeStaleCopies(client *redis.Client, batch int64, report *MigrationReport) error {
+	var stale []string
+	iter := client.Scan(0, "", batch).Iterator()
+	for iter.Next() {
+		key := iter.Val()
+		legacyKey, ok := legacyKeyName(key)
+		if !ok {
+			continue
+		}
+		n, err := client.Exists(legacyKey).Result()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			stale = append(stale, key)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	for start := 0; start < len(stale); start += int(batch) {
+		end := start + int(batch)
+		if end > len(stale) {
+			end = len(stale)
+		}
+		n, err := client.Del(stale[start:end]...).Result()
+		if err != nil {
+			return err
+		}
+		report.Stale += int(n)
+	}
+	return nil
+}
+
+func verifyLegacyKeys(client *redis.Client, batch int64, report *MigrationReport) error {
+	err := scanLegacyKeys(client, batch, report, func(legacyKey string, key string) error {
+		equal, err := sameValue(client, legacyKey, key)
+		if err != nil {
+			return err
+		}
+		if !equal {
+			report.Mismatched = append(report.Mismatched, legacyKey)
+		}
+		return nil
+	})
+	sort.Strings(report.Mismatched)
+	return err
+}
+
+func removeLegacyKeys(client *redis.Client, batch int64, report *MigrationReport) error {
+	var keys []string
+	err := scanLegacyKeys(client, batch, report, func(legacyKey string, _ string) error {
+		keys = append(keys, legacyKey)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	keys = append(keys, legacyProbeKey)
+	for start := 0; start < len(keys); start += int(batch) {
+		end := start + int(batch)
+		if end > len(keys) {
+			end = len(keys)
+		}
+		n, err := client.Del(keys[start:end]...).Result()
+		if err != nil {
+			return err
+		}
+		report.Removed += int(n)
+	}
+	return nil
+}
+
+func scanLegacyKeys(client *redis.Client, batch int64, report *MigrationReport, f func(legacyKey string, key string) error) error {
+	iter := client.Scan(0, "", batch).Iterator()
+	for iter.Next() {
+		legacyKey := iter.Val()
+		report.Scanned++
+		keyType, err := client.Type(legacyKey).Result()
+		if err != nil {
+			return err
+		}
+		key, ok := currentKeyName(legacyKey, keyType)
+		if !ok {
+			continue
+		}
+		if err := f(legacyKey, key); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// Compares the values stored under both keys
+func sameValue(client *redis.Client, a string, b string) (bool, error) {
+	keyType, err := client.Type(a).Result()
+	if err != nil {
+		return false, err
+	}
+	read := func(key string) (interface{}, error) {
+		switch keyType {
+		case "string":
+			return client.Get(key).Result()
+		case "list":
+			return client.LRange(key, 0, -1).Result()
+		case "set":
+			members, err := client.SMembers(key).Result()
+			sort.Strings(members)
+			return members, err
+		case "hash":
+			return client.HGetAll(key).Result()
+		case "zset":
+			return client.ZRangeWithScores(key, 0, -1).Result()
+		case "none":
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("unexpected type %s for key %s", keyType, key)
+		}
+	}
+	va, err := read(a)
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+	vb, err := read(b)
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+	return reflect.DeepEqual(va, vb), nil
+}
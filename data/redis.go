@@ -1,8 +1,10 @@
 package data
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/decentraland/content-service/metrics"
@@ -21,6 +23,10 @@ type RedisClient interface {
 
 	// Retrieves the root cid of the given parcel
 	GetParcelCID(pid string) (string, error)
+	// Retrieves the root cid of each parcel in a single round trip, parcels without a scene are left out
+	GetParcelCIDs(pids []string) (map[string]string, error)
+	// Retrieves which of the given parcels have been processed, in a single round trip
+	ProcessedParcels(pids []string) (map[string]bool, error)
 
 	// Deletes the mapping root cid -> [parcels]
 	ClearScene(cid string) error
@@ -28,6 +34,8 @@ type RedisClient interface {
 	SetSceneParcels(cid string, pid []string) error
 	// Gets the mapping root cid -> [parcels]
 	GetSceneParcels(cid string) ([]string, error)
+	// Gets the mapping root cid -> [parcels] of each root cid in a single round trip, unknown ones are left out
+	GetScenesParcels(cids []string) (map[string][]string, error)
 
 	// Flags parcels as processed, this is used for flagging when the root cid -> parcels maps has been created
 	SetProcessedParcel(pid string) error
@@ -38,8 +46,135 @@ type RedisClient interface {
 	SaveRootCidSceneCid(rootCID, sceneCID string) error
 	// Retrieves the scene cid given the root cid of a scene
 	GetSceneCid(rootCID string) (string, error)
+	// Retrieves the scene cid of each root cid in a single round trip, unknown ones are left out
+	GetSceneCids(rootCIDs []string) (map[string]string, error)
 	// Retrieves the root cid given the scene cid of a scene
 	GetRootCid(sceneCID string) (string, error)
+
+	// Applies all the index updates of a new scene in a single transaction
+	CommitScene(s *SceneIndex) error
+	// Retrieves the mapping file path -> file cid of a scene
+	GetSceneContent(rootCID string) (map[string]string, error)
+	// Retrieves the content and metadata of each root cid in a single round trip, unknown ones are left out
+	GetScenesIndex(rootCIDs []string) (map[string]*StoredScene, error)
+	// Retrieves the deployments of a parcel, oldest first
+	GetParcelHistory(pid string) ([]*DeploymentRecord, error)
+	// Retrieves the deployments of a root cid, oldest first
+	GetSceneHistory(rootCID string) ([]*DeploymentRecord, error)
+
+	// Retrieves the cumulative DAG size of the given file cids, the unknown ones are left out
+	GetCumulativeSizes(cids []string) (map[string]uint64, error)
+	// Records the cumulative DAG size of the given file cids, needed to link them from a directory
+	SetCumulativeSizes(sizes map[string]uint64) error
+
+	// Retrieves the encodings of the compressed variants stored for the given file cid
+	GetVariants(cid string) ([]string, error)
+	// Records the encodings of the compressed variants stored for the given file cid, none clears them
+	SetVariants(cid string, encodings []string) error
+
+	// Retrieves the root cids of the deployed scenes containing the given file cid
+	GetContentReferences(cid string) ([]string, error)
+
+	// Retrieves the root cids currently deployed on any parcel
+	GetLiveRootCids() ([]string, error)
+	// Retrieves every file cid added to the uploaded content set
+	GetUploadedContent() ([]string, error)
+	// Retrieves the unreferenced file cids with the time (unix seconds) they were first found unreferenced
+	GetOrphans() (map[string]int64, error)
+	// Records the time the given file cids were first found unreferenced
+	SetOrphans(orphans map[string]int64) error
+	// Forgets the given file cids as unreferenced
+	ClearOrphans(cids []string) error
+	// Removes the given file cids from the uploaded content set
+	RemoveContent(cids []string) error
+	// Clears the file cid from the orphans, restarting its grace period, and retrieves whether it is uploaded
+	// A deployment reusing a stored file claims it, so the collector does not delete it before the scene is indexed
+	ClaimContent(cid string) (ContentState, error)
+	// Removes the file cid from the uploaded content if it is still the orphan first seen at the given time and no
+	// scene references it. It is marked as being deleted until FinishDeletion. Retrieves false if it is no longer an orphan
+	TakeOrphan(cid string, firstSeen int64) (bool, error)
+	// Clears the deletion mark, the file cid is uploaded again if its file could not be deleted
+	FinishDeletion(cid string, deleted bool) error
+
+	// Retrieves the storage used by the deployments of the given address
+	GetPublisherUsage(address string) (*Usage, error)
+	// Retrieves the storage used by the deployments on the given parcel
+	GetParcelUsage(pid string) (*Usage, error)
+
+	// Saves the state of an asynchronous deployment, it is kept during deploymentRetention
+	StoreDeployment(id string, fields map[string]interface{}) error
+	// Retrieves the state of an asynchronous deployment, nil if not found
+	GetDeployment(id string) (map[string]string, error)
+}
+
+// All the index entries written when a scene is deployed
+type SceneIndex struct {
+	RootCid  string
+	SceneCid string
+	Parcels  []string
+	// File path -> file CID
+	Content  map[string]string
+	Metadata map[string]interface{}
+
+	Publisher string
+	Signature string
+	Origin    string
+	Kind      string
+	// Added to the usage of the publisher and of each parcel, nil leaves it untouched
+	Usage *Usage
+}
+
+// Content and metadata of a deployed scene
+type StoredScene struct {
+	// File path -> file CID
+	Content  map[string]string
+	Metadata map[string]interface{}
+}
+
+// Storage used by deployments over time. Files are counted once per deployment, even if several paths
+// point to them
+type Usage struct {
+	Deployments int64 `json:"deployments"`
+	// Files the deployments stored
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+	// Files the deployments referenced that were already stored, shared with other scenes
+	SharedFiles int64 `json:"shared_files"`
+	SharedBytes int64 `json:"shared_bytes"`
+}
+
+// Fields of the usage hashes
+var usageFields = []string{"deployments", "files", "bytes", "shared_files", "shared_bytes"}
+
+func (u *Usage) values() []int64 {
+	return []int64{u.Deployments, u.Files, u.Bytes, u.SharedFiles, u.SharedBytes}
+}
+
+const (
+	KindDeploy   = "deploy"
+	KindRollback = "rollback"
+)
+
+// State of a file cid in the uploaded content
+type ContentState int
+
+const (
+	ContentMissing ContentState = iota
+	ContentUploaded
+	// Being deleted by the garbage collector
+	ContentDeleting
+)
+
+// A deployment as recorded in the parcel and scene history
+type DeploymentRecord struct {
+	RootCid   string   `json:"root_cid"`
+	SceneCid  string   `json:"scene_cid"`
+	Parcels   []string `json:"parcels"`
+	Publisher string   `json:"publisher"`
+	Signature string   `json:"signature"`
+	Origin    string   `json:"origin"`
+	Kind      string   `json:"kind"`
+	Timestamp int64    `json:"timestamp"`
 }
 
 type Redis struct {
@@ -47,11 +182,10 @@ type Redis struct {
 	Agent  *metrics.Agent
 }
 
-const uploadedElementsKey = "uploaded-content"
-const metadataKeyPrefix = "metadata_"
-const contentKeyPrefix = "content_"
-const proccessedSet = "processedSet"
-const rootScenePrefix = "root-scene:"
+const deploymentRetention = 24 * time.Hour
+
+// Times a scene commit is retried when another deployment modifies the same parcels
+const maxCommitRetries = 5
 
 func NewRedisClient(address string, password string, db int, agent *metrics.Agent) (*Redis, error) {
 	client := redis.NewClient(&redis.Options{
@@ -59,8 +193,8 @@ func NewRedisClient(address string, password string, db int, agent *metrics.Agen
 		Password: password,
 		DB:       db,
 	})
-	err := client.Set("key", "value", 0).Err()
-	return &Redis{Client: client, Agent: agent}, err
+	r := &Redis{Client: client, Agent: agent}
+	return r, r.checkSchema()
 }
 
 func (r Redis) GetParcelMetadata(parcelID string) (map[string]interface{}, error) {
@@ -76,8 +210,17 @@ func (r Redis) GetParcelMetadata(parcelID string) (map[string]interface{}, error
 		return nil, nil
 	}
 
+	metadata, err := parseMetadata(parcelMeta)
+	if err != nil {
+		return nil, err
+	}
+	r.Agent.RecordGetParcelMetadata(time.Since(t))
+	return metadata, nil
+}
+
+func parseMetadata(fields map[string]string) (map[string]interface{}, error) {
 	metadata := make(map[string]interface{})
-	for key, value := range parcelMeta {
+	for key, value := range fields {
 		if key == "validityType" || key == "sequence" || key == "timestamp" {
 			intValue, err := strconv.Atoi(value)
 			if err != nil {
@@ -88,7 +231,6 @@ func (r Redis) GetParcelMetadata(parcelID string) (map[string]interface{}, error
 			metadata[key] = value
 		}
 	}
-	r.Agent.RecordGetParcelMetadata(time.Since(t))
 	return metadata, nil
 }
 
@@ -138,7 +280,7 @@ func (r Redis) IsContentMember(value string) (bool, error) {
 }
 
 func (r Redis) getParcelInformationFromCollection(parcelID string, keyPrefix string) (map[string]string, error) {
-	parcelCID, err := r.Client.Get(parcelID).Result()
+	parcelCID, err := r.Client.Get(parcelPrefix + parcelID).Result()
 
 	if err == redis.Nil {
 		return nil, nil
@@ -159,7 +301,7 @@ func (r Redis) getParcelInformationFromCollection(parcelID string, keyPrefix str
 }
 
 func (r Redis) GetParcelCID(pid string) (string, error) {
-	cid, err := r.Client.Get(pid).Result()
+	cid, err := r.Client.Get(parcelPrefix + pid).Result()
 	if err != nil && err != redis.Nil {
 		logrus.Errorf("Redis error: %s", err.Error())
 		return "", err
@@ -167,6 +309,55 @@ func (r Redis) GetParcelCID(pid string) (string, error) {
 	return cid, nil
 }
 
+func (r Redis) GetParcelCIDs(pids []string) (map[string]string, error) {
+	return r.mget(parcelPrefix, pids)
+}
+
+// Retrieves the value of each prefix+key, the missing ones are left out
+func (r Redis) mget(prefix string, keys []string) (map[string]string, error) {
+	ret := make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return ret, nil
+	}
+	prefixed := make([]string, 0, len(keys))
+	for _, k := range keys {
+		prefixed = append(prefixed, prefix+k)
+	}
+	values, err := r.Client.MGet(prefixed...).Result()
+	if err != nil {
+		logrus.Errorf("Redis error: %s", err.Error())
+		return nil, err
+	}
+	for i, v := range values {
+		if s, ok := v.(string); ok && s != "" {
+			ret[keys[i]] = s
+		}
+	}
+	return ret, nil
+}
+
+func (r Redis) ProcessedParcels(pids []string) (map[string]bool, error) {
+	ret := make(map[string]bool, len(pids))
+	if len(pids) == 0 {
+		return ret, nil
+	}
+	cmds := make([]*redis.BoolCmd, len(pids))
+	_, err := r.Client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, pid := range pids {
+			cmds[i] = pipe.SIsMember(proccessedSet, pid)
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.Errorf("Redis error: %s", err.Error())
+		return nil, err
+	}
+	for i, cmd := range cmds {
+		ret[pids[i]] = cmd.Val()
+	}
+	return ret, nil
+}
+
 func (r Redis) ProcessedParcel(pid string) (bool, error) {
 	member, err := r.Client.SIsMember(proccessedSet, pid).Result()
 	if err != nil && err != redis.Nil {
@@ -208,15 +399,15 @@ func (r Redis) SetSceneParcels(scene string, parcels []string) error {
 	}
 
 	for cid, _ := range cids {
-		_, err := r.Client.Del(cid).Result()
+		_, err := r.Client.Del(sceneParcelsPrefix + cid).Result()
 		if err != nil {
 			return fmt.Errorf("redis error when cleaning old scenes: %s", err)
 		}
 	}
 
-	_, err := r.Client.LPush(scene, parcels).Result()
+	_, err := r.Client.LPush(sceneParcelsPrefix+scene, parcels).Result()
 	for _, pid := range parcels {
-		err := r.setKey(pid, scene)
+		err := r.setKey(parcelPrefix+pid, scene)
 		if err != nil {
 			return fmt.Errorf("redis error when updating scene %s", err)
 		}
@@ -225,19 +416,228 @@ func (r Redis) SetSceneParcels(scene string, parcels []string) error {
 	return err
 }
 
+// Applies all the index updates of a new scene in a single MULTI/EXEC transaction
+// The parcels are watched while the old scenes are read, so concurrent deployments over the same parcels are retried
+// Nothing is written unless the whole transaction is executed, so readers never see a half-deployed scene
+func (r Redis) CommitScene(s *SceneIndex) error {
+	if len(s.Parcels) == 0 {
+		return fmt.Errorf("Trying to push empty parcels list for scene %s", s.RootCid)
+	}
+	t := time.Now()
+	defer func() { r.Agent.RecordCommitScene(time.Since(t)) }()
+
+	parcels := make([]interface{}, 0, len(s.Parcels))
+	for _, p := range s.Parcels {
+		parcels = append(parcels, p)
+	}
+	record, err := json.Marshal(&DeploymentRecord{
+		RootCid:   s.RootCid,
+		SceneCid:  s.SceneCid,
+		Parcels:   s.Parcels,
+		Publisher: s.Publisher,
+		Signature: s.Signature,
+		Origin:    s.Origin,
+		Kind:      s.Kind,
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	fileCids := make([]interface{}, 0, len(s.Content))
+	content := make(map[string]interface{}, len(s.Content))
+	for path, cid := range s.Content {
+		content[path] = cid
+		fileCids = append(fileCids, cid)
+	}
+
+	commit := func(tx *redis.Tx) error {
+		oldScenes := make(map[string]bool, len(s.Parcels))
+		for _, p := range s.Parcels {
+			cid, err := tx.Get(parcelPrefix + p).Result()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			if cid != "" {
+				oldScenes[cid] = true
+			}
+		}
+
+		// The replaced scenes no longer reference their files
+		oldContent := make(map[string][]string, len(oldScenes))
+		for cid := range oldScenes {
+			if cid == s.RootCid {
+				continue
+			}
+			files, err := tx.HVals(contentKeyPrefix + cid).Result()
+			if err != nil {
+				return err
+			}
+			oldContent[cid] = files
+		}
+
+		_, err := tx.Pipelined(func(pipe redis.Pipeliner) error {
+			for cid := range oldScenes {
+				pipe.Del(sceneParcelsPrefix + cid)
+			}
+			pipe.Del(sceneParcelsPrefix + s.RootCid)
+			pipe.LPush(sceneParcelsPrefix+s.RootCid, parcels...)
+			for _, p := range s.Parcels {
+				pipe.Set(parcelPrefix+p, s.RootCid, 0)
+			}
+			pipe.SAdd(proccessedSet, parcels...)
+			for cid, files := range oldContent {
+				for _, f := range files {
+					pipe.SRem(contentReferencesPrefix+f, cid)
+				}
+			}
+			if len(content) > 0 {
+				pipe.HMSet(contentKeyPrefix+s.RootCid, content)
+				pipe.SAdd(uploadedElementsKey, fileCids...)
+			}
+			for _, f := range fileCids {
+				pipe.SAdd(contentReferencesPrefix+f.(string), s.RootCid)
+			}
+			pipe.HMSet(metadataKeyPrefix+s.RootCid, s.Metadata)
+			pipe.Set(rootScenePrefix+s.RootCid, s.SceneCid, 0)
+			if s.SceneCid != "" {
+				pipe.Set(rootScenePrefix+s.SceneCid, s.RootCid, 0)
+			}
+			for _, p := range s.Parcels {
+				pipe.RPush(parcelHistoryPrefix+p, record)
+			}
+			pipe.RPush(sceneHistoryPrefix+s.RootCid, record)
+			if s.Usage != nil {
+				incrUsage(pipe, publisherUsagePrefix+strings.ToLower(s.Publisher), s.Usage)
+				for _, p := range s.Parcels {
+					incrUsage(pipe, parcelUsagePrefix+p, s.Usage)
+				}
+			}
+			return nil
+		})
+		return err
+	}
+
+	parcelKeys := make([]string, 0, len(s.Parcels))
+	for _, p := range s.Parcels {
+		parcelKeys = append(parcelKeys, parcelPrefix+p)
+	}
+	for i := 0; i < maxCommitRetries; i++ {
+		err := r.Client.Watch(commit, parcelKeys...)
+		if err != redis.TxFailedErr {
+			return err
+		}
+		logrus.Debugf("Parcels of scene %s modified during commit, retrying", s.RootCid)
+	}
+	return fmt.Errorf("redis error: unable to commit scene %s, parcels modified concurrently", s.RootCid)
+}
+
+func (r Redis) GetSceneContent(rootCID string) (map[string]string, error) {
+	t := time.Now()
+	res, err := r.Client.HGetAll(contentKeyPrefix + rootCID).Result()
+	r.Agent.RecordGetParcelContent(time.Since(t))
+	if err != nil {
+		logrus.Errorf("Redis error: %s", err.Error())
+		return nil, err
+	}
+	return res, nil
+}
+
+func (r Redis) GetScenesIndex(rootCIDs []string) (map[string]*StoredScene, error) {
+	ret := make(map[string]*StoredScene, len(rootCIDs))
+	if len(rootCIDs) == 0 {
+		return ret, nil
+	}
+	t := time.Now()
+	content := make([]*redis.StringStringMapCmd, len(rootCIDs))
+	metadata := make([]*redis.StringStringMapCmd, len(rootCIDs))
+	_, err := r.Client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, cid := range rootCIDs {
+			content[i] = pipe.HGetAll(contentKeyPrefix + cid)
+			metadata[i] = pipe.HGetAll(metadataKeyPrefix + cid)
+		}
+		return nil
+	})
+	r.Agent.RecordGetParcelContent(time.Since(t))
+	if err != nil {
+		logrus.Errorf("Redis error: %s", err.Error())
+		return nil, err
+	}
+	for i, cid := range rootCIDs {
+		if len(metadata[i].Val()) == 0 {
+			continue
+		}
+		m, err := parseMetadata(metadata[i].Val())
+		if err != nil {
+			return nil, err
+		}
+		ret[cid] = &StoredScene{Content: content[i].Val(), Metadata: m}
+	}
+	return ret, nil
+}
+
+func (r Redis) GetParcelHistory(pid string) ([]*DeploymentRecord, error) {
+	return r.getHistory(parcelHistoryPrefix + pid)
+}
+
+func (r Redis) GetSceneHistory(rootCID string) ([]*DeploymentRecord, error) {
+	return r.getHistory(sceneHistoryPrefix + rootCID)
+}
+
+func (r Redis) getHistory(key string) ([]*DeploymentRecord, error) {
+	entries, err := r.Client.LRange(key, 0, -1).Result()
+	if err != nil {
+		logrus.Errorf("Redis error: %s", err.Error())
+		return nil, err
+	}
+	history := make([]*DeploymentRecord, 0, len(entries))
+	for _, e := range entries {
+		var record DeploymentRecord
+		if err := json.Unmarshal([]byte(e), &record); err != nil {
+			return nil, err
+		}
+		history = append(history, &record)
+	}
+	return history, nil
+}
+
 func (r Redis) ClearScene(cid string) error {
-	_, err := r.Client.Del(cid).Result()
+	_, err := r.Client.Del(sceneParcelsPrefix + cid).Result()
 	return err
 }
 
 func (r Redis) GetSceneParcels(cid string) ([]string, error) {
-	scenes, err := r.Client.LRange(cid, 0, -1).Result()
+	scenes, err := r.Client.LRange(sceneParcelsPrefix+cid, 0, -1).Result()
 	if err != nil {
 		return nil, err
 	}
 	return scenes, nil
 }
 
+func (r Redis) GetScenesParcels(cids []string) (map[string][]string, error) {
+	ret := make(map[string][]string, len(cids))
+	if len(cids) == 0 {
+		return ret, nil
+	}
+	cmds := make([]*redis.StringSliceCmd, len(cids))
+	_, err := r.Client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, cid := range cids {
+			cmds[i] = pipe.LRange(sceneParcelsPrefix+cid, 0, -1)
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.Errorf("Redis error: %s", err.Error())
+		return nil, err
+	}
+	for i, cmd := range cmds {
+		if parcels := cmd.Val(); len(parcels) > 0 {
+			ret[cids[i]] = parcels
+		}
+	}
+	return ret, nil
+}
+
 func (r Redis) SaveRootCidSceneCid(rootCID, sceneCID string) error {
 	_, err := r.Client.Set(rootScenePrefix+rootCID, sceneCID, 0).Result()
 	if err != nil {
@@ -254,6 +654,10 @@ func (r Redis) GetSceneCid(rootCID string) (string, error) {
 	return ret, nil
 }
 
+func (r Redis) GetSceneCids(rootCIDs []string) (map[string]string, error) {
+	return r.mget(rootScenePrefix, rootCIDs)
+}
+
 func (r Redis) GetRootCid(sceneCID string) (string, error) {
 	ret, err := r.Client.Get(rootScenePrefix + sceneCID).Result()
 	if err != nil {
@@ -261,3 +665,322 @@ func (r Redis) GetRootCid(sceneCID string) (string, error) {
 	}
 	return ret, nil
 }
+
+func (r Redis) StoreDeployment(id string, fields map[string]interface{}) error {
+	_, err := r.Client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HMSet(deploymentPrefix+id, fields)
+		pipe.Expire(deploymentPrefix+id, deploymentRetention)
+		return nil
+	})
+	return err
+}
+
+func incrUsage(pipe redis.Pipeliner, key string, u *Usage) {
+	for i, v := range u.values() {
+		if v != 0 {
+			pipe.HIncrBy(key, usageFields[i], v)
+		}
+	}
+}
+
+func (r Redis) GetPublisherUsage(address string) (*Usage, error) {
+	return r.getUsage(publisherUsagePrefix + strings.ToLower(address))
+}
+
+func (r Redis) GetParcelUsage(pid string) (*Usage, error) {
+	return r.getUsage(parcelUsagePrefix + pid)
+}
+
+// Missing counters are retrieved as 0
+func (r Redis) getUsage(key string) (*Usage, error) {
+	res, err := r.Client.HMGet(key, usageFields...).Result()
+	if err != nil {
+		logrus.Errorf("Redis error: %s", err.Error())
+		return nil, err
+	}
+	values := make([]int64, len(usageFields))
+	for i, v := range res {
+		if v == nil {
+			continue
+		}
+		values[i], err = strconv.ParseInt(v.(string), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s usage in %s: %v", usageFields[i], key, v)
+		}
+	}
+	return &Usage{
+		Deployments: values[0],
+		Files:       values[1],
+		Bytes:       values[2],
+		SharedFiles: values[3],
+		SharedBytes: values[4],
+	}, nil
+}
+
+func (r Redis) GetDeployment(id string) (map[string]string, error) {
+	res, err := r.Client.HGetAll(deploymentPrefix + id).Result()
+	if err != nil {
+		logrus.Errorf("Redis error: %s", err.Error())
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+	return res, nil
+}
+
+func (r Redis) GetCumulativeSizes(cids []string) (map[string]uint64, error) {
+	sizes := make(map[string]uint64, len(cids))
+	if len(cids) == 0 {
+		return sizes, nil
+	}
+	res, err := r.Client.HMGet(cumulativeSizesKey, cids...).Result()
+	if err != nil {
+		logrus.Errorf("Redis error: %s", err.Error())
+		return nil, err
+	}
+	for i, v := range res {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		size, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		sizes[cids[i]] = size
+	}
+	return sizes, nil
+}
+
+func (r Redis) SetCumulativeSizes(sizes map[string]uint64) error {
+	if len(sizes) == 0 {
+		return nil
+	}
+	fields := make(map[string]interface{}, len(sizes))
+	for c, size := range sizes {
+		fields[c] = size
+	}
+	return r.Client.HMSet(cumulativeSizesKey, fields).Err()
+}
+
+func (r Redis) GetVariants(cid string) ([]string, error) {
+	v, err := r.Client.HGet(variantsKey, cid).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		logrus.Errorf("Redis error: %s", err.Error())
+		return nil, err
+	}
+	return strings.Split(v, ","), nil
+}
+
+func (r Redis) SetVariants(cid string, encodings []string) error {
+	if len(encodings) == 0 {
+		return r.Client.HDel(variantsKey, cid).Err()
+	}
+	return r.Client.HSet(variantsKey, cid, strings.Join(encodings, ",")).Err()
+}
+
+func (r Redis) GetContentReferences(cid string) ([]string, error) {
+	roots, err := r.Client.SMembers(contentReferencesPrefix + cid).Result()
+	if err != nil {
+		logrus.Errorf("Redis error: %s", err.Error())
+		return nil, err
+	}
+	return roots, nil
+}
+
+func (r Redis) GetLiveRootCids() ([]string, error) {
+	parcels, err := r.Client.SMembers(proccessedSet).Result()
+	if err != nil {
+		logrus.Errorf("Redis error: %s", err.Error())
+		return nil, err
+	}
+	if len(parcels) == 0 {
+		return nil, nil
+	}
+
+	cmds := make([]*redis.StringCmd, 0, len(parcels))
+	_, err = r.Client.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, p := range parcels {
+			cmds = append(cmds, pipe.Get(parcelPrefix+p))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		logrus.Errorf("Redis error: %s", err.Error())
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var roots []string
+	for _, cmd := range cmds {
+		cid, err := cmd.Result()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !seen[cid] {
+			seen[cid] = true
+			roots = append(roots, cid)
+		}
+	}
+	return roots, nil
+}
+
+func (r Redis) GetUploadedContent() ([]string, error) {
+	var cids []string
+	iter := r.Client.SScan(uploadedElementsKey, 0, "", 1000).Iterator()
+	for iter.Next() {
+		cids = append(cids, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		logrus.Errorf("Redis error: %s", err.Error())
+		return nil, err
+	}
+	return cids, nil
+}
+
+func (r Redis) GetOrphans() (map[string]int64, error) {
+	res, err := r.Client.HGetAll(orphansKey).Result()
+	if err != nil {
+		logrus.Errorf("Redis error: %s", err.Error())
+		return nil, err
+	}
+	orphans := make(map[string]int64, len(res))
+	for cid, v := range res {
+		ts, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		orphans[cid] = ts
+	}
+	return orphans, nil
+}
+
+func (r Redis) SetOrphans(orphans map[string]int64) error {
+	if len(orphans) == 0 {
+		return nil
+	}
+	fields := make(map[string]interface{}, len(orphans))
+	for cid, ts := range orphans {
+		fields[cid] = ts
+	}
+	return r.Client.HMSet(orphansKey, fields).Err()
+}
+
+func (r Redis) ClearOrphans(cids []string) error {
+	if len(cids) == 0 {
+		return nil
+	}
+	return r.Client.HDel(orphansKey, cids...).Err()
+}
+
+func (r Redis) RemoveContent(cids []string) error {
+	if len(cids) == 0 {
+		return nil
+	}
+	members := make([]interface{}, 0, len(cids))
+	for _, c := range cids {
+		members = append(members, c)
+	}
+	_, err := r.Client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.SRem(uploadedElementsKey, members...)
+		pipe.HDel(orphansKey, cids...)
+		pipe.HDel(variantsKey, cids...)
+		return nil
+	})
+	return err
+}
+
+var claimContent = redis.NewScript(`
+redis.call('HDEL', KEYS[1], ARGV[1])
+if redis.call('SISMEMBER', KEYS[2], ARGV[1]) == 1 then
+  return 1
+end
+if redis.call('SISMEMBER', KEYS[3], ARGV[1]) == 1 then
+  return 2
+end
+return 0
+`)
+
+func (r Redis) ClaimContent(cid string) (ContentState, error) {
+	state, err := claimContent.Run(r.Client, []string{orphansKey, uploadedElementsKey, deletingKey}, cid).Int64()
+	if err != nil {
+		logrus.Errorf("Redis error: %s", err.Error())
+		return ContentMissing, err
+	}
+	return ContentState(state), nil
+}
+
+// Checks and removes in a single step, a claim or a deployment in between makes it fail
+var takeOrphan = redis.NewScript(`
+if redis.call('HGET', KEYS[1], ARGV[1]) ~= ARGV[2] then
+  return 0
+end
+if redis.call('SCARD', KEYS[4]) > 0 then
+  return 0
+end
+if redis.call('SREM', KEYS[2], ARGV[1]) == 0 then
+  return 0
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+redis.call('SADD', KEYS[3], ARGV[1])
+return 1
+`)
+
+func (r Redis) TakeOrphan(cid string, firstSeen int64) (bool, error) {
+	keys := []string{orphansKey, uploadedElementsKey, deletingKey, contentReferencesPrefix + cid}
+	taken, err := takeOrphan.Run(r.Client, keys, cid, strconv.FormatInt(firstSeen, 10)).Int64()
+	if err != nil {
+		logrus.Errorf("Redis error: %s", err.Error())
+		return false, err
+	}
+	return taken == 1, nil
+}
+
+func (r Redis) FinishDeletion(cid string, deleted bool) error {
+	if deleted {
+		_, err := r.Client.TxPipelined(func(pipe redis.Pipeliner) error {
+			pipe.SRem(deletingKey, cid)
+			pipe.HDel(variantsKey, cid)
+			return nil
+		})
+		return err
+	}
+	return r.Client.SMove(deletingKey, uploadedElementsKey, cid).Err()
+}
+
+// Channel of the keys changed in the index, see SceneCache
+const invalidationsChannel = "index:invalidations"
+
+func (r Redis) PublishInvalidation(keys []string) error {
+	msg, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	return r.Client.Publish(invalidationsChannel, msg).Err()
+}
+
+// Messages published while the connection is down are lost, the cached entries expire anyway
+func (r Redis) SubscribeInvalidations(f func(keys []string)) (func() error, error) {
+	sub := r.Client.Subscribe(invalidationsChannel)
+	if _, err := sub.Receive(); err != nil {
+		sub.Close()
+		return nil, err
+	}
+	go func() {
+		for msg := range sub.Channel() {
+			var keys []string
+			if err := json.Unmarshal([]byte(msg.Payload), &keys); err != nil {
+				logrus.Errorf("Invalid index invalidation: %s", err)
+				continue
+			}
+			f(keys)
+		}
+	}()
+	return sub.Close, nil
+}
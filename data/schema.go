@@ -0,0 +1,155 @@
+package data
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis"
+)
+
+// Version of the key layout this code reads and writes
+// Version 1 is the original layout, with parcels and scenes stored under bare keys. It has no marker
+const SchemaVersion = 2
+
+const schemaVersionKey = "schema:version"
+
+// Keys of the current layout. Every key belongs to a namespace, so the dataset can share the DB
+const (
+	// parcel id -> root cid
+	parcelPrefix = "parcel:"
+	// root cid -> [parcels]
+	sceneParcelsPrefix = "scene:parcels:"
+	// root cid -> {file path: file cid}
+	contentKeyPrefix = "scene:content:"
+	// root cid -> upload metadata
+	metadataKeyPrefix = "scene:metadata:"
+	// root cid -> scene cid, and scene cid -> root cid
+	rootScenePrefix = "scene:cid:"
+	// file cid -> [root cids]
+	contentReferencesPrefix = "content:references:"
+	uploadedElementsKey     = "content:uploaded"
+	cumulativeSizesKey      = "content:dag-size"
+	variantsKey             = "content:variants"
+	proccessedSet           = "parcels:processed"
+	parcelHistoryPrefix     = "history:parcel:"
+	sceneHistoryPrefix      = "history:scene:"
+	orphansKey              = "gc:orphans"
+	// file cids being deleted by the garbage collector
+	deletingKey          = "gc:deleting"
+	publisherUsagePrefix = "usage:publisher:"
+	parcelUsagePrefix    = "usage:parcel:"
+	deploymentPrefix     = "deployment:"
+)
+
+// Fails if the DB holds a dataset in an older layout, it must be migrated first
+// An empty DB is marked with the current version
+func (r Redis) checkSchema() error {
+	version, err := ReadSchemaVersion(r.Client)
+	if err != nil {
+		return err
+	}
+	if version == SchemaVersion {
+		return nil
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("redis schema version %d is newer than the supported one, %d", version, SchemaVersion)
+	}
+	if version > 0 {
+		return fmt.Errorf("redis schema version %d is outdated, run cmd/migrate to upgrade it to version %d", version, SchemaVersion)
+	}
+	return r.Client.Set(schemaVersionKey, SchemaVersion, 0).Err()
+}
+
+// Retrieves the version of the dataset layout, 0 if the DB is empty
+func ReadSchemaVersion(client *redis.Client) (int, error) {
+	v, err := client.Get(schemaVersionKey).Result()
+	if err == nil {
+		return strconv.Atoi(v)
+	}
+	if err != redis.Nil {
+		return 0, err
+	}
+	// The original layout has no marker, it is recognized by its keys
+	n, err := client.Exists(legacyUploadedKey, legacyProcessedKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Keys of the original layout
+const (
+	legacyUploadedKey  = "uploaded-content"
+	legacyProcessedKey = "processedSet"
+	// Written by every client on startup to check the connection
+	legacyProbeKey = "key"
+)
+
+// Legacy key or prefix -> current one
+var legacyNames = []struct {
+	legacy  string
+	current string
+	prefix  bool
+}{
+	{legacy: legacyUploadedKey, current: uploadedElementsKey},
+	{legacy: legacyProcessedKey, current: proccessedSet},
+	{legacy: "metadata_", current: metadataKeyPrefix, prefix: true},
+	{legacy: "content_", current: contentKeyPrefix, prefix: true},
+	{legacy: "root-scene:", current: rootScenePrefix, prefix: true},
+}
+
+// Prefixes of the current layout, their keys are already migrated
+var currentPrefixes = []string{"schema:", "parcel:", "parcels:", "scene:", "content:", "history:", "gc:", "usage:", "events:", deploymentPrefix}
+
+var parcelIdPattern = regexp.MustCompile(`^-?\d+,-?\d+$`)
+
+// Retrieves the name a key of the original layout has in the current one
+// keyType is the Redis type of the key, needed to recognize the scene parcels lists stored under bare root cids
+// ok is false for the keys that are not migrated: the ones already in the current layout and the unknown ones
+func currentKeyName(key string, keyType string) (string, bool) {
+	for _, p := range currentPrefixes {
+		if strings.HasPrefix(key, p) {
+			return "", false
+		}
+	}
+	for _, n := range legacyNames {
+		if n.prefix && strings.HasPrefix(key, n.legacy) {
+			return n.current + strings.TrimPrefix(key, n.legacy), true
+		}
+		if !n.prefix && key == n.legacy {
+			return n.current, true
+		}
+	}
+	if keyType == "string" && parcelIdPattern.MatchString(key) {
+		return parcelPrefix + key, true
+	}
+	if keyType == "list" && strings.HasPrefix(key, "Qm") {
+		return sceneParcelsPrefix + key, true
+	}
+	return "", false
+}
+
+// Retrieves the name the key had in the original layout, the inverse of currentKeyName
+// ok is false for the keys that can not be a copy of one in the original layout
+func legacyKeyName(key string) (string, bool) {
+	for _, n := range legacyNames {
+		if n.prefix && strings.HasPrefix(key, n.current) {
+			return n.legacy + strings.TrimPrefix(key, n.current), true
+		}
+		if !n.prefix && key == n.current {
+			return n.legacy, true
+		}
+	}
+	if pid := strings.TrimPrefix(key, parcelPrefix); pid != key && parcelIdPattern.MatchString(pid) {
+		return pid, true
+	}
+	if cid := strings.TrimPrefix(key, sceneParcelsPrefix); cid != key && strings.HasPrefix(cid, "Qm") {
+		return cid, true
+	}
+	return "", false
+}
@@ -0,0 +1,124 @@
+package data
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+type currentKeyNameCase struct {
+	name     string
+	key      string
+	keyType  string
+	expected string
+	migrated bool
+}
+
+var currentKeyNameCases = []currentKeyNameCase{
+	{name: "Parcel", key: "-10,20", keyType: "string", expected: "parcel:-10,20", migrated: true},
+	{name: "Scene parcels", key: "QmRoot", keyType: "list", expected: "scene:parcels:QmRoot", migrated: true},
+	{name: "Scene content", key: "content_QmRoot", keyType: "hash", expected: "scene:content:QmRoot", migrated: true},
+	{name: "Scene metadata", key: "metadata_QmRoot", keyType: "hash", expected: "scene:metadata:QmRoot", migrated: true},
+	{name: "Scene cid", key: "root-scene:QmRoot", keyType: "string", expected: "scene:cid:QmRoot", migrated: true},
+	{name: "Uploaded content", key: "uploaded-content", keyType: "set", expected: "content:uploaded", migrated: true},
+	{name: "Processed parcels", key: "processedSet", keyType: "set", expected: "parcels:processed", migrated: true},
+	{name: "Already migrated", key: "parcel:-10,20", keyType: "string"},
+	{name: "Migrated scene parcels", key: "scene:parcels:QmRoot", keyType: "list"},
+	{name: "Deployment", key: "deployment:QmRoot", keyType: "hash"},
+	{name: "Probe", key: "key", keyType: "string"},
+	{name: "Parcel id of another type", key: "-10,20", keyType: "hash"},
+	{name: "Unknown", key: "something", keyType: "list"},
+}
+
+func TestCurrentKeyName(t *testing.T) {
+	for _, tc := range currentKeyNameCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, ok := currentKeyName(tc.key, tc.keyType)
+			assert.Equal(t, tc.migrated, ok)
+			assert.Equal(t, tc.expected, key)
+		})
+	}
+}
+
+func TestLegacyKeyName(t *testing.T) {
+	for _, tc := range currentKeyNameCases {
+		if !tc.migrated {
+			continue
+		}
+		t.Run(tc.name, func(t *testing.T) {
+			key, ok := legacyKeyName(tc.expected)
+			assert.True(t, ok)
+			assert.Equal(t, tc.key, key)
+		})
+	}
+	for _, key := range []string{"parcel:something", "scene:parcels:other", "content:references:QmFile", "deployment:QmRoot"} {
+		_, ok := legacyKeyName(key)
+		assert.False(t, ok, key)
+	}
+}
+
+// Runs against a local Redis, on a DB of its own that is flushed
+func TestMigrate(t *testing.T) {
+	if os.Getenv("RUN_IT") != "true" {
+		t.Skip("Skipping integration test. To run it set RUN_IT=true")
+	}
+	address := os.Getenv("REDIS_TEST_ADDRESS")
+	if address == "" {
+		address = "localhost:6379"
+	}
+	client := redis.NewClient(&redis.Options{Addr: address, DB: 15})
+	defer client.Close()
+	if err := client.FlushDB().Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Dataset written by the original layout
+	client.Set("key", "value", 0)
+	client.Set("-10,20", "QmRoot", 0)
+	client.LPush("QmRoot", "-10,20")
+	client.HSet("content_QmRoot", "scene.json", "QmFile")
+	client.HSet("metadata_QmRoot", "pubkey", "0xabc")
+	client.Set("root-scene:QmRoot", "QmScene", 0)
+	client.SAdd("uploaded-content", "QmFile")
+	client.SAdd("processedSet", "-10,20")
+	client.Set("0,0", "QmCleared", 0)
+	client.LPush("QmCleared", "0,0")
+
+	_, err := NewRedisClient(address, "", 15, nil)
+	assert.Error(t, err, "the old layout must be migrated first")
+
+	report, err := Migrate(client, MigrationOptions{BatchSize: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.FromVersion)
+	assert.Equal(t, 9, report.Copied)
+	assert.Equal(t, 1, report.Version)
+
+	// The service on the old layout keeps writing
+	client.SAdd("uploaded-content", "QmOther")
+	client.Del("0,0", "QmCleared")
+
+	report, err = Migrate(client, MigrationOptions{Commit: true, Cleanup: true})
+	assert.Nil(t, err)
+	assert.Equal(t, SchemaVersion, report.Version)
+	assert.Equal(t, 2, report.Stale, "the copies of the deleted keys are removed")
+	assert.Equal(t, 8, report.Removed)
+
+	r, err := NewRedisClient(address, "", 15, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	cid, err := r.GetParcelCID("-10,20")
+	assert.Nil(t, err)
+	assert.Equal(t, "QmRoot", cid)
+	parcels, err := r.GetSceneParcels("QmRoot")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-10,20"}, parcels)
+	uploaded, err := r.GetUploadedContent()
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"QmFile", "QmOther"}, uploaded)
+	keys, err := client.Keys("*").Result()
+	assert.Nil(t, err)
+	assert.Len(t, keys, 8)
+}
@@ -0,0 +1,218 @@
+package gc
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/decentraland/content-service/data"
+	"github.com/decentraland/content-service/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+// Mark and sweep garbage collector of the files no longer referenced by any deployed scene
+// Files are only deleted after being unreferenced for the whole grace period, this protects the files of
+// deployments in progress and gives time to roll back recently replaced scenes
+type Collector struct {
+	RedisClient data.RedisClient
+	Storage     storage.Storage
+	GracePeriod time.Duration
+	Log         *log.Logger
+}
+
+func NewCollector(client data.RedisClient, sto storage.Storage, grace time.Duration, l *log.Logger) *Collector {
+	return &Collector{
+		RedisClient: client,
+		Storage:     sto,
+		GracePeriod: grace,
+		Log:         l,
+	}
+}
+
+type Report struct {
+	DryRun     bool      `json:"dry_run"`
+	LiveScenes int       `json:"live_scenes"`
+	Uploaded   int       `json:"uploaded"`
+	Referenced int       `json:"referenced"`
+	Orphans    []*Orphan `json:"orphans"`
+	Deleted    int       `json:"deleted"`
+	FreedBytes int64     `json:"freed_bytes"`
+}
+
+type Orphan struct {
+	Cid       string `json:"cid"`
+	FirstSeen int64  `json:"first_seen"`
+	// Whether the grace period is over
+	Expired bool   `json:"expired"`
+	Size    int64  `json:"size,omitempty"`
+	Deleted bool   `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Runs a whole collection, in dry run mode nothing is written and the report shows what would be deleted
+func (c *Collector) Run(ctx context.Context, dryRun bool) (*Report, error) {
+	now := time.Now()
+	report := &Report{DryRun: dryRun}
+
+	marked, err := c.mark(report)
+	if err != nil {
+		return nil, err
+	}
+
+	uploaded, err := c.RedisClient.GetUploadedContent()
+	if err != nil {
+		return nil, err
+	}
+	report.Uploaded = len(uploaded)
+
+	known, err := c.RedisClient.GetOrphans()
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make(map[string]bool, len(uploaded))
+	for _, cid := range uploaded {
+		if marked[cid] {
+			continue
+		}
+		// Scenes deployed after the mark phase are found through the reverse index
+		refs, err := c.RedisClient.GetContentReferences(cid)
+		if err != nil {
+			return nil, err
+		}
+		if len(refs) == 0 {
+			candidates[cid] = true
+		}
+	}
+
+	var cleared []string
+	for cid := range known {
+		if !candidates[cid] {
+			cleared = append(cleared, cid)
+		}
+	}
+
+	found := make(map[string]int64)
+	for cid := range candidates {
+		firstSeen, ok := known[cid]
+		if !ok {
+			firstSeen = now.Unix()
+			found[cid] = firstSeen
+		}
+		o := &Orphan{Cid: cid, FirstSeen: firstSeen}
+		o.Expired = now.Sub(time.Unix(firstSeen, 0)) >= c.GracePeriod
+		report.Orphans = append(report.Orphans, o)
+		if !o.Expired {
+			continue
+		}
+
+		if info, err := c.Storage.Stat(ctx, cid); err == nil {
+			o.Size = info.Size
+		}
+		if dryRun {
+			continue
+		}
+		// A deployment may have referenced or reused the file since it was found
+		taken, err := c.RedisClient.TakeOrphan(cid, firstSeen)
+		if err != nil {
+			return nil, err
+		}
+		if !taken {
+			c.Log.Debugf("gc: CID[%s] is no longer an orphan", cid)
+			continue
+		}
+		if err := c.Storage.Delete(ctx, cid); err != nil {
+			if _, notFound := err.(storage.NotFoundError); !notFound {
+				c.Log.WithError(err).Errorf("gc: unable to delete CID[%s]", cid)
+				o.Error = err.Error()
+				if err := c.RedisClient.FinishDeletion(cid, false); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+		c.deleteVariants(ctx, cid)
+		if err := c.RedisClient.FinishDeletion(cid, true); err != nil {
+			return nil, err
+		}
+		o.Deleted = true
+		report.Deleted++
+		report.FreedBytes += o.Size
+	}
+	sort.Slice(report.Orphans, func(i, j int) bool { return report.Orphans[i].Cid < report.Orphans[j].Cid })
+
+	if dryRun {
+		return report, nil
+	}
+	if err := c.RedisClient.ClearOrphans(cleared); err != nil {
+		return nil, err
+	}
+	if err := c.RedisClient.SetOrphans(found); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Compressed variants are only stored for some files, missing ones are expected
+func (c *Collector) deleteVariants(ctx context.Context, cid string) {
+	for _, e := range storage.Encodings {
+		key := storage.VariantKey(cid, e)
+		if err := c.Storage.Delete(ctx, key); err != nil {
+			if _, notFound := err.(storage.NotFoundError); !notFound {
+				c.Log.WithError(err).Errorf("gc: unable to delete %s", key)
+			}
+		}
+	}
+}
+
+// Retrieves every file cid referenced by a scene currently deployed
+func (c *Collector) mark(report *Report) (map[string]bool, error) {
+	roots, err := c.RedisClient.GetLiveRootCids()
+	if err != nil {
+		return nil, err
+	}
+	report.LiveScenes = len(roots)
+
+	marked := make(map[string]bool)
+	for _, root := range roots {
+		content, err := c.RedisClient.GetSceneContent(root)
+		if err != nil {
+			return nil, err
+		}
+		for _, cid := range content {
+			marked[cid] = true
+		}
+	}
+	report.Referenced = len(marked)
+	return marked, nil
+}
+
+// Runs a collection every interval until the returned channel is closed
+func (c *Collector) Start(interval time.Duration, dryRun bool) chan<- struct{} {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				report, err := c.Run(context.Background(), dryRun)
+				if err != nil {
+					c.Log.WithError(err).Error("gc: collection failed")
+					continue
+				}
+				c.Log.WithFields(log.Fields{
+					"dry_run":     report.DryRun,
+					"live_scenes": report.LiveScenes,
+					"referenced":  report.Referenced,
+					"orphans":     len(report.Orphans),
+					"deleted":     report.Deleted,
+					"freed_bytes": report.FreedBytes,
+				}).Info("gc: collection finished")
+			}
+		}
+	}()
+	return stop
+}
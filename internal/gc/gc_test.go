@@ -0,0 +1,180 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/decentraland/content-service/data"
+	"github.com/decentraland/content-service/mocks"
+	"github.com/decentraland/content-service/storage"
+	"github.com/golang/mock/gomock"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// Only the methods used by the collector are implemented
+type redisStub struct {
+	data.RedisClient
+	roots    []string
+	content  map[string]map[string]string
+	uploaded []string
+	orphans  map[string]int64
+	refs     map[string][]string
+	removed  []string
+	// Claimed by a deployment after the collector found them
+	claimed  map[string]bool
+	deleting map[string]bool
+}
+
+func (r *redisStub) GetContentReferences(cid string) ([]string, error) { return r.refs[cid], nil }
+
+func (r *redisStub) GetLiveRootCids() ([]string, error) { return r.roots, nil }
+
+func (r *redisStub) GetSceneContent(rootCID string) (map[string]string, error) {
+	return r.content[rootCID], nil
+}
+
+func (r *redisStub) GetUploadedContent() ([]string, error) { return r.uploaded, nil }
+
+func (r *redisStub) GetOrphans() (map[string]int64, error) { return r.orphans, nil }
+
+func (r *redisStub) SetOrphans(orphans map[string]int64) error {
+	for k, v := range orphans {
+		r.orphans[k] = v
+	}
+	return nil
+}
+
+func (r *redisStub) ClearOrphans(cids []string) error {
+	for _, c := range cids {
+		delete(r.orphans, c)
+	}
+	return nil
+}
+
+func (r *redisStub) TakeOrphan(cid string, firstSeen int64) (bool, error) {
+	if r.claimed[cid] || r.orphans[cid] != firstSeen {
+		return false, nil
+	}
+	delete(r.orphans, cid)
+	r.deleting[cid] = true
+	return true, nil
+}
+
+func (r *redisStub) FinishDeletion(cid string, deleted bool) error {
+	delete(r.deleting, cid)
+	if deleted {
+		r.removed = append(r.removed, cid)
+	}
+	return nil
+}
+
+func newRedisStub() *redisStub {
+	old := time.Now().Add(-48 * time.Hour).Unix()
+	return &redisStub{
+		roots: []string{"QmRoot"},
+		content: map[string]map[string]string{
+			"QmRoot": {"scene.json": "QmScene", "game.js": "QmGame"},
+		},
+		uploaded: []string{"QmScene", "QmGame", "QmExpired", "QmRecent", "QmNew", "QmDeploying"},
+		orphans: map[string]int64{
+			"QmExpired": old,
+			"QmRecent":  time.Now().Add(-time.Hour).Unix(),
+			"QmGame":    old,
+		},
+		refs:     map[string][]string{"QmDeploying": {"QmNewRoot"}},
+		claimed:  map[string]bool{},
+		deleting: map[string]bool{},
+	}
+}
+
+func TestRun(t *testing.T) {
+	mockController := gomock.NewController(t)
+	defer mockController.Finish()
+
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+
+	redis := newRedisStub()
+	sto := mocks.NewMockStorage(mockController)
+	sto.EXPECT().Stat(gomock.Any(), "QmExpired").Return(&storage.FileInfo{Cid: "QmExpired", Size: 10}, nil)
+	sto.EXPECT().Delete(gomock.Any(), "QmExpired").Return(nil)
+	sto.EXPECT().Delete(gomock.Any(), "QmExpired.br").Return(nil)
+	sto.EXPECT().Delete(gomock.Any(), "QmExpired.gz").Return(storage.NotFoundError{Cause: "not found"})
+
+	report, err := NewCollector(redis, sto, 24*time.Hour, l).Run(context.Background(), false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.LiveScenes)
+	assert.Equal(t, 2, report.Referenced)
+	assert.Equal(t, 3, len(report.Orphans))
+	assert.Equal(t, 1, report.Deleted)
+	assert.Equal(t, int64(10), report.FreedBytes)
+
+	assert.Equal(t, []string{"QmExpired"}, redis.removed)
+	assert.Empty(t, redis.deleting)
+	assert.NotContains(t, redis.orphans, "QmGame", "referenced files are no longer orphans")
+	assert.Contains(t, redis.orphans, "QmNew", "new orphans wait for the grace period")
+	assert.Contains(t, redis.orphans, "QmRecent")
+}
+
+func TestRunDryRun(t *testing.T) {
+	mockController := gomock.NewController(t)
+	defer mockController.Finish()
+
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+
+	redis := newRedisStub()
+	sto := mocks.NewMockStorage(mockController)
+	sto.EXPECT().Stat(gomock.Any(), "QmExpired").Return(&storage.FileInfo{Cid: "QmExpired", Size: 10}, nil)
+
+	report, err := NewCollector(redis, sto, 24*time.Hour, l).Run(context.Background(), true)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, report.Deleted)
+	for _, o := range report.Orphans {
+		assert.Equal(t, o.Cid == "QmExpired", o.Expired)
+		assert.False(t, o.Deleted)
+	}
+	assert.Empty(t, redis.removed)
+	assert.NotContains(t, redis.orphans, "QmNew")
+	assert.Contains(t, redis.orphans, "QmGame")
+}
+
+func TestRunClaimedOrphan(t *testing.T) {
+	mockController := gomock.NewController(t)
+	defer mockController.Finish()
+
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+
+	redis := newRedisStub()
+	redis.claimed["QmExpired"] = true
+	sto := mocks.NewMockStorage(mockController)
+	sto.EXPECT().Stat(gomock.Any(), "QmExpired").Return(&storage.FileInfo{Cid: "QmExpired", Size: 10}, nil)
+
+	report, err := NewCollector(redis, sto, 24*time.Hour, l).Run(context.Background(), false)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, report.Deleted)
+	assert.Empty(t, redis.removed)
+}
+
+func TestRunFailedDelete(t *testing.T) {
+	mockController := gomock.NewController(t)
+	defer mockController.Finish()
+
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+
+	redis := newRedisStub()
+	sto := mocks.NewMockStorage(mockController)
+	sto.EXPECT().Stat(gomock.Any(), "QmExpired").Return(&storage.FileInfo{Cid: "QmExpired", Size: 10}, nil)
+	sto.EXPECT().Delete(gomock.Any(), "QmExpired").Return(errors.New("unavailable"))
+
+	report, err := NewCollector(redis, sto, 24*time.Hour, l).Run(context.Background(), false)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, report.Deleted)
+	assert.Empty(t, redis.removed)
+	assert.Empty(t, redis.deleting, "the file is uploaded again")
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/decentraland/content-service/storage"
+)
+
+// Smaller files are not worth compressing
+const minCompressibleSize = 1024
+
+// Media types, besides text/*, that compress well. Images, audio and binary models already are compressed
+var compressibleTypes = map[string]bool{
+	"application/javascript":   true,
+	"application/x-javascript": true,
+	"application/json":         true,
+	"application/xml":          true,
+	"application/wasm":         true,
+	"image/svg+xml":            true,
+	"model/gltf+json":          true,
+}
+
+// Extensions of compressible files, used when the upload does not send a meaningful content type
+var compressibleExtensions = map[string]string{
+	".js":   "application/javascript",
+	".json": "application/json",
+	".gltf": "model/gltf+json",
+	".xml":  "application/xml",
+	".svg":  "image/svg+xml",
+	".wasm": "application/wasm",
+	".txt":  "text/plain",
+	".html": "text/html",
+	".css":  "text/css",
+	".obj":  "text/plain",
+	".mtl":  "text/plain",
+}
+
+func isCompressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType]
+}
+
+// Retrieves the content type of the file if it is worth compressing
+func compressibleContentType(f *multipart.FileHeader) (string, bool) {
+	if f.Size < minCompressibleSize {
+		return "", false
+	}
+	if contentType := f.Header.Get("Content-Type"); isCompressibleType(contentType) {
+		return contentType, true
+	}
+	contentType, ok := compressibleExtensions[strings.ToLower(filepath.Ext(f.Filename))]
+	return contentType, ok
+}
+
+// Stores the compressed variants of the file that are noticeably smaller than the original, and records them in the
+// index so the downloads know which ones to offer without asking the storage
+// Variants are an optimization, failing to store them does not fail the upload
+func (us *UploadServiceImpl) storeVariants(ctx context.Context, cid string, f *multipart.FileHeader) {
+	contentType, ok := compressibleContentType(f)
+	if !ok {
+		return
+	}
+	var stored []string
+	for _, e := range storage.Encodings {
+		ok, err := us.storeVariant(ctx, cid, f, contentType, e)
+		if err != nil {
+			us.Log.WithError(err).Warnf("fail to store the %s variant of CID[%s]", e.Name, cid)
+		}
+		if ok {
+			stored = append(stored, e.Name)
+		}
+	}
+	if len(stored) == 0 {
+		return
+	}
+	if err := us.RedisClient.SetVariants(cid, stored); err != nil {
+		us.Log.WithError(err).Warnf("fail to record the variants of CID[%s]", cid)
+	}
+}
+
+// The variant is compressed into a temporary file, its size is only known once complete
+// Retrieves whether the variant was stored
+func (us *UploadServiceImpl) storeVariant(ctx context.Context, cid string, f *multipart.FileHeader, contentType string, e storage.Encoding) (bool, error) {
+	file, err := f.Open()
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	tmp, err := ioutil.TempFile(us.Workdir, "variant-")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w, err := e.NewWriter(tmp)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(w, &contextReader{ctx, file}); err != nil {
+		return false, err
+	}
+	if err := w.Close(); err != nil {
+		return false, err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, err
+	}
+	if size > f.Size*9/10 {
+		us.Log.Debugf("%s variant of CID[%s] skipped, %d bytes out of %d", e.Name, cid, size, f.Size)
+		return false, nil
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	if _, err = us.Storage.SaveFile(storage.VariantKey(cid, e), tmp, contentType); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Picks the stored variant of the content the client prefers, if any
+// Only compressible files have variants, the ones stored are recorded in the index, see storeVariants
+func (ch *contentHandlerImpl) negotiateVariant(c *gin.Context, cid string) (*storage.Encoding, bool) {
+	accepted := acceptedEncodings(c.GetHeader("Accept-Encoding"))
+	if len(accepted) == 0 {
+		return nil, false
+	}
+	stored, err := ch.RedisClient.GetVariants(cid)
+	if err != nil {
+		ch.Log.WithError(err).Warnf("fail to retrieve the variants of CID[%s]", cid)
+		return nil, false
+	}
+	for _, e := range accepted {
+		for _, name := range stored {
+			if name == e.Name {
+				return &e, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Retrieves the stored encodings the Accept-Encoding header allows, following the client preference
+// Encodings with the same weight keep the service preference
+func acceptedEncodings(header string) []storage.Encoding {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		weights[name] = q
+	}
+
+	var ret []storage.Encoding
+	var retWeights []float64
+	for _, e := range storage.Encodings {
+		q, ok := weights[e.Name]
+		if !ok {
+			q, ok = weights["*"]
+		}
+		if !ok || q <= 0 {
+			continue
+		}
+		i := len(ret)
+		for i > 0 && retWeights[i-1] < q {
+			i--
+		}
+		ret = append(ret[:i], append([]storage.Encoding{e}, ret[i:]...)...)
+		retWeights = append(retWeights[:i], append([]float64{q}, retWeights[i:]...)...)
+	}
+	return ret
+}
+
+// ETag of the content served with the encoding, each representation needs its own
+func variantETag(cid string, e *storage.Encoding) string {
+	if e == nil {
+		return fmt.Sprintf("\"%s\"", cid)
+	}
+	return fmt.Sprintf("\"%s-%s\"", cid, e.Name)
+}
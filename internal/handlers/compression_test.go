@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/decentraland/content-service/config"
+	"github.com/decentraland/content-service/metrics"
+	"github.com/decentraland/content-service/storage"
+)
+
+func TestAcceptedEncodings(t *testing.T) {
+	for _, tc := range acceptedEncodingsTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var names []string
+			for _, e := range acceptedEncodings(tc.header) {
+				names = append(names, e.Name)
+			}
+			assert.Equal(t, tc.expected, names)
+		})
+	}
+}
+
+type acceptedEncodingsCase struct {
+	name     string
+	header   string
+	expected []string
+}
+
+var acceptedEncodingsTestCases = []acceptedEncodingsCase{
+	{name: "Empty", header: ""},
+	{name: "Identity only", header: "identity"},
+	{name: "Gzip", header: "gzip, deflate", expected: []string{"gzip"}},
+	{name: "Case insensitive", header: "GZIP", expected: []string{"gzip"}},
+	{name: "Brotli preferred", header: "gzip, deflate, br", expected: []string{"br", "gzip"}},
+	{name: "Wildcard", header: "*", expected: []string{"br", "gzip"}},
+	{name: "Refused", header: "gzip;q=0", expected: nil},
+	{name: "Refused over wildcard", header: "*, gzip;q=0", expected: []string{"br"}},
+	{name: "Weighted", header: "br;q=0.5, gzip;q=0.8", expected: []string{"gzip", "br"}},
+}
+
+func TestStoreVariants(t *testing.T) {
+	dir, err := ioutil.TempDir("", "variants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	workdir, err := ioutil.TempDir("", "workdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+	sto := storage.NewLocal(dir + "/")
+	client := &redisStub{}
+	us := &UploadServiceImpl{Storage: sto, RedisClient: client, Workdir: workdir, Log: l}
+
+	compressible := []byte(strings.Repeat(`{"key": "value"}`, 200))
+	form := multipartForm(t, map[string][]byte{
+		"QmJson.json":  compressible,
+		"QmSmall.json": []byte(`{}`),
+		"QmImage.png":  compressible,
+	})
+	defer form.RemoveAll()
+
+	for cid, files := range form.File {
+		us.storeVariants(context.Background(), cid, files[0])
+	}
+
+	variant, err := ioutil.ReadFile(sto.GetFile("QmJson.json.gz"))
+	assert.Nil(t, err)
+	r, err := gzip.NewReader(bytes.NewReader(variant))
+	assert.Nil(t, err)
+	content, _ := ioutil.ReadAll(r)
+	assert.Equal(t, compressible, content)
+	assert.Equal(t, "application/json", sto.ContentType("QmJson.json.gz"))
+	assert.Equal(t, map[string][]string{"QmJson.json": {"br", "gzip"}}, client.variants, "only the stored variants are recorded")
+
+	variant, err = ioutil.ReadFile(sto.GetFile("QmJson.json.br"))
+	assert.Nil(t, err)
+	content, _ = ioutil.ReadAll(brotli.NewReader(bytes.NewReader(variant)))
+	assert.Equal(t, compressible, content)
+
+	for _, cid := range []string{"QmSmall.json", "QmImage.png"} {
+		for _, suffix := range []string{".gz", ".br"} {
+			_, err := os.Stat(sto.GetFile(cid + suffix))
+			assert.True(t, os.IsNotExist(err), cid+suffix)
+		}
+	}
+	tmp, _ := ioutil.ReadDir(workdir)
+	assert.Empty(t, tmp, "no temporary file is left behind")
+}
+
+func TestGetContentsVariant(t *testing.T) {
+	dir, err := ioutil.TempDir("", "contents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, _ = gz.Write([]byte("compressible text"))
+	_ = gz.Close()
+
+	sto := storage.NewLocal(dir + "/")
+	_, _ = sto.SaveFile(textCid, bytes.NewReader([]byte("compressible text")), "text/plain")
+	_, _ = sto.SaveFile(textCid+".gz", bytes.NewReader(compressed.Bytes()), "text/plain")
+	_, _ = sto.SaveFile(typedCid, bytes.NewReader([]byte("0123456789")), "model/gltf-binary")
+
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+	dummyAgent, _ := metrics.Make(config.Metrics{AppName: "", Enabled: false, AnalyticsKey: ""})
+	handler := NewContentHandler(sto, &redisStub{variants: map[string][]string{textCid: {"gzip"}}}, dummyAgent, config.Storage{}, l)
+	router := gin.New()
+	router.GET("/contents/:cid", handler.GetContents)
+
+	for _, tc := range variantTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serve(router, "GET", "/contents/"+tc.cid, nil, tc.headers)
+			assert.Equal(t, tc.status, w.Code)
+			if tc.body != "" {
+				assert.Equal(t, tc.body, w.Body.String())
+			}
+			for k, v := range tc.expectedHeaders {
+				assert.Equal(t, v, w.Header().Get(k), k)
+			}
+		})
+	}
+
+	w := serve(router, "GET", "/contents/"+textCid, nil, map[string]string{"Accept-Encoding": "gzip"})
+	assert.Equal(t, compressed.Bytes(), w.Body.Bytes())
+}
+
+var variantTestCases = []serveObjectCase{
+	{
+		name:   "Identity",
+		cid:    textCid,
+		status: http.StatusOK,
+		body:   "compressible text",
+		expectedHeaders: map[string]string{
+			"ETag":             `"` + textCid + `"`,
+			"Content-Encoding": "",
+			"Vary":             "Accept-Encoding",
+		},
+	}, {
+		name:    "Gzip",
+		cid:     textCid,
+		headers: map[string]string{"Accept-Encoding": "gzip, deflate"},
+		status:  http.StatusOK,
+		expectedHeaders: map[string]string{
+			"ETag":             `"` + textCid + `-gzip"`,
+			"Content-Encoding": "gzip",
+			"Content-Type":     "text/plain",
+			"Vary":             "Accept-Encoding",
+		},
+	}, {
+		name:            "Gzip refused",
+		cid:             textCid,
+		headers:         map[string]string{"Accept-Encoding": "gzip;q=0"},
+		status:          http.StatusOK,
+		body:            "compressible text",
+		expectedHeaders: map[string]string{"Content-Encoding": ""},
+	}, {
+		name:            "Variant not recorded",
+		cid:             textCid,
+		headers:         map[string]string{"Accept-Encoding": "br"},
+		status:          http.StatusOK,
+		body:            "compressible text",
+		expectedHeaders: map[string]string{"ETag": `"` + textCid + `"`, "Content-Encoding": ""},
+	}, {
+		name:            "No variant stored",
+		cid:             typedCid,
+		headers:         map[string]string{"Accept-Encoding": "gzip"},
+		status:          http.StatusOK,
+		body:            "0123456789",
+		expectedHeaders: map[string]string{"ETag": `"` + typedCid + `"`, "Content-Encoding": ""},
+	}, {
+		name:    "Variant not modified",
+		cid:     textCid,
+		headers: map[string]string{"Accept-Encoding": "gzip", "If-None-Match": `"` + textCid + `-gzip"`},
+		status:  http.StatusNotModified,
+	},
+}
+
+// Builds a form with a file for each entry, named after the key
+func multipartForm(t *testing.T, files map[string][]byte) *multipart.Form {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for name, content := range files {
+		part, _ := w.CreateFormFile(name, name)
+		_, _ = part.Write(content)
+	}
+	_ = w.Close()
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return form
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ipsn/go-ipfs/gxlibs/github.com/ipfs/go-cid"
+	ipld "github.com/ipsn/go-ipfs/gxlibs/github.com/ipfs/go-ipld-format"
+	unixfs "github.com/ipsn/go-ipfs/gxlibs/github.com/ipfs/go-unixfs"
+
+	"github.com/decentraland/content-service/storage"
+)
+
+// CID of a file and the cumulative size of its DAG, which is part of the links pointing to it
+type dagEntry struct {
+	Cid  cid.Cid
+	Size uint64
+}
+
+// Computes the UnixFS DAG of the content with the same parameters `ipfs add` uses by default
+func fileDag(r io.Reader) (*dagEntry, error) {
+	c, size, err := storage.HashFile(r)
+	if err != nil {
+		return nil, err
+	}
+	return &dagEntry{Cid: c, Size: size}, nil
+}
+
+type dagDir struct {
+	files map[string]*dagEntry
+	dirs  map[string]*dagDir
+}
+
+func newDagDir() *dagDir {
+	return &dagDir{files: make(map[string]*dagEntry), dirs: make(map[string]*dagDir)}
+}
+
+// Builds the UnixFS directory DAG of the given tree of files, path -> file
+// The result matches the root CID `ipfs add -r` generates for the same files, so hidden files and
+// directories are left out while the directories containing them are kept
+func dirDag(tree map[string]*dagEntry) (*dagEntry, error) {
+	root := newDagDir()
+	for name, entry := range tree {
+		parts := strings.Split(name, "/")
+		dir := root
+		for i, p := range parts {
+			if strings.HasPrefix(p, ".") {
+				break
+			}
+			if i == len(parts)-1 {
+				dir.files[p] = entry
+				break
+			}
+			child, ok := dir.dirs[p]
+			if !ok {
+				child = newDagDir()
+				dir.dirs[p] = child
+			}
+			dir = child
+		}
+	}
+	return root.node()
+}
+
+func (d *dagDir) node() (*dagEntry, error) {
+	nd := unixfs.EmptyDirNode()
+	for name, entry := range d.files {
+		if _, ok := d.dirs[name]; ok {
+			return nil, InvalidArgument{fmt.Sprintf("%s is both a file and a directory", name)}
+		}
+		if err := nd.AddRawLink(name, &ipld.Link{Name: name, Size: entry.Size, Cid: entry.Cid}); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(d.dirs))
+	for name := range d.dirs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		child, err := d.dirs[name].node()
+		if err != nil {
+			return nil, err
+		}
+		if err := nd.AddRawLink(name, &ipld.Link{Name: name, Size: child.Size, Cid: child.Cid}); err != nil {
+			return nil, err
+		}
+	}
+
+	size, err := nd.Size()
+	if err != nil {
+		return nil, err
+	}
+	return &dagEntry{Cid: nd.Cid(), Size: size}, nil
+}
+
+// Normalizes a manifest file name into a path relative to the scene root
+func dagPath(name string) (string, error) {
+	p := path.Clean("/" + name)[1:]
+	if p == "" || p == "." {
+		return "", InvalidArgument{fmt.Sprintf("invalid file name: %s", name)}
+	}
+	return p, nil
+}
+
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipsn/go-ipfs/core"
+	"github.com/ipsn/go-ipfs/core/coreunix"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirDag(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	node, err := core.NewNode(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	big := make([]byte, 600*1024)
+	rand.New(rand.NewSource(1)).Read(big)
+	files := map[string][]byte{
+		"scene.json":          []byte(`{"main": "game.js"}`),
+		"game.js":             []byte("console.log('hi')"),
+		".hidden":             []byte("hidden"),
+		"empty.txt":           {},
+		"assets/big.bin":      big,
+		"assets/sub/text.txt": []byte("nested"),
+		"a/b/c/d.txt":         []byte("deep"),
+		"only-hidden/.keep":   []byte("keep"),
+		".git/config":         []byte("hidden dir"),
+	}
+
+	workdir, err := ioutil.TempDir("", "dag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+	root := filepath.Join(workdir, "root")
+
+	tree := make(map[string]*dagEntry)
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(p), os.ModePerm))
+		assert.Nil(t, ioutil.WriteFile(p, content, 0644))
+
+		entry, err := fileDag(bytes.NewReader(content))
+		assert.Nil(t, err)
+		expected, err := coreunix.Add(node, bytes.NewReader(content))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, entry.Cid.String(), name)
+		tree[name] = entry
+	}
+
+	expected, err := coreunix.AddR(node, root)
+	assert.Nil(t, err)
+	actual, err := dirDag(tree)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual.Cid.String())
+}
+
+func TestDagPath(t *testing.T) {
+	for _, tc := range dagPathTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := dagPath(tc.value)
+			if tc.expected == "" {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, p)
+		})
+	}
+}
+
+type dagPathCase struct {
+	name     string
+	value    string
+	expected string
+}
+
+var dagPathTestCases = []dagPathCase{
+	{
+		name:     "Plain file",
+		value:    "scene.json",
+		expected: "scene.json",
+	}, {
+		name:     "Nested file",
+		value:    "./assets//model.glb",
+		expected: "assets/model.glb",
+	}, {
+		name:     "Outside the root",
+		value:    "../../etc/passwd",
+		expected: "etc/passwd",
+	}, {
+		name:  "Root",
+		value: "/",
+	},
+}
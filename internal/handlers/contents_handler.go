@@ -1,13 +1,21 @@
 package handlers
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
 
+	"github.com/decentraland/content-service/config"
 	"github.com/decentraland/content-service/data"
+	"github.com/decentraland/content-service/metrics"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/decentraland/content-service/storage"
@@ -16,34 +24,61 @@ import (
 type ContentHandler interface {
 	GetContents(c *gin.Context)
 	CheckContentStatus(c *gin.Context)
+	GetContentReferences(c *gin.Context)
 }
 
 type contentHandlerImpl struct {
 	Storage     storage.Storage
 	RedisClient data.RedisClient
-	Log         *log.Logger
+	Agent       *metrics.Agent
+	// Check the served content still matches its CID
+	VerifyIntegrity bool
+	// Stream the remote content through the service instead of redirecting to the bucket
+	Proxy bool
+	Log   *log.Logger
 }
 
-func NewContentHandler(storage storage.Storage, client data.RedisClient, l *log.Logger) ContentHandler {
+func NewContentHandler(storage storage.Storage, client data.RedisClient, agent *metrics.Agent, conf config.Storage, l *log.Logger) ContentHandler {
 	return &contentHandlerImpl{
-		Storage:     storage,
-		RedisClient: client,
-		Log:         l,
+		Storage:         storage,
+		RedisClient:     client,
+		Agent:           agent,
+		VerifyIntegrity: conf.VerifyIntegrity,
+		Proxy:           conf.RemoteConfig.Proxy || conf.HTTPConfig.Proxy,
+		Log:             l,
 	}
 }
 
+// Stored content is immutable, so it can be cached forever
+const contentCacheControl = "public, max-age=31536000, immutable"
+
 func (ch *contentHandlerImpl) GetContents(c *gin.Context) {
 	cid := c.Param("cid")
+	// Whatever is not a CID could resolve to a shard directory or a temporary file of the storage
+	if err := checkCIDFormat(cid, ch.Log); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	storeValue := ch.Storage.GetFile(cid)
 
-	switch ch.Storage.(type) {
-	case *storage.S3:
+	switch sto := ch.Storage.(type) {
+	case *storage.S3, *storage.HTTPStore, *storage.Tiered, *storage.Replicated:
+		// Local files behind a cache or replicated have no location the client can read
+		if ch.Proxy || !storage.Redirectable(sto) {
+			ch.serveObject(c, sto.(objectGetter), cid)
+			return
+		}
+		if storage.RedirectsVariants(sto) {
+			c.Header("Vary", "Accept-Encoding")
+			if encoding, ok := ch.negotiateVariant(c, cid); ok {
+				storeValue = ch.Storage.GetFile(storage.VariantKey(cid, *encoding))
+			}
+		}
 		c.Writer.Header().Set("Cache-Control", "max-age:31536000, public")
 		c.Redirect(http.StatusMovedPermanently, storeValue)
 	case *storage.Local:
 		if _, err := os.Stat(storeValue); err == nil {
-			c.Writer.Header().Set("Content-Disposition", "Attachment")
-			c.File(storeValue)
+			ch.serveLocalFile(c, ch.Storage.(*storage.Local), cid, storeValue)
 		} else {
 			c.Status(http.StatusNotFound)
 		}
@@ -52,6 +87,172 @@ func (ch *contentHandlerImpl) GetContents(c *gin.Context) {
 	}
 }
 
+// Serves the file with the content type it was uploaded with, or its compressed variant if the client accepts it
+// Conditional and range requests, including multiple ranges, are handled by http.ServeContent
+func (ch *contentHandlerImpl) serveLocalFile(c *gin.Context, sto *storage.Local, cid string, path string) {
+	c.Header("Vary", "Accept-Encoding")
+	key := cid
+	encoding, _ := ch.negotiateVariant(c, cid)
+	if ch.VerifyIntegrity {
+		var ok bool
+		if encoding, ok = ch.verifyLocalContent(c, sto, cid, encoding); !ok {
+			return
+		}
+	}
+	if encoding != nil {
+		key = storage.VariantKey(cid, *encoding)
+		path = sto.GetFile(key)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		ch.Log.WithError(err).Errorf("fail to open CID[%s]", key)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error, try again later"})
+		return
+	}
+	defer f.Close()
+
+	c.Header("ETag", variantETag(cid, encoding))
+	c.Header("Cache-Control", contentCacheControl)
+	contentType := sto.ContentType(cid)
+	if contentType == "" && encoding != nil {
+		contentType = sto.ContentType(key)
+	}
+	if contentType != "" {
+		c.Header("Content-Type", contentType)
+	} else if encoding != nil {
+		// Otherwise ServeContent sniffs the compressed bytes
+		c.Header("Content-Type", "application/octet-stream")
+	}
+	if encoding != nil {
+		c.Header("Content-Encoding", encoding.Name)
+	}
+	http.ServeContent(c.Writer, c.Request, cid, time.Time{}, f)
+}
+
+type objectGetter interface {
+	GetObject(cid string, byteRange string) (*storage.Object, error)
+}
+
+// Streams the stored object, or its compressed variant if the client accepts it
+// The CID, along with the encoding, is used as a strong ETag
+func (ch *contentHandlerImpl) serveObject(c *gin.Context, sto objectGetter, cid string) {
+	c.Header("Vary", "Accept-Encoding")
+	key := cid
+	encoding, ok := ch.negotiateVariant(c, cid)
+	if ok {
+		key = storage.VariantKey(cid, *encoding)
+	}
+	etag := variantETag(cid, encoding)
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Header("ETag", etag)
+		c.Header("Cache-Control", contentCacheControl)
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	byteRange := c.GetHeader("Range")
+	// Multiple ranges are not supported by the bucket, the whole content is sent instead
+	if strings.Contains(byteRange, ",") {
+		byteRange = ""
+	}
+	if ifRange := c.GetHeader("If-Range"); ifRange != "" && ifRange != etag {
+		byteRange = ""
+	}
+
+	obj, err := sto.GetObject(key, byteRange)
+	if err != nil {
+		switch err.(type) {
+		case storage.NotFoundError:
+			c.Status(http.StatusNotFound)
+		case storage.RangeNotSatisfiableError:
+			if size, err := ch.Storage.FileSize(key); err == nil {
+				c.Header("Content-Range", fmt.Sprintf("bytes */%d", size))
+			}
+			c.Status(http.StatusRequestedRangeNotSatisfiable)
+		default:
+			ch.Log.WithError(err).Errorf("fail to retrieve CID[%s]", key)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error, try again later"})
+		}
+		return
+	}
+	defer obj.Body.Close()
+
+	status := http.StatusOK
+	if obj.ContentRange != "" {
+		status = http.StatusPartialContent
+		c.Header("Content-Range", obj.ContentRange)
+	}
+	contentType := obj.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", contentCacheControl)
+	c.Header("Accept-Ranges", "bytes")
+	if encoding != nil {
+		c.Header("Content-Encoding", encoding.Name)
+	}
+	c.DataFromReader(status, obj.ContentLength, contentType, obj.Body, map[string]string{})
+	ch.Agent.RecordBytesRetrieved(obj.ContentLength)
+}
+
+// Retrieves whether the If-None-Match header matches the given ETag
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Hashes the file about to be served, as the status can not be changed once the content starts streaming
+// Files are only hashed again once modified, see storage.Local.Verify
+// A corrupted variant is removed and the original file served instead. A corrupted file is quarantined and removed
+// from the uploaded content, so the next deployment stores it again
+// Retrieves the encoding to serve, or false if the response was already written
+func (ch *contentHandlerImpl) verifyLocalContent(c *gin.Context, sto *storage.Local, cid string, e *storage.Encoding) (*storage.Encoding, bool) {
+	if e != nil {
+		ok, err := sto.Verify(cid, e)
+		if err == nil && ok {
+			return e, true
+		}
+		key := storage.VariantKey(cid, *e)
+		if err != nil {
+			ch.Log.WithError(err).Warnf("fail to verify %s, serving the original file", key)
+		} else {
+			ch.Log.Errorf("Stored %s does not match its CID, removing it", key)
+			ch.Agent.RecordCorruptedContent()
+			if err := sto.Delete(c.Request.Context(), key); err != nil {
+				ch.Log.WithError(err).Errorf("fail to remove %s", key)
+			}
+		}
+	}
+
+	ok, err := sto.Verify(cid, nil)
+	if err != nil {
+		ch.Log.WithError(err).Errorf("fail to hash CID[%s]", cid)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected error, try again later"})
+		return nil, false
+	}
+	if ok {
+		return nil, true
+	}
+
+	ch.Log.Errorf("Stored content does not match CID[%s], moving it to quarantine", cid)
+	ch.Agent.RecordCorruptedContent()
+	if err := sto.Quarantine(cid); err != nil {
+		ch.Log.WithError(err).Errorf("fail to quarantine CID[%s]", cid)
+	}
+	if err := ch.RedisClient.RemoveContent([]string{cid}); err != nil {
+		ch.Log.WithError(err).Errorf("fail to remove CID[%s] from the uploaded content", cid)
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "corrupted content"})
+	return nil, false
+}
+
 type contentStatusRequest struct {
 	Content []string `json:"content" validate:"required"`
 }
@@ -73,7 +274,7 @@ func (ch *contentHandlerImpl) CheckContentStatus(c *gin.Context) {
 		}
 
 		if !uploaded {
-			if uploaded, err = ch.checkContentInStorage(cid); err != nil {
+			if uploaded, err = ch.checkContentInStorage(c.Request.Context(), cid); err != nil {
 				ch.Log.WithError(err).Error("fail to check content")
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "try again later"})
 				return
@@ -84,16 +285,14 @@ func (ch *contentHandlerImpl) CheckContentStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
-func (ch *contentHandlerImpl) checkContentInStorage(cid string) (bool, error) {
-	_, err := ch.Storage.FileSize(cid)
+func (ch *contentHandlerImpl) checkContentInStorage(ctx context.Context, cid string) (bool, error) {
+	exists, err := ch.Storage.Exists(ctx, cid)
 	if err != nil {
-		switch e := err.(type) {
-		case storage.NotFoundError:
-			return false, nil
-		default:
-			log.WithError(err).Errorf("error while reading storage: %s", e.Error())
-			return false, err
-		}
+		log.WithError(err).Errorf("error while reading storage: %s", err.Error())
+		return false, err
+	}
+	if !exists {
+		return false, nil
 	}
 	if err = ch.RedisClient.AddCID(cid); err != nil {
 		log.WithError(err).Error("fail to save into redis")
@@ -101,3 +300,49 @@ func (ch *contentHandlerImpl) checkContentInStorage(cid string) (bool, error) {
 	}
 	return true, nil
 }
+
+type contentReferences struct {
+	Cid     string            `json:"cid"`
+	Scenes  []*sceneReference `json:"scenes"`
+	Parcels int               `json:"parcels"`
+}
+
+type sceneReference struct {
+	RootCid  string   `json:"root_cid"`
+	SceneCid string   `json:"scene_cid"`
+	Parcels  []string `json:"parcels"`
+}
+
+// Retrieves the deployed scenes containing the given file and the parcels they are deployed on
+func (ch *contentHandlerImpl) GetContentReferences(c *gin.Context) {
+	cid := c.Param("cid")
+	roots, err := ch.RedisClient.GetContentReferences(cid)
+	if err != nil {
+		ch.Log.WithError(err).Error("fail to read redis")
+		_ = c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "try again later"})
+		return
+	}
+	sort.Strings(roots)
+
+	resp := &contentReferences{Cid: cid, Scenes: make([]*sceneReference, 0, len(roots))}
+	for _, root := range roots {
+		parcels, err := ch.RedisClient.GetSceneParcels(root)
+		if err != nil {
+			ch.Log.WithError(err).Error("fail to read redis")
+			_ = c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "try again later"})
+			return
+		}
+		sceneCid, err := ch.RedisClient.GetSceneCid(root)
+		if err != nil && err != redis.Nil {
+			ch.Log.WithError(err).Error("fail to read redis")
+			_ = c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "try again later"})
+			return
+		}
+		resp.Scenes = append(resp.Scenes, &sceneReference{RootCid: root, SceneCid: sceneCid, Parcels: parcels})
+		resp.Parcels += len(parcels)
+	}
+	c.JSON(http.StatusOK, resp)
+}
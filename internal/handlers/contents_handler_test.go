@@ -0,0 +1,474 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/golang/mock/gomock"
+
+	"github.com/decentraland/content-service/config"
+	"github.com/decentraland/content-service/data"
+	"github.com/decentraland/content-service/metrics"
+	"github.com/decentraland/content-service/mocks"
+	"github.com/decentraland/content-service/storage"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// Only the methods used by the handlers under test are implemented
+type redisStub struct {
+	data.RedisClient
+	references   map[string][]string
+	sceneParcels map[string][]string
+	sceneCids    map[string]string
+	dagSizes     map[string]uint64
+	variants     map[string][]string
+	uploaded     map[string]bool
+	deleting     map[string]bool
+	removed      []string
+	usage        map[string]*data.Usage
+	parcels      map[string]string
+	processed    map[string]bool
+	scenes       map[string]*data.StoredScene
+	// Batch reads done
+	batches int
+}
+
+func (r *redisStub) RemoveContent(cids []string) error {
+	r.removed = append(r.removed, cids...)
+	return nil
+}
+
+func (r *redisStub) IsContentMember(value string) (bool, error) {
+	return r.uploaded[value], nil
+}
+
+func (r *redisStub) ClaimContent(cid string) (data.ContentState, error) {
+	if r.uploaded[cid] {
+		return data.ContentUploaded, nil
+	}
+	if r.deleting[cid] {
+		return data.ContentDeleting, nil
+	}
+	return data.ContentMissing, nil
+}
+
+func (r *redisStub) GetCumulativeSizes(cids []string) (map[string]uint64, error) {
+	sizes := make(map[string]uint64)
+	for _, c := range cids {
+		if s, ok := r.dagSizes[c]; ok {
+			sizes[c] = s
+		}
+	}
+	return sizes, nil
+}
+
+func (r *redisStub) SetCumulativeSizes(sizes map[string]uint64) error {
+	for c, s := range sizes {
+		r.dagSizes[c] = s
+	}
+	return nil
+}
+
+func (r *redisStub) GetVariants(cid string) ([]string, error) {
+	return r.variants[cid], nil
+}
+
+func (r *redisStub) SetVariants(cid string, encodings []string) error {
+	if r.variants == nil {
+		r.variants = make(map[string][]string)
+	}
+	r.variants[cid] = encodings
+	return nil
+}
+
+func (r *redisStub) GetContentReferences(cid string) ([]string, error) {
+	return r.references[cid], nil
+}
+
+func (r *redisStub) GetSceneParcels(cid string) ([]string, error) {
+	return r.sceneParcels[cid], nil
+}
+
+func (r *redisStub) GetSceneCid(rootCID string) (string, error) {
+	cid, ok := r.sceneCids[rootCID]
+	if !ok {
+		return "", redis.Nil
+	}
+	return cid, nil
+}
+
+func TestGetContentReferences(t *testing.T) {
+	client := &redisStub{
+		references: map[string][]string{"QmFile": {"QmRootB", "QmRootA"}},
+		sceneParcels: map[string][]string{
+			"QmRootA": {"0,0", "0,1"},
+			"QmRootB": {"5,5"},
+		},
+		sceneCids: map[string]string{"QmRootA": "QmSceneA"},
+	}
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+	handler := NewContentHandler(nil, client, nil, config.Storage{}, l)
+
+	router := gin.New()
+	router.GET("/contents/:cid/references", handler.GetContentReferences)
+
+	w := serve(router, "GET", "/contents/QmFile/references", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp contentReferences
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 3, resp.Parcels)
+	assert.Equal(t, []*sceneReference{
+		{RootCid: "QmRootA", SceneCid: "QmSceneA", Parcels: []string{"0,0", "0,1"}},
+		{RootCid: "QmRootB", Parcels: []string{"5,5"}},
+	}, resp.Scenes)
+
+	w = serve(router, "GET", "/contents/QmUnused/references", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 0, resp.Parcels)
+	assert.Empty(t, resp.Scenes)
+}
+
+func TestGetContentsIntegrity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "contents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := []byte("some stored content")
+	entry, _ := fileDag(bytes.NewReader(content))
+	valid := entry.Cid.String()
+	corrupted := "QmbdQuGbRFZdeqmK3PJyLV3m4p2KDELKRS4GfaXyehz672"
+	_ = ioutil.WriteFile(filepath.Join(dir, valid), content, 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, corrupted), content[:5], 0644)
+
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+	dummyAgent, _ := metrics.Make(config.Metrics{AppName: "", Enabled: false, AnalyticsKey: ""})
+	client := &redisStub{}
+	handler := NewContentHandler(storage.NewLocal(dir+"/"), client, dummyAgent, config.Storage{VerifyIntegrity: true}, l)
+
+	router := gin.New()
+	router.GET("/contents/:cid", handler.GetContents)
+
+	w := serve(router, "GET", "/contents/"+valid, nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, content, w.Body.Bytes())
+
+	w = serve(router, "GET", "/contents/not-a-cid", nil, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(router, "GET", "/contents/"+corrupted, nil, nil)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, []string{corrupted}, client.removed)
+	_, err = os.Stat(filepath.Join(dir, ".quarantine", corrupted))
+	assert.Nil(t, err, "the corrupted file is quarantined")
+
+	w = serve(router, "GET", "/contents/"+corrupted, nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, _ = gz.Write(content)
+	_ = gz.Close()
+	gzipped := filepath.Join(dir, valid+".gz")
+	_ = ioutil.WriteFile(gzipped, buf.Bytes(), 0644)
+	w = serve(router, "GET", "/contents/"+valid, nil, map[string]string{"Accept-Encoding": "gzip"})
+	assert.Empty(t, w.Header().Get("Content-Encoding"), "only the recorded variants are offered")
+	client.variants = map[string][]string{valid: {"gzip"}}
+	w = serve(router, "GET", "/contents/"+valid, nil, map[string]string{"Accept-Encoding": "gzip"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, buf.Bytes(), w.Body.Bytes())
+
+	// A corrupted variant is removed and the original file served instead
+	_ = ioutil.WriteFile(gzipped, []byte("not gzip"), 0644)
+	later := time.Now().Add(time.Minute)
+	_ = os.Chtimes(gzipped, later, later)
+	w = serve(router, "GET", "/contents/"+valid, nil, map[string]string{"Accept-Encoding": "gzip"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, content, w.Body.Bytes())
+	_, err = os.Stat(gzipped)
+	assert.True(t, os.IsNotExist(err))
+}
+
+type objectStub struct {
+	content     []byte
+	contentType string
+	ranges      []string
+}
+
+func (o *objectStub) GetObject(cid string, byteRange string) (*storage.Object, error) {
+	o.ranges = append(o.ranges, byteRange)
+	if cid != "QmStored" {
+		return nil, storage.NotFoundError{Cause: "file not found"}
+	}
+	if byteRange == "bytes=100-200" {
+		return nil, storage.RangeNotSatisfiableError{Cause: "invalid range"}
+	}
+	obj := &storage.Object{
+		Body:          ioutil.NopCloser(bytes.NewReader(o.content)),
+		ContentType:   o.contentType,
+		ContentLength: int64(len(o.content)),
+	}
+	if byteRange == "bytes=2-5" {
+		obj.Body = ioutil.NopCloser(bytes.NewReader(o.content[2:6]))
+		obj.ContentLength = 4
+		obj.ContentRange = fmt.Sprintf("bytes 2-5/%d", len(o.content))
+	}
+	return obj, nil
+}
+
+func TestServeObject(t *testing.T) {
+	mockController := gomock.NewController(t)
+	defer mockController.Finish()
+
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+	dummyAgent, _ := metrics.Make(config.Metrics{AppName: "", Enabled: false, AnalyticsKey: ""})
+	sto := mocks.NewMockStorage(mockController)
+	sto.EXPECT().FileSize("QmStored").Return(int64(10), nil).AnyTimes()
+
+	ch := &contentHandlerImpl{Storage: sto, Agent: dummyAgent, Proxy: true, Log: l}
+	obj := &objectStub{content: []byte("0123456789"), contentType: "model/gltf-binary"}
+	router := gin.New()
+	router.GET("/contents/:cid", func(c *gin.Context) { ch.serveObject(c, obj, c.Param("cid")) })
+
+	for _, tc := range serveObjectTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			obj.ranges = nil
+			w := serve(router, "GET", "/contents/"+tc.cid, nil, tc.headers)
+			assert.Equal(t, tc.status, w.Code)
+			assert.Equal(t, tc.body, w.Body.String())
+			for k, v := range tc.expectedHeaders {
+				assert.Equal(t, v, w.Header().Get(k), k)
+			}
+			if tc.requestedRange != nil {
+				assert.Equal(t, []string{*tc.requestedRange}, obj.ranges)
+			}
+		})
+	}
+}
+
+type serveObjectCase struct {
+	name            string
+	cid             string
+	headers         map[string]string
+	status          int
+	body            string
+	expectedHeaders map[string]string
+	requestedRange  *string
+}
+
+var noRange = ""
+
+var serveObjectTestCases = []serveObjectCase{
+	{
+		name:   "Whole content",
+		cid:    "QmStored",
+		status: http.StatusOK,
+		body:   "0123456789",
+		expectedHeaders: map[string]string{
+			"ETag":           `"QmStored"`,
+			"Content-Type":   "model/gltf-binary",
+			"Content-Length": "10",
+			"Accept-Ranges":  "bytes",
+		},
+	}, {
+		name:    "Range",
+		cid:     "QmStored",
+		headers: map[string]string{"Range": "bytes=2-5"},
+		status:  http.StatusPartialContent,
+		body:    "2345",
+		expectedHeaders: map[string]string{
+			"Content-Range":  "bytes 2-5/10",
+			"Content-Length": "4",
+		},
+	}, {
+		name:           "Multiple ranges",
+		cid:            "QmStored",
+		headers:        map[string]string{"Range": "bytes=0-1,4-5"},
+		status:         http.StatusOK,
+		body:           "0123456789",
+		requestedRange: &noRange,
+	}, {
+		name:           "If-Range does not match",
+		cid:            "QmStored",
+		headers:        map[string]string{"Range": "bytes=2-5", "If-Range": `"QmOther"`},
+		status:         http.StatusOK,
+		body:           "0123456789",
+		requestedRange: &noRange,
+	}, {
+		name:            "Range not satisfiable",
+		cid:             "QmStored",
+		headers:         map[string]string{"Range": "bytes=100-200"},
+		status:          http.StatusRequestedRangeNotSatisfiable,
+		expectedHeaders: map[string]string{"Content-Range": "bytes */10"},
+	}, {
+		name:            "Not modified",
+		cid:             "QmStored",
+		headers:         map[string]string{"If-None-Match": `"QmOther", "QmStored"`},
+		status:          http.StatusNotModified,
+		expectedHeaders: map[string]string{"ETag": `"QmStored"`},
+	}, {
+		name:   "Not found",
+		cid:    "QmMissing",
+		status: http.StatusNotFound,
+	},
+}
+
+// CIDs of the content stored by the tests, anything that is not a CID is rejected before reaching the storage
+const (
+	// "0123456789"
+	typedCid = "QmdwoTaJBH7iW2cvDs94iNZBVnJwa12rjNAZ9G9XoHgbij"
+	// "plain text"
+	untypedCid = "QmeZGNbXQEzV7bDdjXWoengGoamTd9RQfA7KaSwFFXBv2B"
+	// "compressible text"
+	textCid    = "QmNkAjXYYYKKd1cgL8arf3wLQttj7a8UJ7YJEh3bTt9KZV"
+	missingCid = "QmYpfyyCfdfdpSbCZv8trxBYTVhew1MosbevAhxXA9y6Pf"
+)
+
+func TestGetContentsLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "contents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sto := storage.NewLocal(dir + "/")
+	_, _ = sto.SaveFile(typedCid, bytes.NewReader([]byte("0123456789")), "model/gltf-binary")
+	_, _ = sto.SaveFile(untypedCid, bytes.NewReader([]byte("plain text")), "")
+
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+	handler := NewContentHandler(sto, &redisStub{}, nil, config.Storage{}, l)
+	router := gin.New()
+	router.GET("/contents/:cid", handler.GetContents)
+
+	for _, tc := range localContentTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serve(router, "GET", "/contents/"+tc.cid, nil, tc.headers)
+			assert.Equal(t, tc.status, w.Code)
+			if tc.body != "" {
+				assert.Equal(t, tc.body, w.Body.String())
+			}
+			for k, v := range tc.expectedHeaders {
+				assert.Contains(t, w.Header().Get(k), v, k)
+			}
+			assert.Empty(t, w.Header().Get("Content-Disposition"))
+		})
+	}
+}
+
+// Without a URL to redirect to, local files behind replication are served even if the proxy is disabled
+func TestGetContentsReplicatedLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "contents")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sto, _ := storage.NewReplicated([]storage.Storage{storage.NewLocal(dir + "/")}, 1, "")
+	_, _ = sto.SaveFile(typedCid, bytes.NewReader([]byte("0123456789")), "model/gltf-binary")
+
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+	dummyAgent, _ := metrics.Make(config.Metrics{AppName: "", Enabled: false, AnalyticsKey: ""})
+	handler := NewContentHandler(sto, &redisStub{}, dummyAgent, config.Storage{}, l)
+	router := gin.New()
+	router.GET("/contents/:cid", handler.GetContents)
+
+	w := serve(router, "GET", "/contents/"+typedCid, nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0123456789", w.Body.String())
+	assert.Equal(t, "model/gltf-binary", w.Header().Get("Content-Type"))
+
+	w = serve(router, "GET", "/contents/"+typedCid, nil, map[string]string{"Range": "bytes=2-5"})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "2345", w.Body.String())
+	assert.Equal(t, "bytes 2-5/10", w.Header().Get("Content-Range"))
+
+	w = serve(router, "GET", "/contents/"+missingCid, nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+var localContentTestCases = []serveObjectCase{
+	{
+		name:   "Whole content",
+		cid:    typedCid,
+		status: http.StatusOK,
+		body:   "0123456789",
+		expectedHeaders: map[string]string{
+			"ETag":           `"` + typedCid + `"`,
+			"Content-Type":   "model/gltf-binary",
+			"Content-Length": "10",
+			"Cache-Control":  "immutable",
+		},
+	}, {
+		name:            "Sniffed content type",
+		cid:             untypedCid,
+		status:          http.StatusOK,
+		body:            "plain text",
+		expectedHeaders: map[string]string{"Content-Type": "text/plain"},
+	}, {
+		name:            "Range",
+		cid:             typedCid,
+		headers:         map[string]string{"Range": "bytes=2-5"},
+		status:          http.StatusPartialContent,
+		body:            "2345",
+		expectedHeaders: map[string]string{"Content-Range": "bytes 2-5/10"},
+	}, {
+		name:            "Multiple ranges",
+		cid:             typedCid,
+		headers:         map[string]string{"Range": "bytes=0-1,4-5"},
+		status:          http.StatusPartialContent,
+		expectedHeaders: map[string]string{"Content-Type": "multipart/byteranges"},
+	}, {
+		name:    "Not modified",
+		cid:     typedCid,
+		headers: map[string]string{"If-None-Match": `"` + typedCid + `"`},
+		status:  http.StatusNotModified,
+	}, {
+		name:    "If-Range mismatch sends the whole content",
+		cid:     typedCid,
+		headers: map[string]string{"Range": "bytes=2-5", "If-Range": `"QmOther"`},
+		status:  http.StatusOK,
+		body:    "0123456789",
+	}, {
+		name:    "Range not satisfiable",
+		cid:     typedCid,
+		headers: map[string]string{"Range": "bytes=100-200"},
+		status:  http.StatusRequestedRangeNotSatisfiable,
+	}, {
+		name:   "Missing content",
+		cid:    missingCid,
+		status: http.StatusNotFound,
+	}, {
+		name:   "Shard directory",
+		cid:    "Qm",
+		status: http.StatusBadRequest,
+	}, {
+		name:   "Variant",
+		cid:    typedCid + ".gz",
+		status: http.StatusBadRequest,
+	}, {
+		name:   "Temporary file",
+		cid:    typedCid + ".tmp",
+		status: http.StatusBadRequest,
+	},
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/decentraland/content-service/data"
+	log "github.com/sirupsen/logrus"
+)
+
+type DeploymentPhase string
+
+const (
+	PhaseParsing               DeploymentPhase = "parsing"
+	PhaseValidatingSignature   DeploymentPhase = "validating_signature"
+	PhaseCheckingAuthorization DeploymentPhase = "checking_authorization"
+	PhaseHashing               DeploymentPhase = "hashing"
+	PhaseStoring               DeploymentPhase = "storing"
+	PhaseIndexing              DeploymentPhase = "indexing"
+	PhaseDone                  DeploymentPhase = "done"
+)
+
+const (
+	// Waiting for a worker
+	DeploymentQueued    = "queued"
+	DeploymentRunning   = "running"
+	DeploymentSucceeded = "succeeded"
+	DeploymentFailed    = "failed"
+)
+
+// Share of the overall progress [from, to) covered by each phase
+var phaseProgressRange = map[DeploymentPhase][2]int{
+	PhaseParsing:               {0, 5},
+	PhaseValidatingSignature:   {5, 10},
+	PhaseCheckingAuthorization: {10, 20},
+	PhaseHashing:               {20, 60},
+	PhaseStoring:               {60, 90},
+	PhaseIndexing:              {90, 100},
+	PhaseDone:                  {100, 100},
+}
+
+// Receives the progress of an upload while it is being processed
+type ProgressTracker interface {
+	// done and total are the units of work of the current phase, total is 0 when unknown
+	Track(phase DeploymentPhase, done int, total int)
+}
+
+type noopTracker struct{}
+
+func (t noopTracker) Track(phase DeploymentPhase, done int, total int) {}
+
+type Deployment struct {
+	ID        string           `json:"id"`
+	RootCid   string           `json:"root_cid"`
+	Publisher string           `json:"publisher"`
+	Status    string           `json:"status"`
+	Phase     DeploymentPhase  `json:"phase"`
+	Progress  int              `json:"progress"`
+	Error     *DeploymentError `json:"error"`
+	Created   int64            `json:"created"`
+	Updated   int64            `json:"updated"`
+}
+
+type DeploymentError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// Keeps track of the asynchronous deployments
+type Deployments struct {
+	RedisClient data.RedisClient
+	// A queued or running deployment without updates for longer than this (seconds) is considered interrupted
+	TimeToLive int64
+	Log        *log.Logger
+}
+
+func NewDeployments(client data.RedisClient, ttl int64, l *log.Logger) *Deployments {
+	return &Deployments{
+		RedisClient: client,
+		TimeToLive:  ttl,
+		Log:         l,
+	}
+}
+
+// Registers a new deployment and retrieves its id
+func (d *Deployments) Create() (string, error) {
+	id := uuid.New().String()
+	now := time.Now().Unix()
+	err := d.RedisClient.StoreDeployment(id, map[string]interface{}{
+		"id":       id,
+		"status":   DeploymentQueued,
+		"phase":    string(PhaseParsing),
+		"progress": 0,
+		"created":  now,
+		"updated":  now,
+	})
+	if err != nil {
+		return "", UnexpectedError{"redis: fail to store deployment", err}
+	}
+	return id, nil
+}
+
+// Records that a worker took the deployment, its TTL starts again
+func (d *Deployments) Start(id string) {
+	d.update(id, map[string]interface{}{
+		"status": DeploymentRunning,
+	})
+}
+
+// Records the information of the parsed upload request
+func (d *Deployments) Parsed(id string, r *UploadRequest) {
+	d.update(id, map[string]interface{}{
+		"root_cid":  r.Metadata.RootCid,
+		"publisher": r.Metadata.PubKey,
+	})
+}
+
+// Retrieves a ProgressTracker that records the progress in the given deployment
+func (d *Deployments) Tracker(id string) ProgressTracker {
+	return &deploymentTracker{deployments: d, id: id, progress: -1}
+}
+
+// Records the result of the deployment
+func (d *Deployments) Finish(id string, err error) {
+	if err != nil {
+		e := classifyDeploymentError(err)
+		d.update(id, map[string]interface{}{
+			"status":     DeploymentFailed,
+			"error_type": e.Type,
+			"error":      e.Message,
+		})
+		return
+	}
+	d.update(id, map[string]interface{}{
+		"status":   DeploymentSucceeded,
+		"phase":    string(PhaseDone),
+		"progress": 100,
+	})
+}
+
+// Retrieves the deployment, nil if it does not exist
+func (d *Deployments) Get(id string) (*Deployment, error) {
+	fields, err := d.RedisClient.GetDeployment(id)
+	if err != nil || fields == nil {
+		return nil, err
+	}
+	dep := &Deployment{
+		ID:        fields["id"],
+		RootCid:   fields["root_cid"],
+		Publisher: fields["publisher"],
+		Status:    fields["status"],
+		Phase:     DeploymentPhase(fields["phase"]),
+	}
+	dep.Progress, _ = strconv.Atoi(fields["progress"])
+	dep.Created, _ = strconv.ParseInt(fields["created"], 10, 64)
+	dep.Updated, _ = strconv.ParseInt(fields["updated"], 10, 64)
+
+	if dep.Status == DeploymentFailed {
+		dep.Error = &DeploymentError{Type: fields["error_type"], Message: fields["error"]}
+	} else if (dep.Status == DeploymentQueued || dep.Status == DeploymentRunning) && time.Now().Unix()-dep.Updated > d.TimeToLive {
+		dep.Status = DeploymentFailed
+		dep.Error = &DeploymentError{Type: "UnexpectedError", Message: "deployment interrupted, try again later"}
+	}
+	return dep, nil
+}
+
+func (d *Deployments) update(id string, fields map[string]interface{}) {
+	fields["updated"] = time.Now().Unix()
+	if err := d.RedisClient.StoreDeployment(id, fields); err != nil {
+		d.Log.WithError(err).Errorf("Unable to update deployment[%s]", id)
+	}
+}
+
+// Files are processed in parallel, so the tracker is called concurrently
+type deploymentTracker struct {
+	deployments *Deployments
+	id          string
+	mutex       sync.Mutex
+	phase       DeploymentPhase
+	progress    int
+}
+
+func (t *deploymentTracker) Track(phase DeploymentPhase, done int, total int) {
+	progress := phaseProgress(phase, done, total)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if phase == t.phase && progress <= t.progress {
+		return
+	}
+	t.phase = phase
+	t.progress = progress
+	t.deployments.update(t.id, map[string]interface{}{
+		"phase":    string(phase),
+		"progress": progress,
+	})
+}
+
+// Retrieves the overall progress percentage given the progress within the phase
+func phaseProgress(phase DeploymentPhase, done int, total int) int {
+	r := phaseProgressRange[phase]
+	if total <= 0 {
+		return r[0]
+	}
+	if done > total {
+		done = total
+	}
+	return r[0] + (r[1]-r[0])*done/total
+}
+
+// Classifies the error using the same types the upload endpoint responds with
+func classifyDeploymentError(err error) *DeploymentError {
+	switch e := err.(type) {
+	case InvalidArgument:
+		return &DeploymentError{Type: "InvalidArgument", Message: e.Error()}
+	case RequiredValueError:
+		return &DeploymentError{Type: "InvalidArgument", Message: e.Error()}
+	case UnauthorizedError:
+		return &DeploymentError{Type: "UnauthorizedError", Message: e.Error()}
+	default:
+		return &DeploymentError{Type: "UnexpectedError", Message: "internal error, try again later"}
+	}
+}
+
+type DeploymentHandler interface {
+	GetDeployment(c *gin.Context)
+}
+
+func NewDeploymentHandler(d *Deployments, l *log.Logger) DeploymentHandler {
+	return &deploymentHandlerImpl{
+		Deployments: d,
+		Log:         l,
+	}
+}
+
+type deploymentHandlerImpl struct {
+	Deployments *Deployments
+	Log         *log.Logger
+}
+
+func (dh *deploymentHandlerImpl) GetDeployment(c *gin.Context) {
+	dep, err := dh.Deployments.Get(c.Param("id"))
+	if err != nil {
+		dh.Log.WithError(err).Error("error reading deployment from redis")
+		_ = c.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unexpected error, try again later"})
+		return
+	}
+	if dep == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "deployment not found"})
+		return
+	}
+	c.JSON(http.StatusOK, dep)
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/decentraland/content-service/data"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPhaseProgress(t *testing.T) {
+	for _, tc := range phaseProgressTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, phaseProgress(tc.phase, tc.done, tc.total))
+		})
+	}
+}
+
+type phaseProgressCase struct {
+	name     string
+	phase    DeploymentPhase
+	done     int
+	total    int
+	expected int
+}
+
+var phaseProgressTestCases = []phaseProgressCase{
+	{
+		name:     "Phase start",
+		phase:    PhaseValidatingSignature,
+		expected: 5,
+	}, {
+		name:     "Half phase",
+		phase:    PhaseHashing,
+		done:     5,
+		total:    10,
+		expected: 40,
+	}, {
+		name:     "Done exceeds total",
+		phase:    PhaseStoring,
+		done:     12,
+		total:    10,
+		expected: 90,
+	}, {
+		name:     "Finished",
+		phase:    PhaseDone,
+		expected: 100,
+	},
+}
+
+func TestClassifyDeploymentError(t *testing.T) {
+	for _, tc := range classifyTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, classifyDeploymentError(tc.err).Type)
+		})
+	}
+}
+
+type classifyCase struct {
+	name     string
+	err      error
+	expected string
+}
+
+var classifyTestCases = []classifyCase{
+	{
+		name:     "Invalid Argument",
+		err:      InvalidArgument{"invalid cid"},
+		expected: "InvalidArgument",
+	}, {
+		name:     "Missing value",
+		err:      RequiredValueError{"missing scene.json"},
+		expected: "InvalidArgument",
+	}, {
+		name:     "Unauthorized",
+		err:      UnauthorizedError{"address is not authorized to modify given parcels"},
+		expected: "UnauthorizedError",
+	}, {
+		name:     "Unexpected",
+		err:      UnexpectedError{"storage error", errors.New("timeout")},
+		expected: "UnexpectedError",
+	}, {
+		name:     "Unknown",
+		err:      errors.New("Signature fails to verify"),
+		expected: "UnexpectedError",
+	},
+}
+
+func TestDeploymentInterrupted(t *testing.T) {
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+	stub := &deploymentsStub{deployments: make(map[string]map[string]string)}
+	d := NewDeployments(stub, 60, l)
+
+	id, err := d.Create()
+	assert.Nil(t, err)
+	dep, err := d.Get(id)
+	assert.Nil(t, err)
+	assert.Equal(t, DeploymentQueued, dep.Status)
+
+	// Waiting for a worker longer than the TTL
+	stub.deployments[id]["updated"] = fmt.Sprint(time.Now().Unix() - 61)
+	dep, _ = d.Get(id)
+	assert.Equal(t, DeploymentFailed, dep.Status)
+
+	// The TTL starts again once a worker takes it
+	d.Start(id)
+	dep, _ = d.Get(id)
+	assert.Equal(t, DeploymentRunning, dep.Status)
+	assert.Nil(t, dep.Error)
+}
+
+type deploymentsStub struct {
+	data.RedisClient
+	deployments map[string]map[string]string
+}
+
+func (s *deploymentsStub) StoreDeployment(id string, fields map[string]interface{}) error {
+	d, ok := s.deployments[id]
+	if !ok {
+		d = make(map[string]string)
+		s.deployments[id] = d
+	}
+	for f, v := range fields {
+		d[f] = fmt.Sprint(v)
+	}
+	return nil
+}
+
+func (s *deploymentsStub) GetDeployment(id string) (map[string]string, error) {
+	return s.deployments[id], nil
+}
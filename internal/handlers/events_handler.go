@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gorilla/websocket"
+
+	"github.com/decentraland/content-service/data"
+	. "github.com/decentraland/content-service/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+type EventsHandler interface {
+	// Streams the deployment events over Server-Sent Events, or over a WebSocket when the request asks for an upgrade
+	GetEvents(c *gin.Context)
+}
+
+// Parcels a subscriber can filter by, a 50x50 rectangle
+const maxEventParcels = 2500
+
+// Events buffered for a slow subscriber before it is disconnected. It can resume from the last event it received
+const eventsBuffer = 64
+
+const (
+	eventsPingInterval = 30 * time.Second
+	eventsWriteTimeout = 10 * time.Second
+)
+
+type eventsHandlerImpl struct {
+	Events   data.DeploymentEvents
+	Log      *log.Logger
+	upgrader websocket.Upgrader
+
+	mu          sync.Mutex
+	subscribers map[*eventSubscriber]bool
+}
+
+type eventSubscriber struct {
+	events chan *data.DeploymentEvent
+	// Closed when the subscriber falls behind
+	dropped chan struct{}
+}
+
+// Subscribes once to the events, every connection is served from that subscription
+func NewEventsHandler(events data.DeploymentEvents, l *log.Logger) (EventsHandler, error) {
+	h := &eventsHandlerImpl{
+		Events: events,
+		Log:    l,
+		upgrader: websocket.Upgrader{
+			// The API is open to any origin
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		subscribers: make(map[*eventSubscriber]bool),
+	}
+	if _, err := events.Subscribe(h.broadcast); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *eventsHandlerImpl) broadcast(e *data.DeploymentEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subscribers {
+		select {
+		case s.events <- e:
+		default:
+			close(s.dropped)
+			delete(h.subscribers, s)
+		}
+	}
+}
+
+func (h *eventsHandlerImpl) subscribe() *eventSubscriber {
+	s := &eventSubscriber{events: make(chan *data.DeploymentEvent, eventsBuffer), dropped: make(chan struct{})}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[s] = true
+	return s
+}
+
+func (h *eventsHandlerImpl) unsubscribe(s *eventSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, s)
+}
+
+type getEventsParams struct {
+	X1        *int   `form:"x1" binding:"omitempty,min=-150,max=150"`
+	Y1        *int   `form:"y1" binding:"omitempty,min=-150,max=150"`
+	X2        *int   `form:"x2" binding:"omitempty,min=-150,max=150"`
+	Y2        *int   `form:"y2" binding:"omitempty,min=-150,max=150"`
+	Publisher string `form:"publisher"`
+	// Also sent by the browsers as the Last-Event-ID header when they reconnect
+	LastEventID *int64 `form:"last_event_id"`
+}
+
+// Events matching every filter set
+type eventFilter struct {
+	parcels   map[string]bool
+	publisher string
+}
+
+func (f *eventFilter) matches(e *data.DeploymentEvent) bool {
+	if f.publisher != "" && f.publisher != strings.ToLower(e.Publisher) {
+		return false
+	}
+	if f.parcels == nil {
+		return true
+	}
+	for _, p := range e.Parcels {
+		if f.parcels[p] {
+			return true
+		}
+	}
+	return false
+}
+
+func parseEventsParams(c *gin.Context) (*eventFilter, *int64, error) {
+	var p getEventsParams
+	if err := c.ShouldBindWith(&p, binding.Query); err != nil {
+		return nil, nil, err
+	}
+	filter := &eventFilter{}
+	rect := []*int{p.X1, p.Y1, p.X2, p.Y2}
+	set := 0
+	for _, v := range rect {
+		if v != nil {
+			set++
+		}
+	}
+	if set != 0 && set != len(rect) {
+		return nil, nil, fmt.Errorf("x1, y1, x2 and y2 are required to filter by parcels")
+	}
+	if set != 0 {
+		parcels := RectToParcels(*p.X1, *p.Y1, *p.X2, *p.Y2, maxEventParcels)
+		if parcels == nil {
+			return nil, nil, fmt.Errorf("too many parcels requested")
+		}
+		filter.parcels = make(map[string]bool, len(parcels))
+		for _, pid := range parcels {
+			filter.parcels[pid] = true
+		}
+	}
+	if p.Publisher != "" {
+		if !addressPattern.MatchString(p.Publisher) {
+			return nil, nil, fmt.Errorf("invalid publisher")
+		}
+		filter.publisher = strings.ToLower(p.Publisher)
+	}
+
+	lastEventID := p.LastEventID
+	if header := c.GetHeader("Last-Event-ID"); header != "" && lastEventID == nil {
+		id, err := strconv.ParseInt(header, 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid Last-Event-ID")
+		}
+		lastEventID = &id
+	}
+	return filter, lastEventID, nil
+}
+
+func (h *eventsHandlerImpl) GetEvents(c *gin.Context) {
+	filter, lastEventID, err := parseEventsParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Subscribed before reading the kept events, so none is lost in between
+	sub := h.subscribe()
+	defer h.unsubscribe(sub)
+
+	var missed []*data.DeploymentEvent
+	var sent int64
+	if lastEventID != nil {
+		sent = *lastEventID
+		missed, err = h.Events.Since(sent)
+		if err != nil {
+			h.Log.WithError(err).Error("error reading the deployment events")
+			_ = c.Error(err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unexpected error, try again later"})
+			return
+		}
+	}
+
+	var w eventWriter
+	ctx := c.Request.Context()
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// The upgrader already replied
+			h.Log.WithError(err).Debug("websocket upgrade failed")
+			return
+		}
+		defer conn.Close()
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+		w = &wsEventWriter{conn: conn}
+		go discardMessages(conn, cancel)
+	} else {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		// Disables the buffering of nginx
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+		w = &sseEventWriter{w: c.Writer}
+	}
+
+	for _, e := range missed {
+		if err := h.send(w, filter, e); err != nil {
+			return
+		}
+		sent = e.ID
+	}
+
+	ticker := time.NewTicker(eventsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case e := <-sub.events:
+			// Already sent from the kept events
+			if e.ID <= sent {
+				continue
+			}
+			sent = e.ID
+			if err := h.send(w, filter, e); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := w.ping(); err != nil {
+				return
+			}
+		case <-sub.dropped:
+			h.Log.Debug("events subscriber fell behind, disconnecting it")
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *eventsHandlerImpl) send(w eventWriter, filter *eventFilter, e *data.DeploymentEvent) error {
+	if !filter.matches(e) {
+		return nil
+	}
+	err := w.write(e)
+	if err != nil {
+		h.Log.WithError(err).Debug("events subscriber disconnected")
+	}
+	return err
+}
+
+type eventWriter interface {
+	write(e *data.DeploymentEvent) error
+	// Keeps the connection alive through proxies
+	ping() error
+}
+
+type sseEventWriter struct {
+	w gin.ResponseWriter
+}
+
+func (s *sseEventWriter) write(e *data.DeploymentEvent) error {
+	msg, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "id: %d\nevent: deployment\ndata: %s\n\n", e.ID, msg); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return nil
+}
+
+func (s *sseEventWriter) ping() error {
+	if _, err := fmt.Fprint(s.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return nil
+}
+
+type wsEventWriter struct {
+	conn *websocket.Conn
+}
+
+func (ws *wsEventWriter) write(e *data.DeploymentEvent) error {
+	if err := ws.conn.SetWriteDeadline(time.Now().Add(eventsWriteTimeout)); err != nil {
+		return err
+	}
+	return ws.conn.WriteJSON(e)
+}
+
+func (ws *wsEventWriter) ping() error {
+	return ws.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventsWriteTimeout))
+}
+
+// Subscribers send nothing, reading is needed to handle the control messages and notice the disconnection
+func discardMessages(conn *websocket.Conn, cancel context.CancelFunc) {
+	defer cancel()
+	for {
+		if _, _, err := conn.NextReader(); err != nil {
+			return
+		}
+	}
+}
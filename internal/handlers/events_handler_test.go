@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/decentraland/content-service/data"
+)
+
+func newEventsServer(t *testing.T, events data.DeploymentEvents) *httptest.Server {
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+	handler, err := NewEventsHandler(events, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.GET("/events", handler.GetEvents)
+	return httptest.NewServer(router)
+}
+
+func deploymentEvent(root string, publisher string, parcels ...string) *data.DeploymentEvent {
+	return &data.DeploymentEvent{RootCid: root, Parcels: parcels, Publisher: publisher, Kind: data.KindDeploy}
+}
+
+func TestEventsSSE(t *testing.T) {
+	events := data.NewLocalEvents(10)
+	server := newEventsServer(t, events)
+	defer server.Close()
+
+	other := "0x0000000000000000000000000000000000000001"
+	_ = events.Publish(deploymentEvent("QmFirst", validTestPubKey, "0,0"))
+	_ = events.Publish(deploymentEvent("QmOther", other, "0,0"))
+	_ = events.Publish(deploymentEvent("QmSecond", validTestPubKey, "0,1"))
+
+	req, _ := http.NewRequest("GET", server.URL+"/events?publisher="+validTestPubKey, nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	received := make(chan *data.DeploymentEvent)
+	go func() {
+		reader := bufio.NewReader(resp.Body)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				close(received)
+				return
+			}
+			if strings.HasPrefix(line, "data: ") {
+				var e data.DeploymentEvent
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err == nil {
+					received <- &e
+				}
+			}
+		}
+	}()
+
+	// The missed event of the publisher is sent first
+	e := nextEvent(t, received)
+	assert.Equal(t, int64(3), e.ID)
+	assert.Equal(t, "QmSecond", e.RootCid)
+
+	_ = events.Publish(deploymentEvent("QmOther", other, "0,0"))
+	_ = events.Publish(deploymentEvent("QmLive", strings.ToLower(validTestPubKey), "0,2"))
+	e = nextEvent(t, received)
+	assert.Equal(t, int64(5), e.ID)
+	assert.Equal(t, "QmLive", e.RootCid)
+}
+
+func TestEventsWebSocket(t *testing.T) {
+	events := data.NewLocalEvents(10)
+	server := newEventsServer(t, events)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/events?x1=0&y1=0&x2=1&y2=1"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	received := make(chan *data.DeploymentEvent)
+	go func() {
+		for {
+			var e data.DeploymentEvent
+			if err := conn.ReadJSON(&e); err != nil {
+				close(received)
+				return
+			}
+			received <- &e
+		}
+	}()
+
+	// Subscribed before the handshake completes
+	_ = events.Publish(deploymentEvent("QmOutside", validTestPubKey, "5,5"))
+	_ = events.Publish(deploymentEvent("QmInside", validTestPubKey, "5,5", "1,1"))
+	e := nextEvent(t, received)
+	assert.Equal(t, int64(2), e.ID)
+	assert.Equal(t, "QmInside", e.RootCid)
+	assert.Equal(t, []string{"5,5", "1,1"}, e.Parcels)
+}
+
+func nextEvent(t *testing.T, received chan *data.DeploymentEvent) *data.DeploymentEvent {
+	select {
+	case e, ok := <-received:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+	}
+	return nil
+}
+
+type eventsParamsCase struct {
+	name   string
+	query  string
+	header string
+	status int
+}
+
+var eventsParamsCases = []eventsParamsCase{
+	{name: "Partial rectangle", query: "x1=0&y1=0&x2=1", status: http.StatusBadRequest},
+	{name: "Coordinate out of range", query: "x1=0&y1=0&x2=1&y2=151", status: http.StatusBadRequest},
+	{name: "Rectangle too big", query: "x1=-100&y1=-100&x2=100&y2=100", status: http.StatusBadRequest},
+	{name: "Invalid publisher", query: "publisher=0x1234", status: http.StatusBadRequest},
+	{name: "Invalid last event id", query: "last_event_id=abc", status: http.StatusBadRequest},
+	{name: "Invalid Last-Event-ID header", header: "abc", status: http.StatusBadRequest},
+}
+
+func TestEventsParams(t *testing.T) {
+	server := newEventsServer(t, data.NewLocalEvents(10))
+	defer server.Close()
+
+	for _, tc := range eventsParamsCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", server.URL+"/events?"+tc.query, nil)
+			if tc.header != "" {
+				req.Header.Set("Last-Event-ID", tc.header)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if !assert.Nil(t, err) {
+				return
+			}
+			resp.Body.Close()
+			assert.Equal(t, tc.status, resp.StatusCode)
+		})
+	}
+}
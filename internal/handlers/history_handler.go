@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/decentraland/content-service/data"
+	"github.com/decentraland/content-service/validation"
+	log "github.com/sirupsen/logrus"
+)
+
+type HistoryHandler interface {
+	GetParcelHistory(c *gin.Context)
+	Rollback(c *gin.Context)
+}
+
+func NewHistoryHandler(client data.RedisClient, rs RollbackService, v validation.Validator, ttl int64, l *log.Logger) HistoryHandler {
+	return &historyHandlerImpl{
+		RedisClient:     client,
+		Service:         rs,
+		StructValidator: v,
+		TimeToLive:      ttl,
+		Log:             l,
+	}
+}
+
+type historyHandlerImpl struct {
+	RedisClient     data.RedisClient
+	Service         RollbackService
+	StructValidator validation.Validator
+	TimeToLive      int64
+	Log             *log.Logger
+}
+
+func (hh *historyHandlerImpl) GetParcelHistory(c *gin.Context) {
+	parcelId, err := parcelFromPath(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parcel coordinates"})
+		return
+	}
+
+	history, err := hh.RedisClient.GetParcelHistory(parcelId)
+	if err != nil {
+		hh.Log.WithError(err).Error("error reading parcel history from redis")
+		_ = c.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unexpected error, try again later"})
+		return
+	}
+
+	// Most recent deployment first
+	ret := make([]*data.DeploymentRecord, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		ret = append(ret, history[i])
+	}
+	c.JSON(http.StatusOK, gin.H{"data": ret})
+}
+
+type rollbackRequest struct {
+	Metadata json.RawMessage `json:"metadata"`
+	Parcels  []string        `json:"parcels"`
+}
+
+// Deploys again a previous root cid of the parcel. The body holds the metadata of the root cid to restore
+// and every parcel of that deployment, signed for the rollback
+func (hh *historyHandlerImpl) Rollback(c *gin.Context) {
+	parcelId, err := parcelFromPath(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parcel coordinates"})
+		return
+	}
+
+	var req rollbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	metadata, err := parseSceneMetadata(string(req.Metadata), hh.StructValidator, hh.Log)
+	if err != nil {
+		abortWithUploadError(c, err)
+		return
+	}
+
+	if hasRequestExpired(&metadata, hh.TimeToLive) {
+		hh.Log.Debug("expired request")
+		abortWithUploadError(c, InvalidArgument{Message: "expired request"})
+		return
+	}
+
+	err = hh.Service.Rollback(&RollbackRequest{Metadata: metadata, Parcel: parcelId, Parcels: req.Parcels, Origin: c.GetHeader("x-upload-origin")})
+	if err != nil {
+		hh.Log.WithError(err).Error("Error rolling back parcel")
+		abortWithUploadError(c, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// Retrieves the parcel id from the :x and :y path params
+func parcelFromPath(c *gin.Context) (string, error) {
+	x, err := parseParcelCoordinate(c.Param("x"))
+	if err != nil {
+		return "", err
+	}
+	y, err := parseParcelCoordinate(c.Param("y"))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d,%d", x, y), nil
+}
+
+func parseParcelCoordinate(v string) (int, error) {
+	c, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, err
+	}
+	if c < -150 || c > 150 {
+		return 0, fmt.Errorf("coordinate out of range: %d", c)
+	}
+	return c, nil
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseParcelCoordinate(t *testing.T) {
+	for _, tc := range parcelCoordinateTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := parseParcelCoordinate(tc.value)
+			if tc.valid {
+				assert.Nil(t, err)
+				assert.Equal(t, tc.expected, c)
+			} else {
+				assert.NotNil(t, err)
+			}
+		})
+	}
+}
+
+type parcelCoordinateCase struct {
+	name     string
+	value    string
+	valid    bool
+	expected int
+}
+
+var parcelCoordinateTestCases = []parcelCoordinateCase{
+	{
+		name:     "Positive",
+		value:    "54",
+		valid:    true,
+		expected: 54,
+	}, {
+		name:     "Negative limit",
+		value:    "-150",
+		valid:    true,
+		expected: -150,
+	}, {
+		name:  "Out of range",
+		value: "151",
+	}, {
+		name:  "Not a number",
+		value: "1a",
+	},
+}
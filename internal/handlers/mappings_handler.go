@@ -126,16 +126,16 @@ func (ms *mappingsHandlerImpl) GetMappings(c *gin.Context) {
 		return
 	}
 
-	mapContents := []ParcelContent{}
+	contents, err := ms.parcelsInformation(parcels)
+	if err != nil {
+		c.Error(err)
+		ms.Log.WithError(err).Error("fail to retrieve parcel")
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "unexpected error, try again later"})
+		return
+	}
+	mapContents := make([]ParcelContent, 0, len(contents))
 	for _, pid := range parcels {
-		content, err := ms.GetParcelInformation(pid)
-		if err != nil {
-			c.Error(err)
-			ms.Log.WithError(err).Error("fail to retrieve parcel")
-			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "unexpected error, try again later"})
-			return
-		}
-		if content != nil {
+		if content, ok := contents[pid]; ok {
 			mapContents = append(mapContents, *content)
 		}
 	}
@@ -164,49 +164,50 @@ func (ms *mappingsHandlerImpl) GetScenes(c *gin.Context) {
 		return
 	}
 
-	cids := make(map[string]bool, len(pids))
+	// Every query reads all the parcels or scenes at once
+	parcelCids, err := ms.RedisClient.GetParcelCIDs(pids)
+	if err != nil {
+		ms.abortWithRedisError(c, err)
+		return
+	}
+	deployed := make([]string, 0, len(parcelCids))
+	for pid := range parcelCids {
+		deployed = append(deployed, pid)
+	}
+	processed, err := ms.RedisClient.ProcessedParcels(deployed)
+	if err != nil {
+		ms.abortWithRedisError(c, err)
+		return
+	}
 
+	seen := make(map[string]bool, len(parcelCids))
+	cids := make([]string, 0, len(parcelCids))
 	for _, pid := range pids {
-		cid, err := ms.RedisClient.GetParcelCID(pid)
-		if cid == "" {
-			continue
-		}
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unexpected error, try again later"})
-			return
-		}
-
-		validParcel, err := ms.RedisClient.ProcessedParcel(pid)
-		if err != nil {
-			log.Errorf("error when checking validity of parcel %s", pid)
-			// skip on error
-		}
-		if !validParcel {
+		cid, ok := parcelCids[pid]
+		if !ok || !processed[pid] || seen[cid] {
 			continue
 		}
+		seen[cid] = true
+		cids = append(cids, cid)
+	}
 
-		cids[cid] = true
+	sceneParcels, err := ms.RedisClient.GetScenesParcels(cids)
+	if err != nil {
+		ms.abortWithRedisError(c, err)
+		return
+	}
+	sceneCids, err := ms.RedisClient.GetSceneCids(cids)
+	if err != nil {
+		ms.Log.WithError(err).Error("error reading scene cids from redis")
+		// we just use the empty string in this case
+		sceneCids = map[string]string{}
 	}
 
 	ret := make([]*Scene, 0, len(cids))
-	for cid, _ := range cids {
-		parcels, err := ms.RedisClient.GetSceneParcels(cid)
-
-		if err != nil && err != redis.Nil {
-			ms.Log.WithError(err).Error("error reading scene from redis")
-			_ = c.Error(err)
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unexpected error, try again later"})
-			return
-		}
-		sceneCID, err := ms.RedisClient.GetSceneCid(cid)
-		if err != nil && err != redis.Nil {
-			log.Errorf("error reading scene cid for cid %s", cid)
-			// we just use the empty string in this case
-		}
-
-		for _, p := range parcels {
+	for _, cid := range cids {
+		for _, p := range sceneParcels[cid] {
 			ret = append(ret, &Scene{
-				SceneCID: sceneCID,
+				SceneCID: sceneCids[cid],
 				RootCID:  cid,
 				ParcelId: p,
 			})
@@ -216,27 +217,60 @@ func (ms *mappingsHandlerImpl) GetScenes(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": ret})
 }
 
+func (ms *mappingsHandlerImpl) abortWithRedisError(c *gin.Context, err error) {
+	ms.Log.WithError(err).Error("error reading scene from redis")
+	_ = c.Error(err)
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unexpected error, try again later"})
+}
+
 /**
 Retrieves the consolidated information of a given Parcel <ParcelContent>
 if the parcel does not exists, the ParcelContent.Contents will be nil
 */
 func (ms *mappingsHandlerImpl) GetParcelInformation(parcelId string) (*ParcelContent, error) {
-	content, err := ms.RedisClient.GetParcelContent(parcelId)
-	if content == nil || err != nil {
+	contents, err := ms.parcelsInformation([]string{parcelId})
+	if err != nil {
 		return nil, err
 	}
+	return contents[parcelId], nil
+}
 
-	var elements []*ContentElement
-
-	for name, cid := range content {
-		elements = append(elements, &ContentElement{File: name, Cid: cid})
+// Retrieves the consolidated information of the given parcels, the ones without a scene are left out
+// The parcels and their scenes are read in two round trips, whatever the amount of parcels
+func (ms *mappingsHandlerImpl) parcelsInformation(pids []string) (map[string]*ParcelContent, error) {
+	parcelCids, err := ms.RedisClient.GetParcelCIDs(pids)
+	if err != nil {
+		return nil, err
 	}
-
-	metadata, err := ms.RedisClient.GetParcelMetadata(parcelId)
-	if metadata == nil || err != nil {
+	seen := make(map[string]bool, len(parcelCids))
+	cids := make([]string, 0, len(parcelCids))
+	for _, cid := range parcelCids {
+		if !seen[cid] {
+			seen[cid] = true
+			cids = append(cids, cid)
+		}
+	}
+	scenes, err := ms.RedisClient.GetScenesIndex(cids)
+	if err != nil {
 		return nil, err
 	}
-	return &ParcelContent{ParcelID: parcelId, Contents: elements, RootCID: metadata["root_cid"].(string), Publisher: metadata["pubkey"].(string)}, nil
+
+	ret := make(map[string]*ParcelContent, len(parcelCids))
+	for pid, cid := range parcelCids {
+		scene, ok := scenes[cid]
+		if !ok {
+			continue
+		}
+		rootCID, _ := scene.Metadata["root_cid"].(string)
+		publisher, _ := scene.Metadata["pubkey"].(string)
+
+		var elements []*ContentElement
+		for name, fileCid := range scene.Content {
+			elements = append(elements, &ContentElement{File: name, Cid: fileCid})
+		}
+		ret[pid] = &ParcelContent{ParcelID: pid, Contents: elements, RootCID: rootCID, Publisher: publisher}
+	}
+	return ret, nil
 }
 
 func (ms *mappingsHandlerImpl) GetInfo(c *gin.Context) {
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/decentraland/content-service/data"
+)
+
+func (r *redisStub) GetParcelCIDs(pids []string) (map[string]string, error) {
+	r.batches++
+	ret := make(map[string]string)
+	for _, p := range pids {
+		if cid, ok := r.parcels[p]; ok {
+			ret[p] = cid
+		}
+	}
+	return ret, nil
+}
+
+func (r *redisStub) ProcessedParcels(pids []string) (map[string]bool, error) {
+	r.batches++
+	ret := make(map[string]bool)
+	for _, p := range pids {
+		ret[p] = r.processed[p]
+	}
+	return ret, nil
+}
+
+func (r *redisStub) GetScenesParcels(cids []string) (map[string][]string, error) {
+	r.batches++
+	ret := make(map[string][]string)
+	for _, c := range cids {
+		if parcels, ok := r.sceneParcels[c]; ok {
+			ret[c] = parcels
+		}
+	}
+	return ret, nil
+}
+
+func (r *redisStub) GetSceneCids(rootCIDs []string) (map[string]string, error) {
+	r.batches++
+	ret := make(map[string]string)
+	for _, c := range rootCIDs {
+		if cid, ok := r.sceneCids[c]; ok {
+			ret[c] = cid
+		}
+	}
+	return ret, nil
+}
+
+func (r *redisStub) GetScenesIndex(rootCIDs []string) (map[string]*data.StoredScene, error) {
+	r.batches++
+	ret := make(map[string]*data.StoredScene)
+	for _, c := range rootCIDs {
+		if s, ok := r.scenes[c]; ok {
+			ret[c] = s
+		}
+	}
+	return ret, nil
+}
+
+// Scene A is deployed on 0,0 and 0,1. Scene B on 1,0, which is not processed yet
+func newMappingsStub() *redisStub {
+	return &redisStub{
+		parcels:   map[string]string{"0,0": "QmRootA", "0,1": "QmRootA", "1,0": "QmRootB"},
+		processed: map[string]bool{"0,0": true, "0,1": true},
+		sceneParcels: map[string][]string{
+			"QmRootA": {"0,0", "0,1"},
+			"QmRootB": {"1,0"},
+		},
+		sceneCids: map[string]string{"QmRootA": "QmSceneA"},
+		scenes: map[string]*data.StoredScene{
+			"QmRootA": {
+				Content:  map[string]string{"scene.json": "QmSceneA"},
+				Metadata: map[string]interface{}{"root_cid": "QmRootA", "pubkey": validTestPubKey},
+			},
+		},
+	}
+}
+
+func TestGetScenes(t *testing.T) {
+	client := newMappingsStub()
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+	handler := NewMappingsHandler(client, nil, nil, l)
+	router := gin.New()
+	router.GET("/scenes", handler.GetScenes)
+
+	w := serve(router, "GET", "/scenes?x1=-5&y1=-5&x2=5&y2=5", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Data []*Scene `json:"data"`
+	}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, []*Scene{
+		{ParcelId: "0,0", RootCID: "QmRootA", SceneCID: "QmSceneA"},
+		{ParcelId: "0,1", RootCID: "QmRootA", SceneCID: "QmSceneA"},
+	}, resp.Data)
+	assert.Equal(t, 4, client.batches, "one read for each query, whatever the amount of parcels")
+}
+
+func TestGetMappings(t *testing.T) {
+	client := newMappingsStub()
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+	handler := NewMappingsHandler(client, nil, nil, l)
+	router := gin.New()
+	router.GET("/mappings", handler.GetMappings)
+
+	w := serve(router, "GET", "/mappings?nw=-5,-5&se=5,5", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp []ParcelContent
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 2, len(resp))
+	for _, p := range resp {
+		assert.Equal(t, "QmRootA", p.RootCID)
+		assert.Equal(t, validTestPubKey, p.Publisher)
+		assert.Equal(t, []*ContentElement{{File: "scene.json", Cid: "QmSceneA"}}, p.Contents)
+	}
+	assert.Equal(t, 2, client.batches, "one read for the parcels and one for their scenes")
+}
@@ -24,7 +24,7 @@ type UploadHandler interface {
 }
 
 func NewUploadHandler(v validation.Validator, us UploadService, a *metrics.Agent, f *ContentTypeFilter,
-	limits config.Limits, ttl int64, l *log.Logger) UploadHandler {
+	limits config.Limits, ttl int64, d *Deployments, l *log.Logger) UploadHandler {
 	return &uploadHandlerImpl{
 		StructValidator: v,
 		Service:         us,
@@ -32,6 +32,8 @@ func NewUploadHandler(v validation.Validator, us UploadService, a *metrics.Agent
 		Filter:          f,
 		Limits:          limits,
 		TimeToLive:      ttl,
+		Deployments:     d,
+		Queue:           newJobQueue(limits.AsyncWorkers, limits.AsyncQueueSize),
 		Log:             l,
 	}
 }
@@ -43,7 +45,10 @@ type uploadHandlerImpl struct {
 	Filter          *ContentTypeFilter
 	Limits          config.Limits
 	TimeToLive      int64
-	Log             *log.Logger
+	Deployments     *Deployments
+	// Asynchronous deployments
+	Queue *jobQueue
+	Log   *log.Logger
 }
 
 type FileMetadata struct {
@@ -106,6 +111,11 @@ func (f *ContentTypeFilter) IsAllowed(t string) bool {
 func (uh *uploadHandlerImpl) UploadContent(c *gin.Context) {
 	sendRequestData(uh.Agent, c.Request, uh.Log)
 
+	if c.Query("async") == "true" {
+		uh.uploadContentAsync(c)
+		return
+	}
+
 	uh.Log.Debug("About to parse Upload request...")
 	tParse := time.Now()
 	uploadRequest, err := uh.parseRequest(c.Request)
@@ -114,15 +124,8 @@ func (uh *uploadHandlerImpl) UploadContent(c *gin.Context) {
 
 	if err != nil {
 		uh.Log.WithError(err).Error("Error parsing upload")
-		switch e := err.(type) {
-		case InvalidArgument:
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": e.Error()})
-			return
-		default:
-			_ = c.Error(err)
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error, try again later"})
-			return
-		}
+		abortWithUploadError(c, err)
+		return
 	}
 
 	tProcess := time.Now()
@@ -131,23 +134,84 @@ func (uh *uploadHandlerImpl) UploadContent(c *gin.Context) {
 
 	if err != nil {
 		uh.Log.WithError(err).Error("Error parsing upload")
-		switch e := err.(type) {
-		case InvalidArgument:
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": e.Error()})
-			return
-		case UnauthorizedError:
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": e.Error()})
-			return
-		default:
-			_ = c.Error(err)
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error, try again later"})
-			return
-		}
+		abortWithUploadError(c, err)
+		return
 	}
 
 	c.Status(http.StatusOK)
 }
 
+// Receives the request content and processes it in background
+// The client can follow the deployment through GET /deployments/:id
+// Deployments running when the service stops are lost, they are reported as failed once their TTL passes
+func (uh *uploadHandlerImpl) uploadContentAsync(c *gin.Context) {
+	// Rejected before receiving the content
+	if !uh.Queue.reserve() {
+		uh.Log.Warn("Async deployment rejected, the queue is full")
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "too many deployments in progress, try again later"})
+		return
+	}
+
+	uh.Log.Debug("About to receive async Upload request...")
+	if err := c.Request.ParseMultipartForm(0); err != nil {
+		uh.Queue.release()
+		uh.Log.WithError(err).Error("Invalid UploadContent request")
+		abortWithUploadError(c, UnexpectedError{"error parsing request form", err})
+		return
+	}
+	// The form is detached from the request, otherwise its files are removed once the handler returns
+	form := c.Request.MultipartForm
+	c.Request.MultipartForm = nil
+	origin := c.GetHeader("x-upload-origin")
+
+	id, err := uh.Deployments.Create()
+	if err != nil {
+		uh.Queue.release()
+		_ = form.RemoveAll()
+		uh.Log.WithError(err).Error("Error creating deployment")
+		abortWithUploadError(c, err)
+		return
+	}
+
+	uh.Queue.run(func() {
+		defer form.RemoveAll()
+		uh.Deployments.Start(id)
+
+		tParse := time.Now()
+		uploadRequest, err := uh.parseForm(form, origin)
+		uh.Agent.RecordUploadRequestParseTime(time.Since(tParse))
+
+		if err == nil {
+			uh.Deployments.Parsed(id, uploadRequest)
+			uploadRequest.Tracker = uh.Deployments.Tracker(id)
+
+			tProcess := time.Now()
+			err = uh.Service.ProcessUpload(uploadRequest)
+			uh.Agent.RecordUploadProcessTime(time.Since(tProcess))
+		}
+
+		if err != nil {
+			uh.Log.WithError(err).Errorf("Deployment[%s] failed", id)
+		}
+		uh.Deployments.Finish(id, err)
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{"id": id})
+}
+
+// Translates the errors retrieved while parsing or processing an upload into the http response
+func abortWithUploadError(c *gin.Context, err error) {
+	switch e := err.(type) {
+	case InvalidArgument:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+	case UnauthorizedError:
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": e.Error()})
+	default:
+		_ = c.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error, try again later"})
+	}
+}
+
 // Extracts all the information from the http request
 // If any part is missing or is invalid it will retrieve an error
 func (c *uploadHandlerImpl) parseRequest(r *http.Request) (*UploadRequest, error) {
@@ -156,8 +220,12 @@ func (c *uploadHandlerImpl) parseRequest(r *http.Request) (*UploadRequest, error
 		c.Log.WithError(err).Error("Invalid UploadContent request")
 		return nil, UnexpectedError{"error parsing request form", err}
 	}
+	return c.parseForm(r.MultipartForm, r.Header.Get("x-upload-origin"))
+}
 
-	metadata, err := getMetadata(r, c.StructValidator, c.Log)
+// Extracts all the information from the multipart form of an upload request
+func (c *uploadHandlerImpl) parseForm(form *multipart.Form, origin string) (*UploadRequest, error) {
+	metadata, err := getMetadata(form, c.StructValidator, c.Log)
 	if err != nil {
 		return nil, err
 	}
@@ -167,16 +235,21 @@ func (c *uploadHandlerImpl) parseRequest(r *http.Request) (*UploadRequest, error
 		return nil, InvalidArgument{Message: "expired request"}
 	}
 
-	manifestContent, err := getManifestContent(r, c.StructValidator, metadata.RootCid, c.Log)
+	manifestContent, err := getManifestContent(form, c.StructValidator, metadata.RootCid, c.Log)
 	if err != nil {
 		return nil, err
 	}
 
+	return c.buildUploadRequest(metadata, manifestContent, form.File, origin)
+}
+
+// Checks the uploaded files against the manifest and the configured limits and assembles the UploadRequest
+func (c *uploadHandlerImpl) buildUploadRequest(metadata Metadata, manifestContent *[]FileMetadata,
+	uploadedFiles map[string][]*multipart.FileHeader, origin string) (*UploadRequest, error) {
 	filesPerScene := c.Limits.ParcelAssetsLimit
 	manifestSize := len(*manifestContent)
 	c.Agent.RecordManifestSize(len(*manifestContent))
 
-	uploadedFiles := r.MultipartForm.File
 	c.Agent.RecordUploadRequestFiles(len(uploadedFiles))
 	if err := validateContentTypes(uploadedFiles, c.Filter); err != nil {
 		return nil, err
@@ -199,7 +272,7 @@ func (c *uploadHandlerImpl) parseRequest(r *http.Request) (*UploadRequest, error
 		return nil, InvalidArgument{Message: fmt.Sprintf("Max Elements per scene exceeded. Max Value: %d, Got: %d", filesPerScene, manifestSize)}
 	}
 
-	request := UploadRequest{Metadata: metadata, Manifest: manifestContent, UploadedFiles: uploadedFiles, Scene: scene, Origin: r.Header.Get("x-upload-origin")}
+	request := UploadRequest{Metadata: metadata, Manifest: manifestContent, UploadedFiles: uploadedFiles, Scene: scene, Origin: origin}
 	err = c.StructValidator.ValidateStruct(request)
 	if err != nil {
 		c.Log.WithError(err).Debug("invalid UploadRequest")
@@ -221,8 +294,8 @@ func validateContentTypes(files map[string][]*multipart.FileHeader, filter *Cont
 }
 
 // Extracts the request Metadata
-func getMetadata(r *http.Request, v validation.Validator, log *log.Logger) (Metadata, error) {
-	metaMultipart, isset := r.MultipartForm.Value["metadata"]
+func getMetadata(form *multipart.Form, v validation.Validator, log *log.Logger) (Metadata, error) {
+	metaMultipart, isset := form.Value["metadata"]
 	if !isset {
 		log.Error("Metadata not  found in UploadRequest")
 		return Metadata{}, RequiredValueError{"missing metadata part in multipart"}
@@ -282,8 +355,8 @@ func parseSceneJsonFile(file io.Reader, v validation.Validator, log *log.Logger)
 }
 
 // Extracts a the list of FileMetadata from the Request
-func getManifestContent(r *http.Request, v validation.Validator, cid string, log *log.Logger) (*[]FileMetadata, error) {
-	filesJSON, isset := r.MultipartForm.Value[cid]
+func getManifestContent(form *multipart.Form, v validation.Validator, cid string, log *log.Logger) (*[]FileMetadata, error) {
+	filesJSON, isset := form.Value[cid]
 	if !isset {
 		log.Debug("Missing content in multipart")
 		return nil, RequiredValueError{"missing content in multipart"}
@@ -689,6 +689,19 @@ var namingTestCases = []namingCase{
 
 type uploadServiceMock struct {
 	uploadedContent map[string]string
+	// Parcels the signer can't modify
+	forbidden []string
+}
+
+func (s *uploadServiceMock) Authorize(m Metadata, parcels []string) error {
+	for _, p := range parcels {
+		for _, f := range s.forbidden {
+			if p == f {
+				return UnauthorizedError{"address is not authorized to modify given parcels"}
+			}
+		}
+	}
+	return nil
 }
 
 func (s *uploadServiceMock) ProcessUpload(r *UploadRequest) error {
@@ -742,3 +755,17 @@ var filterTestCases = []filterCase{
 		expectedResult: true,
 	},
 }
+
+func TestUploadContentAsyncQueueFull(t *testing.T) {
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+	agent, _ := metrics.Make(config.Metrics{AppName: "", Enabled: false, AnalyticsKey: ""})
+	queue := newJobQueue(1, 0)
+	queue.reserve()
+	h := &uploadHandlerImpl{Agent: agent, Queue: queue, Log: l}
+
+	router := gin.New()
+	router.POST("/mappings", h.UploadContent)
+	w := serve(router, "POST", "/mappings?async=true", nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
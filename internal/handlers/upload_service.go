@@ -2,14 +2,16 @@ package handlers
 
 import (
 	"bufio"
+	"context"
 	"fmt"
-	"io"
 	"mime/multipart"
 	"os"
-	"path/filepath"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/decentraland/content-service/config"
 	"github.com/decentraland/content-service/data"
 	"github.com/decentraland/content-service/metrics"
 	"github.com/fatih/structs"
@@ -18,8 +20,6 @@ import (
 
 	"github.com/decentraland/content-service/storage"
 	"github.com/decentraland/content-service/utils/rpc"
-	"github.com/ipsn/go-ipfs/core"
-	"github.com/ipsn/go-ipfs/core/coreunix"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -29,34 +29,63 @@ type UploadRequest struct {
 	UploadedFiles map[string][]*multipart.FileHeader `validate:"required"`
 	Scene         *scene                             `validate:"required"`
 	Origin        string
+	Tracker       ProgressTracker
+}
+
+// Retrieves the tracker of the request progress
+func (r *UploadRequest) tracker() ProgressTracker {
+	if r.Tracker == nil {
+		return noopTracker{}
+	}
+	return r.Tracker
 }
 
 type UploadService interface {
 	ProcessUpload(r *UploadRequest) error
+	// Checks the metadata signature and, when given, that the signer can modify the parcels
+	// Lets a deployment be checked before its files are received
+	Authorize(m Metadata, parcels []string) error
+}
+
+type RollbackRequest struct {
+	Metadata Metadata
+	Parcel   string
+	// Every parcel of the deployment restored, as signed
+	Parcels []string
+	Origin  string
+}
+
+type RollbackService interface {
+	// Deploys again a root cid previously deployed on the parcel
+	Rollback(r *RollbackRequest) error
 }
 
 type UploadServiceImpl struct {
-	Storage         storage.Storage
-	RedisClient     data.RedisClient
-	IpfsNode        *core.IpfsNode
-	Auth            data.Authorization
-	Agent           *metrics.Agent
+	Storage     storage.Storage
+	RedisClient data.RedisClient
+	Auth        data.Authorization
+	Agent       *metrics.Agent
+	// Receives an event for every deployment, nil disables them
+	Events          data.DeploymentEvents
 	ParcelSizeLimit int64
-	Workdir         string
-	rpc             *rpc.RPC
-	Log             *log.Logger
+	// Files hashed, downloaded or stored at the same time
+	Concurrency int
+	Workdir     string
+	rpc         *rpc.RPC
+	Log         *log.Logger
 }
 
-func NewUploadService(storage storage.Storage, client data.RedisClient, node *core.IpfsNode, auth data.Authorization,
-	agent *metrics.Agent, parcelSizeLimit int64, workdir string,
+func NewUploadService(storage storage.Storage, client data.RedisClient, auth data.Authorization,
+	agent *metrics.Agent, events data.DeploymentEvents, limits config.Limits, workdir string,
 	rpc *rpc.RPC, l *log.Logger) *UploadServiceImpl {
 	return &UploadServiceImpl{
 		Storage:         storage,
 		RedisClient:     client,
-		IpfsNode:        node,
 		Auth:            auth,
 		Agent:           agent,
-		ParcelSizeLimit: parcelSizeLimit,
+		Events:          events,
+		ParcelSizeLimit: limits.ParcelSizeLimit,
+		Concurrency:     limits.UploadConcurrency,
 		Workdir:         workdir,
 		rpc:             rpc,
 		Log:             l,
@@ -66,58 +95,186 @@ func NewUploadService(storage storage.Storage, client data.RedisClient, node *co
 func (us *UploadServiceImpl) ProcessUpload(r *UploadRequest) error {
 	us.Log.Debug("Processing Upload request")
 	logUploadRequest(r, us.Log)
+	tracker := r.tracker()
 
+	tracker.Track(PhaseValidatingSignature, 0, 0)
 	if err := us.validateSignature(us.Auth, r.Metadata); err != nil {
 		return err
 	}
 
+	tracker.Track(PhaseCheckingAuthorization, 0, 0)
 	if err := validateKeyAccess(us.Auth, r.Metadata.PubKey, r.Scene.Scene.Parcels, us.Log); err != nil {
 		return err
 	}
 
-	if err := us.validateRequestSize(r); err != nil {
+	if err := us.claimStoredContent(r); err != nil {
 		return err
 	}
 
+	sizes, err := us.validateRequestSize(r)
+	if err != nil {
+		return err
+	}
+
+	tracker.Track(PhaseHashing, 0, len(*r.Manifest))
 	t := time.Now()
-	err := us.validateContentCID(r.UploadedFiles, r.Manifest, r.Metadata.RootCid)
+	err = us.validateContentCID(r.UploadedFiles, r.Manifest, r.Metadata.RootCid, tracker)
 	us.Agent.RecordUploadRequestValidationTime(time.Since(t))
 
 	if err != nil {
 		return err
 	}
 
-	pathsByCid := groupFilePathsByCid(r.Manifest)
-	if err := us.processUploadedFiles(r.UploadedFiles, pathsByCid, r.Metadata.RootCid); err != nil {
+	tracker.Track(PhaseStoring, 0, len(r.UploadedFiles))
+	stored, err := us.processUploadedFiles(r.UploadedFiles, r.Metadata.RootCid, tracker)
+	if err != nil {
 		return err
 	}
 
-	if err := us.storeParcelsInformation(r.Metadata.RootCid, r.Scene.Scene.Parcels); err != nil {
+	tracker.Track(PhaseIndexing, 0, 0)
+	sceneCID, err := us.commitScene(r, deploymentUsage(r.Manifest, sizes, stored))
+	if err != nil {
 		return err
 	}
+	us.publishDeployment(r.Metadata.RootCid, sceneCID, r.Scene.Scene.Parcels, r.Metadata.PubKey, r.Origin, data.KindDeploy)
+
+	pathsByCid := groupFilePathsByCid(r.Manifest)
+	us.Agent.RecordUpload(r.Metadata.RootCid, r.Metadata.PubKey, r.Scene.Scene.Parcels, pathsByCid, r.Origin)
+
+	return nil
+}
+
+func (us *UploadServiceImpl) Rollback(r *RollbackRequest) error {
+	target := r.Metadata.RootCid
+	us.Log.Infof("Rolling back Parcel[%s] to RootCID[%s]", r.Parcel, target)
 
-	if err := us.RedisClient.StoreMetadata(r.Metadata.RootCid, structs.Map(r.Metadata)); err != nil {
-		return UnexpectedError{Message: "fail to store metadata", error: err}
+	current, err := us.RedisClient.GetParcelCID(r.Parcel)
+	if err != nil {
+		return UnexpectedError{"redis: fail to read parcel", err}
+	}
+	if current == target {
+		return InvalidArgument{fmt.Sprintf("root cid %s is already deployed on parcel %s", target, r.Parcel)}
 	}
 
-	sceneCID := ""
-	for _, f := range *r.Manifest {
-		if strings.Contains(f.Name, "scene.json") {
-			sceneCID = f.Cid
-			break
-		}
+	parcelHistory, err := us.RedisClient.GetParcelHistory(r.Parcel)
+	if err != nil {
+		return UnexpectedError{"redis: fail to read parcel history", err}
+	}
+	deployed := false
+	for _, h := range parcelHistory {
+		deployed = deployed || h.RootCid == target
 	}
-	if err := us.RedisClient.SaveRootCidSceneCid(r.Metadata.RootCid, sceneCID); err != nil {
-		return UnexpectedError{Message: "fail to save root cid", error: err} //TODO: we can't recover error from here
+	if !deployed {
+		return InvalidArgument{fmt.Sprintf("root cid %s was never deployed on parcel %s", target, r.Parcel)}
 	}
 
-	us.Agent.RecordUpload(r.Metadata.RootCid, r.Metadata.PubKey, r.Scene.Scene.Parcels, pathsByCid, r.Origin)
+	sceneHistory, err := us.RedisClient.GetSceneHistory(target)
+	if err != nil {
+		return UnexpectedError{"redis: fail to read scene history", err}
+	}
+	if len(sceneHistory) == 0 {
+		return InvalidArgument{fmt.Sprintf("root cid %s has no deployment record", target)}
+	}
+	previous := sceneHistory[len(sceneHistory)-1]
+
+	// The whole deployment is restored, the publisher must know and sign every parcel it takes
+	if !sameParcels(r.Parcels, previous.Parcels) {
+		return InvalidArgument{fmt.Sprintf("the rollback must name every parcel of root cid %s: %s", target, strings.Join(previous.Parcels, ";"))}
+	}
+
+	if err := us.validateRollbackSignature(us.Auth, r.Metadata, previous.Parcels); err != nil {
+		return err
+	}
 
+	if err := validateKeyAccess(us.Auth, r.Metadata.PubKey, previous.Parcels, us.Log); err != nil {
+		return err
+	}
+
+	content, err := us.RedisClient.GetSceneContent(target)
+	if err != nil {
+		return UnexpectedError{"redis: fail to read scene content", err}
+	}
+	// Files of replaced scenes are eventually removed by the garbage collector
+	for _, cid := range content {
+		state, err := us.RedisClient.ClaimContent(cid)
+		if err != nil {
+			return UnexpectedError{"redis: fail to read uploaded content", err}
+		}
+		if state != data.ContentUploaded {
+			return InvalidArgument{fmt.Sprintf("content of root cid %s is no longer available", target)}
+		}
+	}
+
+	err = us.RedisClient.CommitScene(&data.SceneIndex{
+		RootCid:  target,
+		SceneCid: previous.SceneCid,
+		Parcels:  previous.Parcels,
+		Content:  content,
+		Metadata: structs.Map(r.Metadata),
+
+		Publisher: r.Metadata.PubKey,
+		Signature: r.Metadata.Signature,
+		Origin:    r.Origin,
+		Kind:      data.KindRollback,
+	})
+	if err != nil {
+		us.Log.WithError(err).Errorf("Error when rolling back to root cid %s", target)
+		return UnexpectedError{"redis: fail to store scene", err}
+	}
+	us.publishDeployment(target, previous.SceneCid, previous.Parcels, r.Metadata.PubKey, r.Origin, data.KindRollback)
 	return nil
 }
 
+// The deployment is already indexed, subscribers missing the event find it on their next poll of /scenes
+func (us *UploadServiceImpl) publishDeployment(rootCID, sceneCID string, parcels []string, publisher, origin, kind string) {
+	if us.Events == nil {
+		return
+	}
+	err := us.Events.Publish(&data.DeploymentEvent{
+		RootCid:   rootCID,
+		SceneCid:  sceneCID,
+		Parcels:   parcels,
+		Publisher: strings.ToLower(publisher),
+		Timestamp: time.Now().Unix(),
+		Origin:    origin,
+		Kind:      kind,
+	})
+	if err != nil {
+		us.Log.WithError(err).Errorf("Error when publishing the deployment of root cid %s", rootCID)
+	}
+}
+
 // Retrieves an error if the signature is invalid, of if the signature does not corresponds to the given key and message
+func (us *UploadServiceImpl) Authorize(m Metadata, parcels []string) error {
+	if err := us.validateSignature(us.Auth, m); err != nil {
+		return err
+	}
+	if len(parcels) == 0 {
+		return nil
+	}
+	return validateKeyAccess(us.Auth, m.PubKey, parcels, us.Log)
+}
+
 func (us *UploadServiceImpl) validateSignature(a data.Authorization, m Metadata) error {
+	// ERC 1654 wallets sign the value as sent
+	return us.validateMessageSignature(a, m, fmt.Sprintf("%s.%d", m.RootCid, m.Timestamp), fmt.Sprintf("%s.%d", m.Value, m.Timestamp))
+}
+
+// A rollback signs its own message, so the signature of a deployment can't be replayed to restore it
+func (us *UploadServiceImpl) validateRollbackSignature(a data.Authorization, m Metadata, parcels []string) error {
+	msg := rollbackMessage(m.RootCid, parcels, m.Timestamp)
+	return us.validateMessageSignature(a, m, msg, msg)
+}
+
+// Message signed to roll back parcels to a root cid: rollback.<root cid>.<parcels sorted, separated by ;>.<timestamp>
+func rollbackMessage(rootCID string, parcels []string, timestamp int64) string {
+	sorted := append([]string(nil), parcels...)
+	sort.Strings(sorted)
+	return fmt.Sprintf("rollback.%s.%s.%d", rootCID, strings.Join(sorted, ";"), timestamp)
+}
+
+// contractMsg is the message checked for ERC 1654 signatures
+func (us *UploadServiceImpl) validateMessageSignature(a data.Authorization, m Metadata, msg string, contractMsg string) error {
 	us.Log.Debugf("Validating signature: %s", m.Signature)
 
 	// ERC 1654 support https://github.com/ethereum/EIPs/issues/1654
@@ -125,8 +282,7 @@ func (us *UploadServiceImpl) validateSignature(a data.Authorization, m Metadata)
 	if len(m.Signature) > 150 {
 		signature := m.Signature
 		address := m.PubKey
-		msg := fmt.Sprintf("%s.%d", m.Value, m.Timestamp)
-		valid, err := us.rpc.ValidateDapperSignature(address, msg, signature)
+		valid, err := us.rpc.ValidateDapperSignature(address, contractMsg, signature)
 		if err != nil {
 			return err
 		}
@@ -135,43 +291,40 @@ func (us *UploadServiceImpl) validateSignature(a data.Authorization, m Metadata)
 		}
 		return nil
 	}
-	if !a.IsSignatureValid(fmt.Sprintf("%s.%d", m.RootCid, m.Timestamp), m.Signature, m.PubKey) {
+	if !a.IsSignatureValid(msg, m.Signature, m.PubKey) {
 		us.Log.Debugf("Invalid signature[%s] for rootCID[%s] and pubKey[%s]", m.RootCid, m.Signature, m.PubKey)
 		return InvalidArgument{"Signature is invalid"}
 	}
 	return nil
 }
 
-// Retrieves an error if the calculated global CID differs from the expected CID
-func (us *UploadServiceImpl) validateContentCID(requestFiles map[string][]*multipart.FileHeader, manifest *[]FileMetadata, rootCid string) error {
-	us.Log.Debugf("Validating content. RootCID: %s", rootCid)
-	if err := checkCIDFormat(rootCid, us.Log); err != nil {
-		return err
+func sameParcels(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
 	}
-
-	rootDir := filepath.Join(us.Workdir, rootCid)
-	defer cleanUpTmpFile(rootDir, us.Log)
-
-	us.Log.Infof("Consolidating scene content for CID[%s]", rootCid)
-	err := us.consolidateContent(requestFiles, manifest, rootDir)
-	if err != nil {
-		return err
+	set := make(map[string]bool, len(a))
+	for _, p := range a {
+		set[p] = true
 	}
-
-	actualRootCID, err := us.calculateRootCid(rootDir)
-	if err != nil {
-		return UnexpectedError{"", err}
+	for _, p := range b {
+		if !set[p] {
+			return false
+		}
 	}
+	return true
+}
 
-	if rootCid != actualRootCID {
-		return InvalidArgument{"Generated root CID does not match given root CID"}
+// Retrieves an error if the calculated global CID differs from the expected CID
+// The files of the request are hashed while they are read from the form. The files already stored are
+// represented by their CID and the DAG size recorded when they were first hashed, so they are not read again
+func (us *UploadServiceImpl) validateContentCID(requestFiles map[string][]*multipart.FileHeader, manifest *[]FileMetadata, rootCid string, tracker ProgressTracker) error {
+	us.Log.Debugf("Validating content. RootCID: %s", rootCid)
+	if err := checkCIDFormat(rootCid, us.Log); err != nil {
+		return err
 	}
-	return nil
-}
 
-// Consolidate all the scene content under a tmp directory
-func (us *UploadServiceImpl) consolidateContent(requestFiles map[string][]*multipart.FileHeader, manifest *[]FileMetadata, projectTmpFile string) error {
-	us.Log.Debug("Consolidating Content...")
+	// path -> file cid
+	paths := make(map[string]string, len(*manifest))
 	for _, m := range *manifest {
 		us.Log.Debugf("Verifying Manifest File[%s] CID [%s]", m.Name, m.Cid)
 		if strings.HasSuffix(m.Name, "/") {
@@ -181,90 +334,171 @@ func (us *UploadServiceImpl) consolidateContent(requestFiles map[string][]*multi
 			us.Log.Debugf("Invalid CID for fileName[%s] CID [%s]", m.Name, m.Cid)
 			return err
 		}
-
-		tmpFilePath := filepath.Join(projectTmpFile, m.Name)
-
-		var err error
-		if f, ok := requestFiles[m.Cid]; ok {
-			err = saveRequestFile(f[0], tmpFilePath, us.Log)
-		} else {
-			us.Log.Debugf("File[%s] CID [%s] not found in the request content", m.Name, m.Cid)
-			err = us.retrieveContent(m.Cid, tmpFilePath)
-		}
+		name, err := dagPath(m.Name)
 		if err != nil {
 			return err
 		}
-		if err := us.validateCID(tmpFilePath, m.Cid); err != nil {
-			us.Log.Debugf("Failed to validate File[%s] cid: %s", m.Name, err.Error())
-			return err
-		}
+		paths[name] = m.Cid
 	}
-	return nil
-}
 
-func saveRequestFile(f *multipart.FileHeader, projectTmpFile string, log *log.Logger) error {
-	dir := filepath.Dir(projectTmpFile)
-	filePath := filepath.Join(dir, filepath.Base(projectTmpFile))
+	var requested, stored []string
+	seen := make(map[string]bool, len(paths))
+	for _, c := range paths {
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		if _, ok := requestFiles[c]; ok {
+			requested = append(requested, c)
+		} else {
+			us.Log.Debugf("CID [%s] not found in the request content", c)
+			stored = append(stored, c)
+		}
+	}
 
-	err := os.MkdirAll(dir, os.ModePerm)
+	entries, err := us.hashRequestFiles(requestFiles, requested, tracker)
 	if err != nil {
-		log.Errorf("Failed to create directory: %s", dir)
 		return err
 	}
+	hashed := make(map[string]*dagEntry, len(entries))
+	for c, entry := range entries {
+		hashed[c] = entry
+	}
 
-	dst, err := os.Create(filePath)
+	storedEntries, err := us.resolveStoredContent(stored, hashed)
 	if err != nil {
-		log.Errorf("Failed to create file: %s", filePath)
 		return err
 	}
-	defer dst.Close()
+	for c, entry := range storedEntries {
+		entries[c] = entry
+	}
 
-	file, err := f.Open()
+	tree := make(map[string]*dagEntry, len(paths))
+	for name, c := range paths {
+		tree[name] = entries[c]
+	}
+	root, err := dirDag(tree)
 	if err != nil {
-		log.Errorf("Failed to Open file: %s", filePath)
 		return err
 	}
-	defer file.Close()
+	if rootCid != root.Cid.String() {
+		return InvalidArgument{"Generated root CID does not match given root CID"}
+	}
 
-	_, err = io.Copy(dst, file)
-	if err != nil {
-		log.Errorf("Failed to save file: %s", filePath)
-		return err
+	sizes := make(map[string]uint64, len(hashed))
+	for c, entry := range hashed {
+		sizes[c] = entry.Size
+	}
+	if err := us.RedisClient.SetCumulativeSizes(sizes); err != nil {
+		us.Log.WithError(err).Warn("Unable to record the DAG size of the uploaded files")
 	}
 	return nil
 }
 
-// Check if the expectedCID matches the actual CID for a given file
-func (us *UploadServiceImpl) validateCID(f string, expectedCID string) error {
-	us.Log.Debugf("Validating File[%s] CID, expected: %s", f, expectedCID)
-	file, err := os.Open(f)
+// Hashes the given files of the request in parallel, cid -> DAG
+func (us *UploadServiceImpl) hashRequestFiles(requestFiles map[string][]*multipart.FileHeader, cids []string, tracker ProgressTracker) (map[string]*dagEntry, error) {
+	entries := make([]*dagEntry, len(cids))
+	var done int32
+	err := runBatch(us.Concurrency, len(cids), func(ctx context.Context, i int) error {
+		entry, err := us.hashRequestFile(ctx, requestFiles[cids[i]][0], cids[i])
+		if err != nil {
+			us.Log.Debugf("Failed to validate CID[%s]: %s", cids[i], err.Error())
+			return err
+		}
+		entries[i] = entry
+		tracker.Track(PhaseHashing, int(atomic.AddInt32(&done, 1)), len(cids))
+		return nil
+	})
 	if err != nil {
-		us.Log.Debugf("Unable to open File[%s] to calculate CID", f)
-		return InvalidArgument{fmt.Sprintf("Unable to open File[%s] to calculate CID", f)}
+		return nil, err
 	}
-	defer file.Close()
 
-	reader := bufio.NewReader(file)
+	ret := make(map[string]*dagEntry, len(cids))
+	for i, c := range cids {
+		ret[c] = entries[i]
+	}
+	return ret, nil
+}
 
-	actualCID, err := coreunix.Add(us.IpfsNode, reader)
+// Retrieves the DAG of the given files, that are already stored
+// The ones stored before the DAG sizes were recorded are downloaded and added to hashed
+func (us *UploadServiceImpl) resolveStoredContent(cids []string, hashed map[string]*dagEntry) (map[string]*dagEntry, error) {
+	ret := make(map[string]*dagEntry, len(cids))
+	if len(cids) == 0 {
+		return ret, nil
+	}
+	sizes, err := us.RedisClient.GetCumulativeSizes(cids)
 	if err != nil {
+		return nil, UnexpectedError{"redis: fail to read content sizes", err}
+	}
+
+	var unknown []string
+	for _, c := range cids {
+		size, ok := sizes[c]
+		if !ok {
+			unknown = append(unknown, c)
+			continue
+		}
+		decoded, err := cid.Decode(c)
+		if err != nil {
+			return nil, InvalidArgument{fmt.Sprintf("invalid cid: %s", c)}
+		}
+		ret[c] = &dagEntry{Cid: decoded, Size: size}
+	}
+
+	entries := make([]*dagEntry, len(unknown))
+	err = runBatch(us.Concurrency, len(unknown), func(ctx context.Context, i int) error {
+		entry, err := us.hashStoredFile(ctx, unknown[i])
+		entries[i] = entry
 		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	if expectedCID != actualCID {
-		us.Log.Debugf("File[%s] CID does not match expected value: %s", f, expectedCID)
-		return InvalidArgument{fmt.Sprintf("File[%s] CID does not match expected value: %s", f, expectedCID)}
+	for i, c := range unknown {
+		ret[c] = entries[i]
+		hashed[c] = entries[i]
 	}
-	return nil
+	return ret, nil
 }
 
-// Calculate the RootCid for a given set of files
-// rootPath: root folder to group the files
-func (us *UploadServiceImpl) calculateRootCid(rootPath string) (string, error) {
-	rcid, err := coreunix.AddR(us.IpfsNode, rootPath)
+// Hashes a file of the request and checks it matches the expected CID
+func (us *UploadServiceImpl) hashRequestFile(ctx context.Context, f *multipart.FileHeader, expectedCID string) (*dagEntry, error) {
+	file, err := f.Open()
 	if err != nil {
-		return "", err
+		us.Log.Debugf("Unable to open File[%s] to calculate CID", f.Filename)
+		return nil, InvalidArgument{fmt.Sprintf("Unable to open File[%s] to calculate CID", f.Filename)}
 	}
-	return rcid, nil
+	defer file.Close()
+
+	entry, err := fileDag(&contextReader{ctx, file})
+	if err != nil {
+		return nil, UnexpectedError{"fail to calculate cid", err}
+	}
+	if entry.Cid.String() != expectedCID {
+		us.Log.Debugf("File[%s] CID does not match expected value: %s", f.Filename, expectedCID)
+		return nil, InvalidArgument{fmt.Sprintf("File[%s] CID does not match expected value: %s", f.Filename, expectedCID)}
+	}
+	return entry, nil
+}
+
+// Streams and hashes a stored file whose DAG size is unknown
+func (us *UploadServiceImpl) hashStoredFile(ctx context.Context, c string) (*dagEntry, error) {
+	file, _, err := us.Storage.Open(ctx, c)
+	if err != nil {
+		return nil, handleStorageError(err, c, us.Log)
+	}
+	defer file.Close()
+
+	entry, err := fileDag(&contextReader{ctx, bufio.NewReader(file)})
+	if err != nil {
+		return nil, UnexpectedError{"fail to calculate cid", err}
+	}
+	if entry.Cid.String() != c {
+		us.Log.Errorf("Stored content does not match its CID[%s]", c)
+		return nil, UnexpectedError{"storage error", fmt.Errorf("stored content does not match cid %s", c)}
+	}
+	return entry, nil
 }
 
 // Retrieves an error if the given pKey does not have permissions to modify the parcels
@@ -281,116 +515,222 @@ func validateKeyAccess(a data.Authorization, pKey string, parcels []string, log
 	return nil
 }
 
-func (us *UploadServiceImpl) processUploadedFiles(fh map[string][]*multipart.FileHeader, paths map[string][]string, cid string) error {
+// Retrieves the file cids written to the storage, the ones already stored are left out
+func (us *UploadServiceImpl) processUploadedFiles(fh map[string][]*multipart.FileHeader, cid string, tracker ProgressTracker) (map[string]bool, error) {
 	us.Log.Infof("Processing  new content for RootCID[%s]. New files: %d", cid, len(fh))
-	for fileCID, fileHeaders := range fh {
-		fileHeader := fileHeaders[0]
-		us.Log.Debugf("Processing file[%s], CID[%s]", fileHeader.Filename, fileCID)
+	cids := make([]string, 0, len(fh))
+	for fileCID := range fh {
+		cids = append(cids, fileCID)
+	}
 
-		// This anonymous function would allow the defers to work properly
-		// preventing resources from being piled up
-		err := func() error {
-			file, err := fileHeader.Open()
-			if err != nil {
-				us.Log.Errorf("Failed to open file[%s] fileCID[%s]", fileHeader.Filename, fileCID)
-				return UnexpectedError{"fail to open file", err}
-			}
-			defer file.Close()
+	var stored int32
+	written := make([]bool, len(cids))
+	err := runBatch(us.Concurrency, len(cids), func(ctx context.Context, i int) error {
+		fileCID := cids[i]
+		fileHeader := fh[fileCID][0]
+		us.Log.Debugf("Processing file[%s], CID[%s]", fileHeader.Filename, fileCID)
 
-			_, err = us.Storage.SaveFile(fileCID, file, fileHeader.Header.Get("Content-Type"))
-			if err != nil {
-				us.Log.Errorf("Failed to store file[%s] fileCID[%s]", fileHeader.Filename, fileCID)
-				return UnexpectedError{"fail to store file", err}
-			}
-			us.Agent.RecordBytesStored(fileHeader.Size)
-			us.Log.Infof("File[%s] stored successfully under CID[%s]. Bytes stored: %d", fileHeader.Filename, fileCID, fileHeader.Size)
-			return nil
-		}()
+		alreadyStored, err := us.isStored(ctx, fileCID)
 		if err != nil {
-			us.Log.Debugf("Failed to upload file[%s], CID[%s]: %s", fileHeader.Filename, fileCID, err.Error())
 			return err
 		}
-	}
+		if alreadyStored {
+			us.Agent.RecordBytesDeduplicated(fileHeader.Size)
+			us.Log.Debugf("File[%s] CID[%s] already stored, skipping %d bytes", fileHeader.Filename, fileCID, fileHeader.Size)
+			tracker.Track(PhaseStoring, int(atomic.AddInt32(&stored, 1)), len(cids))
+			return nil
+		}
 
-	// Update the content of the parcel with all the files contained in the new scene
-	for fileCID, filePaths := range paths {
-		for _, p := range filePaths {
-			if err := us.RedisClient.StoreContent(cid, p, fileCID); err != nil {
-				return UnexpectedError{"redis: fail to store content", err}
-			}
+		file, err := fileHeader.Open()
+		if err != nil {
+			us.Log.Errorf("Failed to open file[%s] fileCID[%s]", fileHeader.Filename, fileCID)
+			return UnexpectedError{"fail to open file", err}
 		}
-		if err := us.RedisClient.AddCID(fileCID); err != nil {
-			return UnexpectedError{"redis: fail to store file cid", err}
+		defer file.Close()
+
+		_, err = us.Storage.SaveFile(fileCID, &contextReader{ctx, file}, fileHeader.Header.Get("Content-Type"))
+		if err != nil {
+			us.Log.Errorf("Failed to store file[%s] fileCID[%s]", fileHeader.Filename, fileCID)
+			return UnexpectedError{"fail to store file", err}
 		}
+		us.Agent.RecordBytesStored(fileHeader.Size)
+		written[i] = true
+		us.storeVariants(ctx, fileCID, fileHeader)
+		us.Log.Infof("File[%s] stored successfully under CID[%s]. Bytes stored: %d", fileHeader.Filename, fileCID, fileHeader.Size)
+		tracker.Track(PhaseStoring, int(atomic.AddInt32(&stored, 1)), len(cids))
+		return nil
+	})
+	if err != nil {
+		us.Log.Debugf("Failed to upload content of RootCID[%s]: %s", cid, err.Error())
+		return nil, err
 	}
 
+	ret := make(map[string]bool)
+	for i, c := range cids {
+		if written[i] {
+			ret[c] = true
+		}
+	}
 	us.Log.Infof("[Process New Files] New content for RootCID[%s] done", cid)
-	return nil
+	return ret, nil
 }
 
-// Retrieves the specify content by the CID from the storage and saves it into the storePath
-func (us *UploadServiceImpl) retrieveContent(cid string, storePath string) error {
-	err := us.Storage.DownloadFile(cid, storePath)
-	if err != nil {
-		return handleStorageError(err, cid, us.Log)
+// Storage the deployment adds to the usage of its publisher and parcels
+// sizes holds the size of every file in the manifest, stored the ones the deployment wrote
+func deploymentUsage(manifest *[]FileMetadata, sizes map[string]int64, stored map[string]bool) *data.Usage {
+	usage := &data.Usage{Deployments: 1}
+	seen := make(map[string]bool, len(*manifest))
+	for _, f := range *manifest {
+		if strings.HasSuffix(f.Name, "/") || seen[f.Cid] {
+			continue
+		}
+		seen[f.Cid] = true
+		if stored[f.Cid] {
+			usage.Files++
+			usage.Bytes += sizes[f.Cid]
+		} else {
+			usage.SharedFiles++
+			usage.SharedBytes += sizes[f.Cid]
+		}
 	}
-
-	return nil
+	return usage
 }
 
-func (us *UploadServiceImpl) storeParcelsInformation(rootCID string, parcels []string) error {
+// Retrieves whether the content is already in the storage, so it does not need to be written again
+func (us *UploadServiceImpl) isStored(ctx context.Context, cid string) (bool, error) {
+	// Claiming the content keeps the garbage collector from deleting it before the scene is indexed
+	state, err := us.RedisClient.ClaimContent(cid)
+	if err != nil {
+		return false, UnexpectedError{"redis: fail to read uploaded content", err}
+	}
+	switch state {
+	case data.ContentUploaded:
+		return true, nil
+	case data.ContentDeleting:
+		// Writing it now could race with the deletion
+		return false, UnexpectedError{"storage: content is being deleted, try again later", fmt.Errorf("CID[%s] is being deleted", cid)}
+	}
 
-	err := us.RedisClient.SetSceneParcels(rootCID, parcels)
+	// Content stored by a deployment that failed before being indexed
+	exists, err := us.Storage.Exists(ctx, cid)
 	if err != nil {
-		us.Log.WithError(err).Errorf("Error when storing parcels for root cid %s", rootCID)
-		return UnexpectedError{"redis: fail to store parcel cid", err}
+		us.Log.WithError(err).Warnf("Unable to check whether CID[%s] is stored", cid)
+		return false, nil
 	}
+	return exists, nil
+}
 
-	for _, parcel := range parcels {
+// Claims the files of the manifest that are not in the request, so the garbage collector does not delete them
+// before the scene is indexed. Retrieves an error if any of them is being deleted or is not stored
+func (us *UploadServiceImpl) claimStoredContent(r *UploadRequest) error {
+	var cids []string
+	seen := make(map[string]bool, len(*r.Manifest))
+	for _, m := range *r.Manifest {
+		if strings.HasSuffix(m.Name, "/") || seen[m.Cid] {
+			continue
+		}
+		seen[m.Cid] = true
+		if _, uploaded := r.UploadedFiles[m.Cid]; !uploaded {
+			cids = append(cids, m.Cid)
+		}
+	}
+
+	return runBatch(us.Concurrency, len(cids), func(ctx context.Context, i int) error {
+		state, err := us.RedisClient.ClaimContent(cids[i])
+		if err != nil {
+			return UnexpectedError{"redis: fail to read uploaded content", err}
+		}
+		switch state {
+		case data.ContentUploaded:
+			return nil
+		case data.ContentDeleting:
+			return UnexpectedError{"storage: content is being deleted, try again later", fmt.Errorf("CID[%s] is being deleted", cids[i])}
+		}
 
-		err = us.RedisClient.SetProcessedParcel(parcel)
+		// Content stored by a deployment that failed before being indexed, the collector does not know it
+		exists, err := us.Storage.Exists(ctx, cids[i])
 		if err != nil {
-			us.Log.WithError(err).Errorf("Unable to store parcel[%s] ", parcel)
-			return UnexpectedError{"redis: fail to store parcel information", err}
+			return handleStorageError(err, cids[i], us.Log)
+		}
+		if !exists {
+			us.Log.Debugf("file with cid[%s] not found", cids[i])
+			return InvalidArgument{fmt.Sprintf("file: %s not found", cids[i])}
+		}
+		return nil
+	})
+}
+
+// Indexes the parcels, metadata and content of the new scene
+// Everything is written in a single transaction, if it fails the previous scene remains untouched
+// Retrieves the scene cid
+func (us *UploadServiceImpl) commitScene(r *UploadRequest, usage *data.Usage) (string, error) {
+	content := make(map[string]string, len(*r.Manifest))
+	sceneCID := ""
+	for _, f := range *r.Manifest {
+		content[f.Name] = f.Cid
+		if sceneCID == "" && strings.Contains(f.Name, "scene.json") {
+			sceneCID = f.Cid
 		}
 	}
 
-	return err
+	err := us.RedisClient.CommitScene(&data.SceneIndex{
+		RootCid:  r.Metadata.RootCid,
+		SceneCid: sceneCID,
+		Parcels:  r.Scene.Scene.Parcels,
+		Content:  content,
+		Metadata: structs.Map(r.Metadata),
+
+		Publisher: r.Metadata.PubKey,
+		Signature: r.Metadata.Signature,
+		Origin:    r.Origin,
+		Kind:      data.KindDeploy,
+		Usage:     usage,
+	})
+	if err != nil {
+		us.Log.WithError(err).Errorf("Error when storing scene for root cid %s", r.Metadata.RootCid)
+		return "", UnexpectedError{"redis: fail to store scene", err}
+	}
+	return sceneCID, nil
 }
 
-func (us *UploadServiceImpl) validateRequestSize(r *UploadRequest) error {
+// Retrieves the size of each file cid of the request
+func (us *UploadServiceImpl) validateRequestSize(r *UploadRequest) (map[string]int64, error) {
 	maxSize := int64(len(r.Scene.Scene.Parcels)) * us.ParcelSizeLimit
 
-	size, err := us.estimateRequestSize(r)
+	size, sizes, err := us.estimateRequestSize(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if size > maxSize {
-		us.Log.Errorf(fmt.Sprintf("UploadRequest RootCid[%s] exceeds the allowed limit Max[bytes]: %d, RequestSize[bytes]: %d", r.Metadata.RootCid, maxSize, size))
-		return InvalidArgument{fmt.Sprintf("UploadRequest exceeds the allowed limit Max[bytes]: %d, RequestSize[bytes]: %d", maxSize, size)}
+		us.Log.Errorf("UploadRequest RootCid[%s] exceeds the allowed limit Max[bytes]: %d, RequestSize[bytes]: %d", r.Metadata.RootCid, maxSize, size)
+		return nil, InvalidArgument{fmt.Sprintf("UploadRequest exceeds the allowed limit Max[bytes]: %d, RequestSize[bytes]: %d", maxSize, size)}
 	}
-	return nil
+	return sizes, nil
 }
 
-func (us *UploadServiceImpl) estimateRequestSize(r *UploadRequest) (int64, error) {
+func (us *UploadServiceImpl) estimateRequestSize(r *UploadRequest) (int64, map[string]int64, error) {
 	size := int64(0)
+	sizes := make(map[string]int64, len(*r.Manifest))
 	for _, m := range *r.Manifest {
 		if strings.HasSuffix(m.Name, "/") {
 			continue
 		}
-		if f, ok := r.UploadedFiles[m.Cid]; ok {
-			size += f[0].Size
-		} else {
-			s, err := us.retrieveUploadedFileSize(m.Cid)
+		s, ok := sizes[m.Cid]
+		if f, uploaded := r.UploadedFiles[m.Cid]; !ok && uploaded {
+			s = f[0].Size
+		} else if !ok {
+			var err error
+			s, err = us.retrieveUploadedFileSize(m.Cid)
 			if err != nil {
-				return 0, err
+				return 0, nil, err
 			}
-			size += s
 		}
+		sizes[m.Cid] = s
+		size += s
 	}
-	us.Log.Debugf(fmt.Sprintf("UploadRequest size: %d", size))
-	return size, nil
+	us.Log.Debugf("UploadRequest size: %d", size)
+	return size, sizes, nil
 }
 
 func (us *UploadServiceImpl) retrieveUploadedFileSize(cid string) (int64, error) {
@@ -1,9 +1,19 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
 	"testing"
 
+	"github.com/decentraland/content-service/config"
+	"github.com/decentraland/content-service/data"
+	"github.com/decentraland/content-service/metrics"
 	"github.com/decentraland/content-service/mocks"
+	"github.com/decentraland/content-service/storage"
 	"github.com/golang/mock/gomock"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -23,7 +33,7 @@ func TestValidateRequestSize(t *testing.T) {
 
 		us := &UploadServiceImpl{Storage: mockStorage, ParcelSizeLimit: tc.parcelMaxSize, Log: l}
 
-		err := us.validateRequestSize(tc.r)
+		_, err := us.validateRequestSize(tc.r)
 
 		tc.errorsAssertion(t, err)
 	}
@@ -82,3 +92,342 @@ var sizeTestTable = []sizeCase{
 		errorsAssertion: assert.Nil,
 	},
 }
+
+func TestValidateContentCID(t *testing.T) {
+	mockController := gomock.NewController(t)
+	defer mockController.Finish()
+
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+
+	workdir, err := ioutil.TempDir("", "cid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	content := map[string][]byte{
+		"scene.json":        []byte(`{"main": "game.js"}`),
+		"game.js":           []byte("console.log('hi')"),
+		"models/model.glb":  []byte("model"),
+		"models/.gitignore": []byte("*.tmp"),
+	}
+	tree := make(map[string]*dagEntry)
+	cids := make(map[string]string)
+	var manifest []FileMetadata
+	for name, c := range content {
+		entry, _ := fileDag(bytes.NewReader(c))
+		tree[name] = entry
+		cids[name] = entry.Cid.String()
+		manifest = append(manifest, FileMetadata{Name: name, Cid: entry.Cid.String()})
+	}
+	root, _ := dirDag(tree)
+	glbCid := cids["models/model.glb"]
+
+	for _, tc := range validateCIDTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &redisStub{dagSizes: make(map[string]uint64)}
+			sto := mocks.NewMockStorage(mockController)
+
+			var body bytes.Buffer
+			w := multipart.NewWriter(&body)
+			for name, c := range content {
+				if name == "models/model.glb" && tc.storedGlb != noStored {
+					continue
+				}
+				part, _ := w.CreateFormFile(cids[name], name)
+				_, _ = part.Write(c)
+			}
+			_ = w.Close()
+			form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer form.RemoveAll()
+
+			switch tc.storedGlb {
+			case storedKnownSize:
+				client.dagSizes[glbCid] = tree["models/model.glb"].Size
+			case storedUnknownSize:
+				sto.EXPECT().Open(gomock.Any(), glbCid).Return(ioutil.NopCloser(bytes.NewReader(content["models/model.glb"])), &storage.FileInfo{}, nil)
+			}
+
+			rootCid := root.Cid.String()
+			if tc.wrongRoot {
+				rootCid = cids["game.js"]
+			}
+
+			us := &UploadServiceImpl{Storage: sto, RedisClient: client, Concurrency: 4, Workdir: workdir, Log: l}
+			err = us.validateContentCID(form.File, &manifest, rootCid, noopTracker{})
+			if tc.wrongRoot {
+				assert.IsType(t, InvalidArgument{}, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tree["models/model.glb"].Size, client.dagSizes[glbCid], "the DAG size must be recorded")
+		})
+	}
+}
+
+const (
+	noStored = iota
+	storedKnownSize
+	storedUnknownSize
+)
+
+type validateCIDCase struct {
+	name      string
+	storedGlb int
+	wrongRoot bool
+}
+
+var validateCIDTestCases = []validateCIDCase{
+	{
+		name:      "Every file in the request",
+		storedGlb: noStored,
+	}, {
+		name:      "Stored file with known DAG size",
+		storedGlb: storedKnownSize,
+	}, {
+		name:      "Stored file without DAG size",
+		storedGlb: storedUnknownSize,
+	}, {
+		name:      "Root CID does not match",
+		storedGlb: noStored,
+		wrongRoot: true,
+	},
+}
+
+func TestProcessUploadedFiles(t *testing.T) {
+	mockController := gomock.NewController(t)
+	defer mockController.Finish()
+
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+	dummyAgent, _ := metrics.Make(config.Metrics{AppName: "", Enabled: false, AnalyticsKey: ""})
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, c := range []string{"QmIndexed", "QmInBucket", "QmNew"} {
+		part, _ := w.CreateFormFile(c, c+".png")
+		_, _ = part.Write([]byte(c))
+	}
+	_ = w.Close()
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer form.RemoveAll()
+
+	client := &redisStub{uploaded: map[string]bool{"QmIndexed": true}}
+	sto := mocks.NewMockStorage(mockController)
+	sto.EXPECT().Exists(gomock.Any(), "QmInBucket").Return(true, nil)
+	sto.EXPECT().Exists(gomock.Any(), "QmNew").Return(false, nil)
+	sto.EXPECT().SaveFile("QmNew", gomock.Any(), gomock.Any()).Return("QmNew", nil)
+
+	us := &UploadServiceImpl{Storage: sto, RedisClient: client, Agent: dummyAgent, Concurrency: 2, Log: l}
+	stored, err := us.processUploadedFiles(form.File, "QmRoot", noopTracker{})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"QmNew": true}, stored)
+}
+
+func TestIsStoredWhileDeleting(t *testing.T) {
+	mockController := gomock.NewController(t)
+	defer mockController.Finish()
+
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+
+	client := &redisStub{deleting: map[string]bool{"QmDeleting": true}}
+	us := &UploadServiceImpl{Storage: mocks.NewMockStorage(mockController), RedisClient: client, Log: l}
+	stored, err := us.isStored(context.Background(), "QmDeleting")
+	assert.False(t, stored)
+	assert.IsType(t, UnexpectedError{}, err)
+}
+
+func TestClaimStoredContentAgainstCollector(t *testing.T) {
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+	dummyAgent, _ := metrics.Make(config.Metrics{AppName: "", Enabled: false, AnalyticsKey: ""})
+
+	for _, collectorFirst := range []bool{false, true} {
+		t.Run(fmt.Sprintf("collector first %t", collectorFirst), func(t *testing.T) {
+			mockController := gomock.NewController(t)
+			defer mockController.Finish()
+
+			dir, err := ioutil.TempDir("", "index")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			client, err := data.NewLevelDBClient(dir, dummyAgent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			// QmShared belonged to a scene that was replaced, it is an orphan since 100
+			assert.Nil(t, client.CommitScene(&data.SceneIndex{RootCid: "QmOld", Parcels: []string{"0,0"}, Content: map[string]string{"a.png": "QmShared"}}))
+			assert.Nil(t, client.CommitScene(&data.SceneIndex{RootCid: "QmNewer", Parcels: []string{"0,0"}, Content: map[string]string{"b.png": "QmOther"}}))
+			assert.Nil(t, client.SetOrphans(map[string]int64{"QmShared": 100}))
+
+			sto := mocks.NewMockStorage(mockController)
+			us := &UploadServiceImpl{Storage: sto, RedisClient: client, ParcelSizeLimit: 1000, Concurrency: 2, Log: l}
+			r := &UploadRequest{
+				Metadata: Metadata{RootCid: "QmDeploy"},
+				Manifest: &[]FileMetadata{{Cid: "QmShared", Name: "a.png"}},
+				Scene:    &scene{Scene: sceneData{Parcels: []string{"0,1"}}},
+			}
+
+			if collectorFirst {
+				taken, err := client.TakeOrphan("QmShared", 100)
+				assert.Nil(t, err)
+				assert.True(t, taken)
+				assert.IsType(t, UnexpectedError{}, us.claimStoredContent(r), "the file is being deleted")
+				return
+			}
+
+			sto.EXPECT().FileSize("QmShared").DoAndReturn(func(cid string) (int64, error) {
+				// The collector runs while the deployment is being validated
+				taken, err := client.TakeOrphan(cid, 100)
+				assert.Nil(t, err)
+				assert.False(t, taken, "the deployment claimed the file")
+				return 10, nil
+			})
+			assert.Nil(t, us.claimStoredContent(r))
+			_, err = us.validateRequestSize(r)
+			assert.Nil(t, err)
+			state, err := client.ClaimContent("QmShared")
+			assert.Nil(t, err)
+			assert.Equal(t, data.ContentUploaded, state)
+		})
+	}
+}
+
+func TestClaimStoredContentMissing(t *testing.T) {
+	mockController := gomock.NewController(t)
+	defer mockController.Finish()
+
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+
+	sto := mocks.NewMockStorage(mockController)
+	sto.EXPECT().Exists(gomock.Any(), "QmInBucket").Return(true, nil).Times(2)
+	sto.EXPECT().Exists(gomock.Any(), "QmMissing").Return(false, nil)
+
+	us := &UploadServiceImpl{Storage: sto, RedisClient: &redisStub{uploaded: map[string]bool{"QmIndexed": true}}, Log: l}
+	manifest := []FileMetadata{{Cid: "QmIndexed", Name: "a.png"}, {Cid: "QmInBucket", Name: "b.png"}, {Cid: "QmNew", Name: "c.png"}}
+	r := &UploadRequest{Manifest: &manifest, UploadedFiles: map[string][]*multipart.FileHeader{"QmNew": nil}}
+	assert.Nil(t, us.claimStoredContent(r))
+
+	manifest = append(manifest, FileMetadata{Cid: "QmMissing", Name: "d.png"})
+	assert.IsType(t, InvalidArgument{}, us.claimStoredContent(r))
+}
+
+type rollbackStub struct {
+	data.RedisClient
+	current   string
+	history   []*data.DeploymentRecord
+	content   map[string]string
+	committed *data.SceneIndex
+}
+
+func (r *rollbackStub) GetParcelCID(pid string) (string, error) {
+	return r.current, nil
+}
+
+func (r *rollbackStub) GetParcelHistory(pid string) ([]*data.DeploymentRecord, error) {
+	return r.history, nil
+}
+
+func (r *rollbackStub) GetSceneHistory(rootCID string) ([]*data.DeploymentRecord, error) {
+	var ret []*data.DeploymentRecord
+	for _, h := range r.history {
+		if h.RootCid == rootCID {
+			ret = append(ret, h)
+		}
+	}
+	return ret, nil
+}
+
+func (r *rollbackStub) GetSceneContent(rootCID string) (map[string]string, error) {
+	return r.content, nil
+}
+
+func (r *rollbackStub) ClaimContent(cid string) (data.ContentState, error) {
+	return data.ContentUploaded, nil
+}
+
+func (r *rollbackStub) CommitScene(s *data.SceneIndex) error {
+	r.committed = s
+	return nil
+}
+
+// Accepts the signature of a single message
+type signedMessageAuth struct {
+	msg string
+}
+
+func (a *signedMessageAuth) UserCanModifyParcels(pubkey string, parcelsList []string) (bool, error) {
+	return true, nil
+}
+
+func (a *signedMessageAuth) IsSignatureValid(msg, hexSignature, hexAddress string) bool {
+	return msg == a.msg
+}
+
+func TestRollback(t *testing.T) {
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+	const timestamp = int64(1544626154)
+	parcels := []string{"54,-137", "54,-136"}
+	metadata := Metadata{RootCid: validRootCid, Value: validRootCid, Signature: validSignature, PubKey: validTestPubKey, Timestamp: timestamp}
+
+	for _, tc := range rollbackTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &rollbackStub{
+				current: "QmCurrent",
+				history: []*data.DeploymentRecord{
+					{RootCid: validRootCid, SceneCid: sceneJsonCID, Parcels: parcels},
+					{RootCid: "QmCurrent", Parcels: parcels},
+				},
+				content: map[string]string{"scene.json": sceneJsonCID},
+			}
+			us := &UploadServiceImpl{RedisClient: client, Auth: &signedMessageAuth{msg: tc.signed}, Log: l}
+
+			err := us.Rollback(&RollbackRequest{Metadata: metadata, Parcel: "54,-136", Parcels: tc.parcels})
+			if !tc.valid {
+				assert.NotNil(t, err)
+				assert.Nil(t, client.committed)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, validRootCid, client.committed.RootCid)
+			assert.Equal(t, parcels, client.committed.Parcels)
+		})
+	}
+}
+
+type rollbackCase struct {
+	name    string
+	parcels []string
+	signed  string
+	valid   bool
+}
+
+var rollbackTestCases = []rollbackCase{
+	{
+		name:    "Rollback signature",
+		parcels: []string{"54,-136", "54,-137"},
+		signed:  "rollback." + validRootCid + ".54,-136;54,-137.1544626154",
+		valid:   true,
+	}, {
+		name:    "Replayed deployment signature",
+		parcels: []string{"54,-136", "54,-137"},
+		signed:  validRootCid + ".1544626154",
+	}, {
+		name:    "Missing parcel",
+		parcels: []string{"54,-136"},
+		signed:  "rollback." + validRootCid + ".54,-136.1544626154",
+	},
+}
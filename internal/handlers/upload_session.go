@@ -0,0 +1,664 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/decentraland/content-service/config"
+	"github.com/decentraland/content-service/metrics"
+	"github.com/decentraland/content-service/validation"
+	log "github.com/sirupsen/logrus"
+)
+
+const sessionsDir = "upload-sessions"
+const sessionFileName = "session.json"
+const partsDir = "parts"
+
+type UploadSessionHandler interface {
+	OpenSession(c *gin.Context)
+	UploadSessionFile(c *gin.Context)
+	GetSession(c *gin.Context)
+	CommitSession(c *gin.Context)
+}
+
+func NewUploadSessionHandler(v validation.Validator, us UploadService, a *metrics.Agent, f *ContentTypeFilter,
+	limits config.Limits, ttl int64, workdir string, l *log.Logger) UploadSessionHandler {
+	return &uploadSessionHandlerImpl{
+		Uploads: &uploadHandlerImpl{
+			StructValidator: v,
+			Service:         us,
+			Agent:           a,
+			Filter:          f,
+			Limits:          limits,
+			TimeToLive:      ttl,
+			Log:             l,
+		},
+		Sessions: newSessionStore(filepath.Join(workdir, sessionsDir), ttl, limits.UploadSessions, limits.AddressUploadSessions),
+		Log:      l,
+	}
+}
+
+type uploadSessionHandlerImpl struct {
+	Uploads  *uploadHandlerImpl
+	Sessions *sessionStore
+	Log      *log.Logger
+}
+
+type openSessionRequest struct {
+	Metadata json.RawMessage `json:"metadata"`
+	Content  json.RawMessage `json:"content"`
+}
+
+type uploadSession struct {
+	ID       string                  `json:"id"`
+	Metadata Metadata                `json:"metadata"`
+	Manifest []FileMetadata          `json:"manifest"`
+	Origin   string                  `json:"origin"`
+	Files    map[string]*sessionFile `json:"files"`
+	// Parcels of the scene, known once scene.json is received
+	Parcels int `json:"parcels"`
+	// Whether the signer can modify the parcels of the scene
+	Authorized bool  `json:"authorized"`
+	UpdatedAt  int64 `json:"updated_at"`
+}
+
+type sessionFile struct {
+	ContentType string `json:"content_type"`
+	Received    int64  `json:"received"`
+	Complete    bool   `json:"complete"`
+}
+
+type sessionStatus struct {
+	ID       string                  `json:"id"`
+	RootCid  string                  `json:"root_cid"`
+	Expires  int64                   `json:"expires"`
+	Files    map[string]*sessionFile `json:"files"`
+	Pending  []string                `json:"pending"`
+	Complete bool                    `json:"complete"`
+}
+
+func (uh *uploadSessionHandlerImpl) OpenSession(c *gin.Context) {
+	var req openSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	metadata, err := parseSceneMetadata(string(req.Metadata), uh.Uploads.StructValidator, uh.Log)
+	if err != nil {
+		abortWithUploadError(c, err)
+		return
+	}
+
+	if hasRequestExpired(&metadata, uh.Uploads.TimeToLive) {
+		uh.Log.Debug("expired request")
+		abortWithUploadError(c, InvalidArgument{Message: "expired request"})
+		return
+	}
+
+	if err := checkCIDFormat(metadata.RootCid, uh.Log); err != nil {
+		abortWithUploadError(c, err)
+		return
+	}
+
+	manifest, err := parseFilesMetadata(string(req.Content), uh.Uploads.StructValidator)
+	if err != nil {
+		abortWithUploadError(c, err)
+		return
+	}
+
+	// The parcels are unknown until scene.json is received, only the signature can be checked now
+	if err := uh.Uploads.Service.Authorize(metadata, nil); err != nil {
+		uh.Log.WithError(err).Debugf("Upload session rejected for RootCID[%s]", metadata.RootCid)
+		abortWithUploadError(c, err)
+		return
+	}
+
+	uh.Sessions.purgeExpired(uh.Log)
+
+	s := &uploadSession{
+		ID:       uuid.New().String(),
+		Metadata: metadata,
+		Manifest: *manifest,
+		Origin:   c.GetHeader("x-upload-origin"),
+		Files:    make(map[string]*sessionFile),
+	}
+	err = uh.Sessions.create(s)
+	if err == errTooManySessions {
+		uh.Log.Warnf("Upload session rejected for RootCID[%s], too many sessions open", metadata.RootCid)
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "too many upload sessions open, try again later"})
+		return
+	}
+	if err != nil {
+		uh.Log.WithError(err).Error("fail to create upload session")
+		abortWithUploadError(c, UnexpectedError{"fail to create upload session", err})
+		return
+	}
+	uh.Log.Infof("Upload session[%s] opened for RootCID[%s]", s.ID, metadata.RootCid)
+
+	c.JSON(http.StatusCreated, uh.Sessions.status(s))
+}
+
+func (uh *uploadSessionHandlerImpl) UploadSessionFile(c *gin.Context) {
+	id := c.Param("id")
+	fileCid := c.Param("cid")
+
+	unlock := uh.Sessions.lock(id)
+	defer unlock()
+
+	s, err := uh.Sessions.load(id)
+	if err != nil {
+		abortWithSessionError(c, err, uh.Log)
+		return
+	}
+
+	if !manifestContains(s.Manifest, fileCid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("file %s is not part of the manifest", fileCid)})
+		return
+	}
+
+	contentType := c.GetHeader("Content-Type")
+	if !uh.Uploads.Filter.IsAllowed(contentType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid  Content-type: %s File: %s", contentType, fileCid)})
+		return
+	}
+
+	cr, err := parseContentRange(c.GetHeader("Content-Range"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	f, ok := s.Files[fileCid]
+	if !ok || cr.Start == 0 {
+		f = &sessionFile{}
+		s.Files[fileCid] = f
+	}
+	f.ContentType = contentType
+
+	if cr.Start != f.Received {
+		c.JSON(http.StatusConflict, gin.H{"error": "unexpected chunk offset", "received": f.Received})
+		return
+	}
+
+	// Sessions are not authenticated, the data is bounded while it arrives instead of at commit
+	remaining := uh.sizeLimit(s) - s.received()
+	if remaining <= 0 || (cr.End >= 0 && cr.length() > remaining) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "upload session exceeds the size limit", "received": f.Received})
+		return
+	}
+
+	n, err := uh.Sessions.writePart(s.ID, fileCid, cr.Start, &cappedReader{r: io.LimitReader(c.Request.Body, cr.length()), remaining: remaining})
+	f.Received = cr.Start + n
+	if err == errSessionTooLarge {
+		// The rejected chunk is dropped, so it takes no room in the session
+		f.Received = cr.Start
+	}
+	f.Complete = err == nil && cr.isLast(f.Received)
+	var authErr error
+	if f.Complete && isSceneFile(s.Manifest, fileCid) {
+		parcels := uh.Sessions.readParcels(s.ID, fileCid)
+		s.Parcels = len(parcels)
+		s.Authorized = false
+		if len(parcels) > 0 {
+			authErr = uh.Uploads.Service.Authorize(s.Metadata, parcels)
+			s.Authorized = authErr == nil
+		}
+	}
+	if saveErr := uh.Sessions.save(s); saveErr != nil {
+		uh.Log.WithError(saveErr).Errorf("fail to save upload session[%s]", s.ID)
+		abortWithUploadError(c, UnexpectedError{"fail to save upload session", saveErr})
+		return
+	}
+
+	if err == errSessionTooLarge {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "upload session exceeds the size limit", "received": f.Received})
+		return
+	}
+	if authErr != nil {
+		uh.Log.WithError(authErr).Debugf("Upload session[%s] signer can't modify the scene parcels", s.ID)
+		abortWithUploadError(c, authErr)
+		return
+	}
+	if err != nil {
+		uh.Log.WithError(err).Debugf("Upload session[%s] file[%s] interrupted at byte %d", s.ID, fileCid, f.Received)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "incomplete chunk", "received": f.Received})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"cid": fileCid, "received": f.Received, "complete": f.Complete})
+}
+
+// Bytes a session can hold, the limit of a single parcel until the signer is known to own the parcels listed in scene.json
+func (uh *uploadSessionHandlerImpl) sizeLimit(s *uploadSession) int64 {
+	if !s.Authorized || s.Parcels < 1 {
+		return uh.Uploads.Limits.ParcelSizeLimit
+	}
+	return int64(s.Parcels) * uh.Uploads.Limits.ParcelSizeLimit
+}
+
+func (uh *uploadSessionHandlerImpl) GetSession(c *gin.Context) {
+	id := c.Param("id")
+
+	unlock := uh.Sessions.lock(id)
+	defer unlock()
+
+	s, err := uh.Sessions.load(id)
+	if err != nil {
+		abortWithSessionError(c, err, uh.Log)
+		return
+	}
+	c.JSON(http.StatusOK, uh.Sessions.status(s))
+}
+
+func (uh *uploadSessionHandlerImpl) CommitSession(c *gin.Context) {
+	id := c.Param("id")
+
+	unlock := uh.Sessions.lock(id)
+	defer unlock()
+
+	s, err := uh.Sessions.load(id)
+	if err != nil {
+		abortWithSessionError(c, err, uh.Log)
+		return
+	}
+
+	for fileCid, f := range s.Files {
+		if !f.Complete {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("file %s is incomplete", fileCid), "received": f.Received})
+			return
+		}
+	}
+
+	form, err := uh.Sessions.readForm(s)
+	if err != nil {
+		uh.Log.WithError(err).Errorf("fail to read upload session[%s] files", s.ID)
+		abortWithUploadError(c, UnexpectedError{"fail to read upload session files", err})
+		return
+	}
+	defer form.RemoveAll()
+
+	uploadRequest, err := uh.Uploads.buildUploadRequest(s.Metadata, &s.Manifest, form.File, s.Origin)
+	if err != nil {
+		uh.Log.WithError(err).Error("Error parsing upload session")
+		abortWithUploadError(c, err)
+		return
+	}
+
+	tProcess := time.Now()
+	err = uh.Uploads.Service.ProcessUpload(uploadRequest)
+	uh.Uploads.Agent.RecordUploadProcessTime(time.Since(tProcess))
+
+	if err != nil {
+		uh.Log.WithError(err).Error("Error processing upload session")
+		abortWithUploadError(c, err)
+		return
+	}
+
+	uh.Sessions.remove(s.ID, uh.Log)
+	c.Status(http.StatusOK)
+}
+
+func abortWithSessionError(c *gin.Context, err error, log *log.Logger) {
+	if os.IsNotExist(err) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "upload session not found"})
+		return
+	}
+	log.WithError(err).Error("fail to read upload session")
+	_ = c.Error(err)
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error, try again later"})
+}
+
+func isSceneFile(manifest []FileMetadata, fileCid string) bool {
+	for _, m := range manifest {
+		if m.Cid == fileCid && m.Name == "scene.json" {
+			return true
+		}
+	}
+	return false
+}
+
+func manifestContains(manifest []FileMetadata, fileCid string) bool {
+	for _, m := range manifest {
+		if m.Cid == fileCid && !strings.HasSuffix(m.Name, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+var contentRangePattern = regexp.MustCompile(`^bytes (\d+)-(\d+)/(\d+|\*)$`)
+
+// Byte range of a chunk, as sent in the Content-Range header. A request without the header carries the whole file
+type contentRange struct {
+	Start int64
+	End   int64
+	Total int64
+}
+
+func parseContentRange(h string) (*contentRange, error) {
+	if h == "" {
+		return &contentRange{Start: 0, End: -1, Total: -1}, nil
+	}
+	m := contentRangePattern.FindStringSubmatch(strings.TrimSpace(h))
+	if m == nil {
+		return nil, InvalidArgument{fmt.Sprintf("invalid Content-Range: %s", h)}
+	}
+	start, _ := strconv.ParseInt(m[1], 10, 64)
+	end, _ := strconv.ParseInt(m[2], 10, 64)
+	total := int64(-1)
+	if m[3] != "*" {
+		total, _ = strconv.ParseInt(m[3], 10, 64)
+	}
+	if end < start || (total >= 0 && end >= total) {
+		return nil, InvalidArgument{fmt.Sprintf("invalid Content-Range: %s", h)}
+	}
+	return &contentRange{Start: start, End: end, Total: total}, nil
+}
+
+func (cr *contentRange) length() int64 {
+	if cr.End < 0 {
+		return 1<<63 - 1
+	}
+	return cr.End - cr.Start + 1
+}
+
+// Retrieves whether the file is complete once the chunk has been received
+func (cr *contentRange) isLast(received int64) bool {
+	if cr.End < 0 {
+		return true
+	}
+	return cr.Total >= 0 && received == cr.Total
+}
+
+var errSessionTooLarge = errors.New("upload session exceeds the size limit")
+
+// Fails once more than the remaining bytes are read
+type cappedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (cr *cappedReader) Read(p []byte) (int, error) {
+	if cr.remaining <= 0 {
+		var b [1]byte
+		n, err := cr.r.Read(b[:])
+		if n > 0 {
+			return 0, errSessionTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+	n, err := cr.r.Read(p)
+	cr.remaining -= int64(n)
+	return n, err
+}
+
+var errTooManySessions = errors.New("too many upload sessions open")
+
+// Keeps the upload sessions on disk, one directory per session holding its state and the received parts
+type sessionStore struct {
+	dir string
+	ttl int64
+	// Sessions open at the same time, in total and by address. 0 for no limit
+	maxOpen       int
+	maxPerAddress int
+	mutex         sync.Mutex
+	locks         map[string]*sync.Mutex
+	// Serializes the creations, so the limits are checked against every open session
+	creating sync.Mutex
+}
+
+func newSessionStore(dir string, ttl int64, maxOpen int, maxPerAddress int) *sessionStore {
+	return &sessionStore{dir: dir, ttl: ttl, maxOpen: maxOpen, maxPerAddress: maxPerAddress, locks: make(map[string]*sync.Mutex)}
+}
+
+// Locks the given session and retrieves the function that releases it
+func (ss *sessionStore) lock(id string) func() {
+	ss.mutex.Lock()
+	l, ok := ss.locks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		ss.locks[id] = l
+	}
+	ss.mutex.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+func (ss *sessionStore) sessionDir(id string) string {
+	return filepath.Join(ss.dir, filepath.Base(id))
+}
+
+func (ss *sessionStore) partPath(id string, fileCid string) string {
+	return filepath.Join(ss.sessionDir(id), partsDir, filepath.Base(fileCid))
+}
+
+// Creates the session, errTooManySessions when the open sessions, in total or of its signer, reached their limit
+func (ss *sessionStore) create(s *uploadSession) error {
+	ss.creating.Lock()
+	defer ss.creating.Unlock()
+
+	total, owned := ss.countOpen(s.Metadata.PubKey)
+	if (ss.maxOpen > 0 && total >= ss.maxOpen) || (ss.maxPerAddress > 0 && owned >= ss.maxPerAddress) {
+		return errTooManySessions
+	}
+	if err := os.MkdirAll(filepath.Join(ss.sessionDir(s.ID), partsDir), os.ModePerm); err != nil {
+		return err
+	}
+	return ss.save(s)
+}
+
+func (ss *sessionStore) save(s *uploadSession) error {
+	s.UpdatedAt = time.Now().Unix()
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(ss.sessionDir(s.ID), sessionFileName+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(ss.sessionDir(s.ID), sessionFileName))
+}
+
+// Retrieves the session with the given id. Expired sessions are reported as not found
+func (ss *sessionStore) load(id string) (*uploadSession, error) {
+	b, err := ioutil.ReadFile(filepath.Join(ss.sessionDir(id), sessionFileName))
+	if err != nil {
+		return nil, err
+	}
+	var s uploadSession
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	if ss.hasExpired(&s) {
+		return nil, os.ErrNotExist
+	}
+	return &s, nil
+}
+
+func (ss *sessionStore) hasExpired(s *uploadSession) bool {
+	return time.Now().Unix()-s.UpdatedAt > ss.ttl
+}
+
+func (ss *sessionStore) remove(id string, log *log.Logger) {
+	cleanUpTmpFile(ss.sessionDir(id), log)
+	ss.mutex.Lock()
+	delete(ss.locks, id)
+	ss.mutex.Unlock()
+}
+
+// Removes every session that has not received data within the TTL
+func (ss *sessionStore) purgeExpired(log *log.Logger) {
+	entries, err := ioutil.ReadDir(ss.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		unlock := ss.lock(e.Name())
+		_, err := ss.load(e.Name())
+		unlock()
+		if err != nil && time.Now().Unix()-e.ModTime().Unix() > ss.ttl {
+			log.Debugf("Removing expired upload session[%s]", e.Name())
+			ss.remove(e.Name(), log)
+		}
+	}
+}
+
+// Retrieves the sessions open, in total and signed by the given address
+func (ss *sessionStore) countOpen(address string) (int, int) {
+	entries, err := ioutil.ReadDir(ss.dir)
+	if err != nil {
+		return 0, 0
+	}
+	total, owned := 0, 0
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		unlock := ss.lock(e.Name())
+		s, err := ss.load(e.Name())
+		unlock()
+		if err != nil {
+			continue
+		}
+		total++
+		if strings.EqualFold(s.Metadata.PubKey, address) {
+			owned++
+		}
+	}
+	return total, owned
+}
+
+// Writes the chunk into the part file starting at the given offset. Retrieves the number of bytes written
+func (ss *sessionStore) writePart(id string, fileCid string, offset int64, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(ss.partPath(id, fileCid), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// Drops whatever a rejected chunk left past the offset
+	if err := f.Truncate(offset); err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(f, r)
+}
+
+// Bytes received for every file of the session
+func (s *uploadSession) received() int64 {
+	total := int64(0)
+	for _, f := range s.Files {
+		total += f.Received
+	}
+	return total
+}
+
+// Retrieves the parcels listed in the received scene.json, none if it can't be read
+func (ss *sessionStore) readParcels(id string, fileCid string) []string {
+	b, err := ioutil.ReadFile(ss.partPath(id, fileCid))
+	if err != nil {
+		return nil
+	}
+	var sc scene
+	if err := json.Unmarshal(b, &sc); err != nil {
+		return nil
+	}
+	return sc.Scene.Parcels
+}
+
+func (ss *sessionStore) status(s *uploadSession) *sessionStatus {
+	pending := []string{}
+	seen := make(map[string]bool)
+	for _, m := range s.Manifest {
+		if strings.HasSuffix(m.Name, "/") || seen[m.Cid] {
+			continue
+		}
+		seen[m.Cid] = true
+		if f, ok := s.Files[m.Cid]; !ok || !f.Complete {
+			pending = append(pending, m.Cid)
+		}
+	}
+	return &sessionStatus{
+		ID:       s.ID,
+		RootCid:  s.Metadata.RootCid,
+		Expires:  s.UpdatedAt + ss.ttl,
+		Files:    s.Files,
+		Pending:  pending,
+		Complete: len(pending) == 0,
+	}
+}
+
+// Builds the multipart form the upload pipeline expects from the parts received in the session
+func (ss *sessionStore) readForm(s *uploadSession) (*multipart.Form, error) {
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	w := multipart.NewWriter(pw)
+
+	go func() {
+		pw.CloseWithError(ss.writeForm(s, w))
+	}()
+
+	return multipart.NewReader(pr, w.Boundary()).ReadForm(0)
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func (ss *sessionStore) writeForm(s *uploadSession, w *multipart.Writer) error {
+	for fileCid, f := range s.Files {
+		name := fileCid
+		for _, m := range s.Manifest {
+			if m.Cid == fileCid {
+				name = m.Name
+				break
+			}
+		}
+
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			quoteEscaper.Replace(fileCid), quoteEscaper.Replace(name)))
+		h.Set("Content-Type", f.ContentType)
+
+		part, err := w.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		err = func() error {
+			file, err := os.Open(ss.partPath(s.ID, fileCid))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			_, err = io.Copy(part, file)
+			return err
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/decentraland/content-service/config"
+	"github.com/decentraland/content-service/metrics"
+	"github.com/decentraland/content-service/validation"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadSession(t *testing.T) {
+	workdir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	limits := config.Limits{ParcelSizeLimit: 150000, ParcelAssetsLimit: 1000}
+	router, service := newSessionRouter(workdir, limits)
+
+	sceneBytes, _ := json.Marshal(&scene{
+		Scene: sceneData{Parcels: []string{"54,-136"}, Base: "54,-136"},
+		Main:  "scene.js",
+	})
+	content := []byte("some content split in chunks")
+	const contentCid = "QmbdQuGbRFZdeqmK3PJyLV3m4p2KDELKRS4GfaXyehz672"
+
+	body := sessionRequest([]FileMetadata{
+		{Cid: sceneJsonCID, Name: "scene.json"},
+		{Cid: contentCid, Name: "assets/test.txt"},
+	})
+
+	w := serve(router, "POST", "/mappings/sessions", body, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var status sessionStatus
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.ElementsMatch(t, []string{sceneJsonCID, contentCid}, status.Pending)
+
+	filesURL := fmt.Sprintf("/mappings/sessions/%s/files/", status.ID)
+
+	w = serve(router, "PUT", filesURL+sceneJsonCID, sceneBytes, map[string]string{"Content-Type": "application/json"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(router, "PUT", filesURL+contentCid, content[:10], map[string]string{
+		"Content-Type":  "text/plain",
+		"Content-Range": fmt.Sprintf("bytes 0-9/%d", len(content)),
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(router, "POST", fmt.Sprintf("/mappings/sessions/%s/commit", status.ID), nil, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "commit must wait for every chunk")
+
+	w = serve(router, "PUT", filesURL+contentCid, content[20:], map[string]string{
+		"Content-Type":  "text/plain",
+		"Content-Range": fmt.Sprintf("bytes 20-%d/%d", len(content)-1, len(content)),
+	})
+	assert.Equal(t, http.StatusConflict, w.Code, "chunks must be sent in order")
+
+	w = serve(router, "PUT", filesURL+contentCid, content[10:], map[string]string{
+		"Content-Type":  "text/plain",
+		"Content-Range": fmt.Sprintf("bytes 10-%d/%d", len(content)-1, len(content)),
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(router, "GET", fmt.Sprintf("/mappings/sessions/%s", status.ID), nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.True(t, status.Complete)
+	assert.Equal(t, int64(len(content)), status.Files[contentCid].Received)
+
+	w = serve(router, "POST", fmt.Sprintf("/mappings/sessions/%s/commit", status.ID), nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "scene.json", service.uploadedContent[sceneJsonCID])
+	assert.Equal(t, "test.txt", service.uploadedContent[contentCid])
+
+	w = serve(router, "GET", fmt.Sprintf("/mappings/sessions/%s", status.ID), nil, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUploadSessionSizeLimit(t *testing.T) {
+	workdir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	sceneBytes, _ := json.Marshal(&scene{
+		Scene: sceneData{Parcels: []string{"54,-136", "54,-137"}, Base: "54,-136"},
+		Main:  "scene.js",
+	})
+	const contentCid = "QmbdQuGbRFZdeqmK3PJyLV3m4p2KDELKRS4GfaXyehz672"
+	router, _ := newSessionRouter(workdir, config.Limits{ParcelSizeLimit: int64(len(sceneBytes)), ParcelAssetsLimit: 1000})
+
+	body := sessionRequest([]FileMetadata{
+		{Cid: sceneJsonCID, Name: "scene.json"},
+		{Cid: contentCid, Name: "assets/test.txt"},
+	})
+	w := serve(router, "POST", "/mappings/sessions", body, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var status sessionStatus
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &status))
+	filesURL := fmt.Sprintf("/mappings/sessions/%s/files/", status.ID)
+
+	content := bytes.Repeat([]byte("a"), len(sceneBytes)+1)
+	w = serve(router, "PUT", filesURL+contentCid, content, map[string]string{"Content-Type": "text/plain"})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, "a single parcel is allowed until scene.json is received")
+
+	w = serve(router, "PUT", filesURL+sceneJsonCID, sceneBytes, map[string]string{"Content-Type": "application/json"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The two parcels of the scene leave room for the content, but not for a chunk past it
+	w = serve(router, "PUT", filesURL+contentCid, content, map[string]string{
+		"Content-Type":  "text/plain",
+		"Content-Range": fmt.Sprintf("bytes 0-%d/*", len(content)),
+	})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	w = serve(router, "PUT", filesURL+contentCid, content[:len(content)-1], map[string]string{"Content-Type": "text/plain"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestUploadSessionUnauthorizedParcels(t *testing.T) {
+	workdir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	sceneBytes, _ := json.Marshal(&scene{
+		Scene: sceneData{Parcels: []string{"54,-136", "54,-137"}, Base: "54,-136"},
+		Main:  "scene.js",
+	})
+	const contentCid = "QmbdQuGbRFZdeqmK3PJyLV3m4p2KDELKRS4GfaXyehz672"
+	router, service := newSessionRouter(workdir, config.Limits{ParcelSizeLimit: int64(len(sceneBytes)), ParcelAssetsLimit: 1000})
+	service.forbidden = []string{"54,-137"}
+
+	body := sessionRequest([]FileMetadata{
+		{Cid: sceneJsonCID, Name: "scene.json"},
+		{Cid: contentCid, Name: "assets/test.txt"},
+	})
+	w := serve(router, "POST", "/mappings/sessions", body, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var status sessionStatus
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &status))
+	filesURL := fmt.Sprintf("/mappings/sessions/%s/files/", status.ID)
+
+	w = serve(router, "PUT", filesURL+sceneJsonCID, sceneBytes, map[string]string{"Content-Type": "application/json"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// The parcels listed by the client don't raise the limit
+	w = serve(router, "PUT", filesURL+contentCid, []byte("a"), map[string]string{"Content-Type": "text/plain"})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestUploadSessionOpenLimits(t *testing.T) {
+	workdir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workdir)
+
+	router, _ := newSessionRouter(workdir, config.Limits{ParcelSizeLimit: 1000, ParcelAssetsLimit: 1000,
+		UploadSessions: 2, AddressUploadSessions: 1})
+	manifest := []FileMetadata{{Cid: sceneJsonCID, Name: "scene.json"}}
+	const otherPubKey = "0x0f5d2fb29fb7d3cfee444a200298f468908cc942"
+	const anotherPubKey = "0x1f5d2fb29fb7d3cfee444a200298f468908cc942"
+
+	w := serve(router, "POST", "/mappings/sessions", sessionRequestBy(validTestPubKey, manifest), nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = serve(router, "POST", "/mappings/sessions", sessionRequestBy("0x"+strings.ToUpper(validTestPubKey[2:]), manifest), nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "an address is limited to a single session")
+
+	w = serve(router, "POST", "/mappings/sessions", sessionRequestBy(otherPubKey, manifest), nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = serve(router, "POST", "/mappings/sessions", sessionRequestBy(anotherPubKey, manifest), nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "two sessions can be open at the same time")
+}
+
+func newSessionRouter(workdir string, limits config.Limits) (*gin.Engine, *uploadServiceMock) {
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+
+	dummyAgent, _ := metrics.Make(config.Metrics{AppName: "", Enabled: false, AnalyticsKey: ""})
+	service := &uploadServiceMock{uploadedContent: make(map[string]string)}
+	handler := NewUploadSessionHandler(validation.NewValidator(), service, dummyAgent,
+		NewContentTypeFilter([]string{".*"}), limits, 600, workdir, l)
+
+	router := gin.New()
+	router.POST("/mappings/sessions", handler.OpenSession)
+	router.GET("/mappings/sessions/:id", handler.GetSession)
+	router.PUT("/mappings/sessions/:id/files/:cid", handler.UploadSessionFile)
+	router.POST("/mappings/sessions/:id/commit", handler.CommitSession)
+	return router, service
+}
+
+func sessionRequest(manifest []FileMetadata) []byte {
+	return sessionRequestBy(validTestPubKey, manifest)
+}
+
+func sessionRequestBy(pubKey string, manifest []FileMetadata) []byte {
+	metaBytes, _ := json.Marshal(&Metadata{
+		Value:        validRootCid,
+		Signature:    validSignature,
+		Validity:     "2018-12-12T14:49:14.074000000Z",
+		ValidityType: 0,
+		Sequence:     2,
+		PubKey:       pubKey,
+		RootCid:      validRootCid,
+		Timestamp:    time.Now().Unix(),
+	})
+	manifestBytes, _ := json.Marshal(manifest)
+	body, _ := json.Marshal(gin.H{"metadata": json.RawMessage(metaBytes), "content": json.RawMessage(manifestBytes)})
+	return body
+}
+
+func TestParseContentRange(t *testing.T) {
+	for _, tc := range contentRangeTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cr, err := parseContentRange(tc.header)
+			if tc.expected == nil {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, cr)
+		})
+	}
+}
+
+type contentRangeCase struct {
+	name     string
+	header   string
+	expected *contentRange
+}
+
+var contentRangeTestCases = []contentRangeCase{
+	{
+		name:     "Whole file",
+		header:   "",
+		expected: &contentRange{Start: 0, End: -1, Total: -1},
+	}, {
+		name:     "Known size",
+		header:   "bytes 0-99/1000",
+		expected: &contentRange{Start: 0, End: 99, Total: 1000},
+	}, {
+		name:     "Unknown size",
+		header:   "bytes 100-199/*",
+		expected: &contentRange{Start: 100, End: 199, Total: -1},
+	}, {
+		name:   "Range outside the file",
+		header: "bytes 900-1000/1000",
+	}, {
+		name:   "Inverted range",
+		header: "bytes 10-5/1000",
+	}, {
+		name:   "Invalid unit",
+		header: "items 0-5/10",
+	},
+}
+
+func serve(router *gin.Engine, method string, url string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest(method, url, bytes.NewReader(body))
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
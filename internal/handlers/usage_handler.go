@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/decentraland/content-service/data"
+	log "github.com/sirupsen/logrus"
+)
+
+type UsageHandler interface {
+	GetPublisherUsage(c *gin.Context)
+	GetParcelUsage(c *gin.Context)
+}
+
+func NewUsageHandler(client data.RedisClient, l *log.Logger) UsageHandler {
+	return &usageHandlerImpl{
+		RedisClient: client,
+		Log:         l,
+	}
+}
+
+type usageHandlerImpl struct {
+	RedisClient data.RedisClient
+	Log         *log.Logger
+}
+
+var addressPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+
+func (uh *usageHandlerImpl) GetPublisherUsage(c *gin.Context) {
+	address := c.Param("address")
+	if !addressPattern.MatchString(address) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid address"})
+		return
+	}
+
+	usage, err := uh.RedisClient.GetPublisherUsage(strings.ToLower(address))
+	if err != nil {
+		uh.abortWithRedisError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, usage)
+}
+
+// Handles /usage/parcels/:x/:y, registered as /usage/:address/:x/:y
+func (uh *usageHandlerImpl) GetParcelUsage(c *gin.Context) {
+	if c.Param("address") != "parcels" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	parcelId, err := parcelFromPath(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parcel coordinates"})
+		return
+	}
+
+	usage, err := uh.RedisClient.GetParcelUsage(parcelId)
+	if err != nil {
+		uh.abortWithRedisError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, usage)
+}
+
+func (uh *usageHandlerImpl) abortWithRedisError(c *gin.Context, err error) {
+	uh.Log.WithError(err).Error("error reading usage from redis")
+	_ = c.Error(err)
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unexpected error, try again later"})
+}
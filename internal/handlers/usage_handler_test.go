@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/decentraland/content-service/data"
+)
+
+func (r *redisStub) GetPublisherUsage(address string) (*data.Usage, error) {
+	return r.getUsage("publisher:" + address), nil
+}
+
+func (r *redisStub) GetParcelUsage(pid string) (*data.Usage, error) {
+	return r.getUsage("parcel:" + pid), nil
+}
+
+func (r *redisStub) getUsage(key string) *data.Usage {
+	if u, ok := r.usage[key]; ok {
+		return u
+	}
+	return &data.Usage{}
+}
+
+func TestUsageHandler(t *testing.T) {
+	client := &redisStub{usage: map[string]*data.Usage{
+		"publisher:" + validTestPubKey: {Deployments: 2, Files: 3, Bytes: 300, SharedFiles: 1, SharedBytes: 50},
+		"parcel:-10,20":                {Deployments: 1, Files: 1, Bytes: 100},
+	}}
+	l := log.New()
+	l.SetLevel(log.PanicLevel)
+	handler := NewUsageHandler(client, l)
+	router := gin.New()
+	router.GET("/usage/:address", handler.GetPublisherUsage)
+	router.GET("/usage/:address/:x/:y", handler.GetParcelUsage)
+
+	for _, tc := range usageTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serve(router, "GET", tc.url, nil, nil)
+			assert.Equal(t, tc.status, w.Code)
+			if tc.body != "" {
+				assert.JSONEq(t, tc.body, w.Body.String())
+			}
+		})
+	}
+}
+
+type usageCase struct {
+	name   string
+	url    string
+	status int
+	body   string
+}
+
+var usageTestCases = []usageCase{
+	{
+		name:   "Publisher",
+		url:    "/usage/0xA08A656AC52C0B32902A76E122D2973B022CAA0E",
+		status: http.StatusOK,
+		body:   `{"deployments": 2, "files": 3, "bytes": 300, "shared_files": 1, "shared_bytes": 50}`,
+	}, {
+		name:   "Unknown publisher",
+		url:    "/usage/0x0000000000000000000000000000000000000000",
+		status: http.StatusOK,
+		body:   `{"deployments": 0, "files": 0, "bytes": 0, "shared_files": 0, "shared_bytes": 0}`,
+	}, {
+		name:   "Invalid address",
+		url:    "/usage/0x1234",
+		status: http.StatusBadRequest,
+	}, {
+		name:   "Parcel",
+		url:    "/usage/parcels/-10/20",
+		status: http.StatusOK,
+		body:   `{"deployments": 1, "files": 1, "bytes": 100, "shared_files": 0, "shared_bytes": 0}`,
+	}, {
+		name:   "Invalid parcel",
+		url:    "/usage/parcels/-10/200",
+		status: http.StatusBadRequest,
+	}, {
+		name:   "Unknown path",
+		url:    "/usage/estates/-10/20",
+		status: http.StatusNotFound,
+	},
+}
+
+func TestDeploymentUsage(t *testing.T) {
+	manifest := []FileMetadata{
+		{Name: "scene.json", Cid: "QmScene"},
+		{Name: "models/", Cid: "QmDir"},
+		{Name: "models/a.glb", Cid: "QmModel"},
+		// Same content under another path
+		{Name: "models/b.glb", Cid: "QmModel"},
+		{Name: "textures/shared.png", Cid: "QmShared"},
+	}
+	sizes := map[string]int64{"QmScene": 10, "QmModel": 100, "QmShared": 1000}
+	stored := map[string]bool{"QmScene": true, "QmModel": true}
+
+	usage := deploymentUsage(&manifest, sizes, stored)
+	assert.Equal(t, &data.Usage{Deployments: 1, Files: 2, Bytes: 110, SharedFiles: 1, SharedBytes: 1000}, usage)
+}
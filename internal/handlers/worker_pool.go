@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Runs the task for every index in [0, n) with at most concurrency tasks running at the same time
+// The first error cancels the batch: no new task is started, the context of the running ones is cancelled
+// and the error is returned once they finish
+func runBatch(concurrency int, n int, task func(ctx context.Context, i int) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > n {
+		concurrency = n
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := task(ctx, i); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return firstErr
+}
+
+// Runs background jobs, at most workers at the same time and up to size more waiting for a worker
+type jobQueue struct {
+	slots   chan struct{}
+	workers chan struct{}
+}
+
+func newJobQueue(workers int, size int) *jobQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	if size < 0 {
+		size = 0
+	}
+	return &jobQueue{slots: make(chan struct{}, workers+size), workers: make(chan struct{}, workers)}
+}
+
+// Takes a place in the queue, false when it is full
+// The place is given back by release, or once the job passed to run finishes
+func (q *jobQueue) reserve() bool {
+	select {
+	case q.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *jobQueue) release() {
+	<-q.slots
+}
+
+// Runs the job in the place reserved, as soon as a worker is free
+func (q *jobQueue) run(job func()) {
+	go func() {
+		defer q.release()
+		q.workers <- struct{}{}
+		defer func() { <-q.workers }()
+		job()
+	}()
+}
+
+// Reader that fails as soon as the context is cancelled, so a cancelled batch stops streaming its files
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
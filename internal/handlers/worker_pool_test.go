@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunBatch(t *testing.T) {
+	var running, maxRunning, ran int32
+	err := runBatch(4, 50, func(ctx context.Context, i int) error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&ran, 1)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(50), ran)
+	assert.True(t, maxRunning <= 4, "no more than 4 tasks at the same time")
+}
+
+func TestRunBatchFailure(t *testing.T) {
+	failure := InvalidArgument{"File[a] CID does not match expected value: b"}
+	var ran int32
+	err := runBatch(2, 100, func(ctx context.Context, i int) error {
+		atomic.AddInt32(&ran, 1)
+		if i == 3 {
+			return failure
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Millisecond):
+			return nil
+		}
+	})
+	assert.Equal(t, failure, err, "the first error is returned")
+	assert.True(t, ran < 100, "the remaining tasks are not started")
+}
+
+func TestRunBatchEmpty(t *testing.T) {
+	err := runBatch(8, 0, func(ctx context.Context, i int) error {
+		return errors.New("unexpected task")
+	})
+	assert.Nil(t, err)
+}
+
+func TestContextReader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &contextReader{ctx, bytes.NewReader([]byte("content"))}
+	b, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "content", string(b))
+
+	cancel()
+	_, err = r.Read(make([]byte, 1))
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestJobQueue(t *testing.T) {
+	q := newJobQueue(1, 1)
+	assert.True(t, q.reserve())
+	assert.True(t, q.reserve())
+	assert.False(t, q.reserve(), "one job running and one waiting")
+
+	block := make(chan struct{})
+	started := make(chan struct{}, 2)
+	for i := 0; i < 2; i++ {
+		q.run(func() {
+			started <- struct{}{}
+			<-block
+		})
+	}
+
+	<-started
+	select {
+	case <-started:
+		t.Fatal("the second job must wait for the worker")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(block)
+	<-started
+	for !q.reserve() {
+		time.Sleep(time.Millisecond)
+	}
+	q.release()
+}
@@ -14,15 +14,13 @@ import (
 
 	"github.com/gin-gonic/gin"
 
-	"github.com/ipsn/go-ipfs/core"
-
 	"github.com/decentraland/dcl-gin/pkg/dclgin"
 )
 
 type Config struct {
 	Client  data.RedisClient
+	Events  data.DeploymentEvents
 	Storage storage.Storage
-	Node    *core.IpfsNode
 	Agent   *metrics.Agent
 	Conf    *config.Configuration
 	Log     *log.Logger
@@ -34,31 +32,70 @@ func AddRoutes(router gin.IRouter, c *Config) {
 	router.Use(dclgin.CorsMiddleware())
 
 	mappingsHandler := handlers.NewMappingsHandler(c.Client, data.NewDclClient(c.Conf.DecentralandApi.LandUrl, c.Agent), c.Storage, c.Log)
-	contentHandler := handlers.NewContentHandler(c.Storage, c.Client, c.Log)
+	contentHandler := handlers.NewContentHandler(c.Storage, c.Client, c.Agent, c.Conf.Storage, c.Log)
 	metadataHandler := handlers.NewMetadataHandler(c.Client, c.Log)
 
-	uploadService := handlers.NewUploadService(c.Storage, c.Client, c.Node,
+	uploadService := handlers.NewUploadService(c.Storage, c.Client,
 		data.NewAuthorizationService(data.NewDclClient(c.Conf.DecentralandApi.LandUrl, c.Agent)),
-		c.Agent, c.Conf.Limits.ParcelSizeLimit, c.Conf.Workdir, rpc.NewRPC(c.Conf.RPCConnection.URL), c.Log)
+		c.Agent, c.Events, c.Conf.Limits, c.Conf.Workdir, rpc.NewRPC(c.Conf.RPCConnection.URL), c.Log)
+
+	historyHandler := handlers.NewHistoryHandler(c.Client, uploadService, validation.NewValidator(), c.Conf.UploadRequestTTL, c.Log)
+
+	deployments := handlers.NewDeployments(c.Client, c.Conf.DeploymentTTL, c.Log)
+	deploymentHandler := handlers.NewDeploymentHandler(deployments, c.Log)
+	usageHandler := handlers.NewUsageHandler(c.Client, c.Log)
+	eventsHandler, err := handlers.NewEventsHandler(c.Events, c.Log)
+	if err != nil {
+		c.Log.Fatalf("Error subscribing to the deployment events: %s", err)
+	}
 
 	uploadHandler := handlers.NewUploadHandler(validation.NewValidator(), uploadService, c.Agent,
-		handlers.NewContentTypeFilter(c.Conf.AllowedContentTypes), c.Conf.Limits, c.Conf.UploadRequestTTL, c.Log)
+		handlers.NewContentTypeFilter(c.Conf.AllowedContentTypes), c.Conf.Limits, c.Conf.UploadRequestTTL, deployments, c.Log)
+
+	uploadSessionHandler := handlers.NewUploadSessionHandler(validation.NewValidator(), uploadService, c.Agent,
+		handlers.NewContentTypeFilter(c.Conf.AllowedContentTypes), c.Conf.Limits, c.Conf.UploadRequestTTL, c.Conf.Workdir, c.Log)
 
 	router.OPTIONS("/mappings", dclgin.PrefligthChecksMiddleware("GET, POST",
 		fmt.Sprintf("x-upload-origin, %s", dclgin.BasicHeaders)))
+	router.OPTIONS("/mappings/sessions", dclgin.PrefligthChecksMiddleware("POST",
+		fmt.Sprintf("x-upload-origin, %s", dclgin.BasicHeaders)))
+	router.OPTIONS("/mappings/sessions/:id", dclgin.PrefligthChecksMiddleware("GET", dclgin.BasicHeaders))
+	router.OPTIONS("/mappings/sessions/:id/files/:cid", dclgin.PrefligthChecksMiddleware("PUT",
+		fmt.Sprintf("Content-Range, %s", dclgin.BasicHeaders)))
+	router.OPTIONS("/mappings/sessions/:id/commit", dclgin.PrefligthChecksMiddleware("POST", dclgin.BasicHeaders))
+	router.OPTIONS("/deployments/:id", dclgin.PrefligthChecksMiddleware("GET", dclgin.BasicHeaders))
+	router.OPTIONS("/parcels/:x/:y/history", dclgin.PrefligthChecksMiddleware("GET", dclgin.BasicHeaders))
+	router.OPTIONS("/parcels/:x/:y/rollback", dclgin.PrefligthChecksMiddleware("POST",
+		fmt.Sprintf("x-upload-origin, %s", dclgin.BasicHeaders)))
+	router.OPTIONS("/usage/:address", dclgin.PrefligthChecksMiddleware("GET", dclgin.BasicHeaders))
+	router.OPTIONS("/usage/:address/:x/:y", dclgin.PrefligthChecksMiddleware("GET", dclgin.BasicHeaders))
+	router.OPTIONS("/events", dclgin.PrefligthChecksMiddleware("GET", dclgin.BasicHeaders))
 	router.OPTIONS("/scenes", dclgin.PrefligthChecksMiddleware("GET", dclgin.BasicHeaders))
 	router.OPTIONS("/parcel_info", dclgin.PrefligthChecksMiddleware("GET", dclgin.BasicHeaders))
 	router.OPTIONS("/contents/:cid", dclgin.PrefligthChecksMiddleware("GET", dclgin.BasicHeaders))
+	router.OPTIONS("/contents/:cid/references", dclgin.PrefligthChecksMiddleware("GET", dclgin.BasicHeaders))
 	router.OPTIONS("/validate", dclgin.PrefligthChecksMiddleware("GET", dclgin.BasicHeaders))
 	router.OPTIONS("/content/status", dclgin.PrefligthChecksMiddleware("POST", dclgin.BasicHeaders))
 
 	router.GET("/mappings", mappingsHandler.GetMappings)
 	router.GET("/scenes", mappingsHandler.GetScenes)
+	router.GET("/events", eventsHandler.GetEvents)
 	router.GET("/parcel_info", mappingsHandler.GetInfo)
 	router.GET("/contents/:cid", contentHandler.GetContents)
+	router.GET("/contents/:cid/references", contentHandler.GetContentReferences)
 	router.GET("/validate", metadataHandler.GetParcelMetadata)
 	router.POST("/content/status", contentHandler.CheckContentStatus)
 	router.POST("/mappings", uploadHandler.UploadContent)
+	router.POST("/mappings/sessions", uploadSessionHandler.OpenSession)
+	router.GET("/mappings/sessions/:id", uploadSessionHandler.GetSession)
+	router.PUT("/mappings/sessions/:id/files/:cid", uploadSessionHandler.UploadSessionFile)
+	router.POST("/mappings/sessions/:id/commit", uploadSessionHandler.CommitSession)
+	router.GET("/deployments/:id", deploymentHandler.GetDeployment)
+	router.GET("/parcels/:x/:y/history", historyHandler.GetParcelHistory)
+	router.POST("/parcels/:x/:y/rollback", historyHandler.Rollback)
+	router.GET("/usage/:address", usageHandler.GetPublisherUsage)
+	// Serves /usage/parcels/:x/:y, the router does not allow a static segment next to the :address wildcard
+	router.GET("/usage/:address/:x/:y", usageHandler.GetParcelUsage)
 
 	dclgin.RegisterVersionEndpoint(router)
 
@@ -3,18 +3,20 @@ package main
 import (
 	"context"
 	"fmt"
+	"time"
+
 	"github.com/decentraland/dcl-gin/pkg/dclgin"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/decentraland/content-service/data"
+	"github.com/decentraland/content-service/internal/gc"
 	"github.com/decentraland/content-service/internal/routes"
 	"github.com/decentraland/content-service/metrics"
 
 	"github.com/decentraland/content-service/config"
 	"github.com/decentraland/content-service/storage"
 
-	"github.com/ipsn/go-ipfs/core"
 	log "github.com/sirupsen/logrus"
 	"github.com/toorop/gin-logrus"
 )
@@ -57,34 +59,59 @@ func InitializeHandler(r gin.IRouter, conf *config.Configuration, l *log.Logger)
 		log.Fatal("Error initializing metrics agent")
 	}
 
-	// Initialize Redis client
-	client, err := data.NewRedisClient(conf.Redis.Address, conf.Redis.Password, conf.Redis.DB, agent)
+	// Initialize the index client
+	client, err := data.NewIndexClient(conf, agent)
 	if err != nil {
-		log.Fatal("Error initializing Redis client")
+		log.Fatalf("Error initializing index client: %s", err)
 	}
 
-	// Initialize IPFS for CID calculations
-	var ipfsNode *core.IpfsNode
-	ipfsNode, err = initIpfsNode()
-	if err != nil {
-		log.Fatal("Error initializing IPFS node")
+	sto := storage.NewStorage(&conf.Storage, agent)
+	if local, ok := sto.(*storage.Local); ok {
+		scanLocalStorage(local, client, conf.Storage.VerifyOnStartup)
 	}
 
-	sto := storage.NewStorage(&conf.Storage, agent)
+	if conf.GC.Enabled {
+		collector := gc.NewCollector(client, sto, time.Duration(conf.GC.GracePeriod)*time.Second, l)
+		collector.Start(time.Duration(conf.GC.Interval)*time.Second, conf.GC.DryRun)
+	}
+
+	replicated, ok := sto.(*storage.Replicated)
+	if tiered, isTiered := sto.(*storage.Tiered); isTiered {
+		replicated, ok = tiered.Remote.(*storage.Replicated)
+	}
+	if ok && conf.Storage.Replication.RepairInterval > 0 {
+		replicated.StartRepair(time.Duration(conf.Storage.Replication.RepairInterval)*time.Second,
+			conf.Storage.Replication.RepairBatch, client.GetUploadedContent)
+	}
 
 	routes.AddRoutes(r, &routes.Config{
 		Client:  client,
+		Events:  data.NewDeploymentEvents(conf),
 		Storage: sto,
-		Node:    ipfsNode,
 		Agent:   agent,
 		Conf:    conf,
 		Log:     l,
 	})
 }
 
-func initIpfsNode() (*core.IpfsNode, error) {
-	ctx, _ := context.WithCancel(context.Background())
-	return core.NewNode(ctx, nil)
+// Cleans up the writes interrupted by the last shutdown. The removed files are no longer uploaded, so the
+// next deployment including them stores them again
+func scanLocalStorage(sto *storage.Local, client data.RedisClient, verify bool) {
+	if verify {
+		log.Info("Verifying the local storage, this may take a while")
+	}
+	report, err := sto.Scan(context.Background(), verify)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to scan the local storage")
+	}
+	if err := client.RemoveContent(report.Removed); err != nil {
+		log.WithError(err).Fatal("Failed to remove the partial files from the uploaded content")
+	}
+	log.WithFields(log.Fields{
+		"checked":   report.Checked,
+		"removed":   len(report.Removed),
+		"leftovers": report.Leftovers,
+	}).Info("Local storage scanned")
 }
 
 func newLogger() *log.Logger {
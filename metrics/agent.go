@@ -13,6 +13,12 @@ import (
 type ddClient interface {
 	RecordBytesStored(fileSize int64)
 	RecordBytesRetrieved(fileSize int64)
+	RecordBytesDeduplicated(fileSize int64)
+	RecordCorruptedContent()
+	RecordStorageCacheHit()
+	RecordStorageCacheMiss()
+	RecordIndexCacheHit()
+	RecordIndexCacheMiss()
 	RecordRetrieveTime(t time.Duration)
 	RecordStorageTime(t time.Duration)
 	RecordUploadReqSize(size int)
@@ -27,6 +33,7 @@ type ddClient interface {
 	RecordGetParcelContent(t time.Duration)
 	RecordStoreContent(t time.Duration)
 	RecordStoreMetadata(t time.Duration)
+	RecordCommitScene(t time.Duration)
 	RecordDCLAPIError(status int)
 }
 
@@ -50,6 +57,12 @@ func (c *ddClientImpl) gauge(metric string, value float64) {
 	}
 }
 
+func (c *ddClientImpl) count(metric string, value int64) {
+	if err := c.client.Count(fmt.Sprintf(".%s", metric), value, c.tags, 1); err != nil {
+		log.Errorf("Metrics agent failed: %s", err.Error())
+	}
+}
+
 func (c *ddClientImpl) RecordBytesStored(fileSize int64) {
 	c.gauge("FileStored.bytes", float64(fileSize))
 }
@@ -58,6 +71,30 @@ func (c *ddClientImpl) RecordBytesRetrieved(fileSize int64) {
 	c.gauge("FileRetrieved.bytes", float64(fileSize))
 }
 
+func (c *ddClientImpl) RecordBytesDeduplicated(fileSize int64) {
+	c.count("FileDeduplicated.bytes", fileSize)
+}
+
+func (c *ddClientImpl) RecordCorruptedContent() {
+	c.count("FileCorrupted.count", 1)
+}
+
+func (c *ddClientImpl) RecordStorageCacheHit() {
+	c.count("StorageCacheHit.count", 1)
+}
+
+func (c *ddClientImpl) RecordStorageCacheMiss() {
+	c.count("StorageCacheMiss.count", 1)
+}
+
+func (c *ddClientImpl) RecordIndexCacheHit() {
+	c.count("IndexCacheHit.count", 1)
+}
+
+func (c *ddClientImpl) RecordIndexCacheMiss() {
+	c.count("IndexCacheMiss.count", 1)
+}
+
 func (c *ddClientImpl) RecordUploadRequestValidationTime(t time.Duration) {
 	c.gauge("UploadValidationTime.msec.call", toMillis(t))
 }
@@ -114,6 +151,10 @@ func (c *ddClientImpl) RecordStoreMetadata(t time.Duration) {
 	c.gauge("StoreMetadata.msec.call", toMillis(t))
 }
 
+func (c *ddClientImpl) RecordCommitScene(t time.Duration) {
+	c.gauge("CommitScene.msec.call", toMillis(t))
+}
+
 func (c *ddClientImpl) RecordDCLAPIError(status int) {
 	c.gauge(fmt.Sprintf("DecentralandAPIError%d", status), float64(1))
 }
@@ -122,6 +163,12 @@ type ddClientDummy struct{}
 
 func (d *ddClientDummy) RecordBytesStored(fileSize int64)                  {}
 func (d *ddClientDummy) RecordBytesRetrieved(fileSize int64)               {}
+func (d *ddClientDummy) RecordBytesDeduplicated(fileSize int64)            {}
+func (d *ddClientDummy) RecordCorruptedContent()                           {}
+func (d *ddClientDummy) RecordStorageCacheHit()                            {}
+func (d *ddClientDummy) RecordStorageCacheMiss()                           {}
+func (d *ddClientDummy) RecordIndexCacheHit()                              {}
+func (d *ddClientDummy) RecordIndexCacheMiss()                             {}
 func (d *ddClientDummy) RecordUploadRequestValidationTime(t time.Duration) {}
 func (d *ddClientDummy) RecordRetrieveTime(t time.Duration)                {}
 func (d *ddClientDummy) RecordUploadReqSize(size int)                      {}
@@ -136,6 +183,7 @@ func (d *ddClientDummy) RecordGetParcelMetadata(t time.Duration)           {}
 func (d *ddClientDummy) RecordGetParcelContent(t time.Duration)            {}
 func (d *ddClientDummy) RecordStoreContent(t time.Duration)                {}
 func (d *ddClientDummy) RecordStoreMetadata(t time.Duration)               {}
+func (d *ddClientDummy) RecordCommitScene(t time.Duration)                 {}
 func (d *ddClientDummy) RecordDCLAPIError(status int)                      {}
 
 type segmentClientImpl struct {
@@ -5,9 +5,11 @@
 package mocks
 
 import (
+	context "context"
 	io "io"
 	reflect "reflect"
 
+	storage "github.com/decentraland/content-service/storage"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -34,6 +36,18 @@ func (m *MockStorage) EXPECT() *MockStorageMockRecorder {
 	return m.recorder
 }
 
+// Delete mocks base method
+func (m *MockStorage) Delete(arg0 context.Context, arg1 string) error {
+	ret := m.ctrl.Call(m, "Delete", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete
+func (mr *MockStorageMockRecorder) Delete(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockStorage)(nil).Delete), arg0, arg1)
+}
+
 // DownloadFile mocks base method
 func (m *MockStorage) DownloadFile(arg0, arg1 string) error {
 	ret := m.ctrl.Call(m, "DownloadFile", arg0, arg1)
@@ -46,6 +60,19 @@ func (mr *MockStorageMockRecorder) DownloadFile(arg0, arg1 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadFile", reflect.TypeOf((*MockStorage)(nil).DownloadFile), arg0, arg1)
 }
 
+// Exists mocks base method
+func (m *MockStorage) Exists(arg0 context.Context, arg1 string) (bool, error) {
+	ret := m.ctrl.Call(m, "Exists", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Exists indicates an expected call of Exists
+func (mr *MockStorageMockRecorder) Exists(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exists", reflect.TypeOf((*MockStorage)(nil).Exists), arg0, arg1)
+}
+
 // FileSize mocks base method
 func (m *MockStorage) FileSize(arg0 string) (int64, error) {
 	ret := m.ctrl.Call(m, "FileSize", arg0)
@@ -71,6 +98,33 @@ func (mr *MockStorageMockRecorder) GetFile(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFile", reflect.TypeOf((*MockStorage)(nil).GetFile), arg0)
 }
 
+// List mocks base method
+func (m *MockStorage) List(arg0 context.Context, arg1, arg2 string, arg3 int) (*storage.ListPage, error) {
+	ret := m.ctrl.Call(m, "List", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(*storage.ListPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List
+func (mr *MockStorageMockRecorder) List(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockStorage)(nil).List), arg0, arg1, arg2, arg3)
+}
+
+// Open mocks base method
+func (m *MockStorage) Open(arg0 context.Context, arg1 string) (io.ReadCloser, *storage.FileInfo, error) {
+	ret := m.ctrl.Call(m, "Open", arg0, arg1)
+	ret0, _ := ret[0].(io.ReadCloser)
+	ret1, _ := ret[1].(*storage.FileInfo)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Open indicates an expected call of Open
+func (mr *MockStorageMockRecorder) Open(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Open", reflect.TypeOf((*MockStorage)(nil).Open), arg0, arg1)
+}
+
 // SaveFile mocks base method
 func (m *MockStorage) SaveFile(arg0 string, arg1 io.Reader, arg2 string) (string, error) {
 	ret := m.ctrl.Call(m, "SaveFile", arg0, arg1, arg2)
@@ -83,3 +137,16 @@ func (m *MockStorage) SaveFile(arg0 string, arg1 io.Reader, arg2 string) (string
 func (mr *MockStorageMockRecorder) SaveFile(arg0, arg1, arg2 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveFile", reflect.TypeOf((*MockStorage)(nil).SaveFile), arg0, arg1, arg2)
 }
+
+// Stat mocks base method
+func (m *MockStorage) Stat(arg0 context.Context, arg1 string) (*storage.FileInfo, error) {
+	ret := m.ctrl.Call(m, "Stat", arg0, arg1)
+	ret0, _ := ret[0].(*storage.FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Stat indicates an expected call of Stat
+func (mr *MockStorageMockRecorder) Stat(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stat", reflect.TypeOf((*MockStorage)(nil).Stat), arg0, arg1)
+}
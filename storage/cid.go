@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"io"
+
+	"github.com/ipsn/go-ipfs/gxlibs/github.com/ipfs/go-cid"
+	chunker "github.com/ipsn/go-ipfs/gxlibs/github.com/ipfs/go-ipfs-chunker"
+	ipld "github.com/ipsn/go-ipfs/gxlibs/github.com/ipfs/go-ipld-format"
+	"github.com/ipsn/go-ipfs/gxlibs/github.com/ipfs/go-unixfs/importer/balanced"
+	ihelper "github.com/ipsn/go-ipfs/gxlibs/github.com/ipfs/go-unixfs/importer/helpers"
+)
+
+// Computes the CID of the content with the same parameters `ipfs add` uses by default, along with
+// the cumulative size of its DAG. The blocks are discarded, only the root node is kept
+func HashFile(r io.Reader) (cid.Cid, uint64, error) {
+	params := ihelper.DagBuilderParams{
+		Dagserv:  discardDAG{},
+		Maxlinks: ihelper.DefaultLinksPerBlock,
+	}
+	nd, err := balanced.Layout(params.New(chunker.DefaultSplitter(r)))
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	size, err := nd.Size()
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	return nd.Cid(), size, nil
+}
+
+// Retrieves whether the name is a CID HashFile can compute, other names, like the variants, are not checked
+func isHashable(name string) bool {
+	c, err := cid.Decode(name)
+	return err == nil && c.Version() == 0
+}
+
+// DAG service that drops every node, the content is only hashed
+type discardDAG struct{}
+
+func (discardDAG) Get(context.Context, cid.Cid) (ipld.Node, error) {
+	return nil, ipld.ErrNotFound
+}
+
+func (discardDAG) GetMany(context.Context, []cid.Cid) <-chan *ipld.NodeOption {
+	ch := make(chan *ipld.NodeOption)
+	close(ch)
+	return ch
+}
+
+func (discardDAG) Add(context.Context, ipld.Node) error { return nil }
+
+func (discardDAG) AddMany(context.Context, []ipld.Node) error { return nil }
+
+func (discardDAG) Remove(context.Context, cid.Cid) error { return nil }
+
+func (discardDAG) RemoveMany(context.Context, []cid.Cid) error { return nil }
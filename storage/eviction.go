@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+// Eviction policies of the storage cache
+const (
+	// Least recently used first
+	LRU = "LRU"
+	// Oldest stored first, reads do not change the order
+	FIFO = "FIFO"
+)
+
+type evictionEntry struct {
+	key   string
+	value int64
+	cost  int64
+}
+
+// Keys, each one with a value, bounded by their total cost. The ones to evict are retrieved following the policy
+type evictionList struct {
+	mu      sync.Mutex
+	touch   bool
+	maxCost int64
+	cost    int64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newEvictionList(policy string, maxCost int64) *evictionList {
+	return &evictionList{
+		touch:   strings.ToUpper(policy) != FIFO,
+		maxCost: maxCost,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Retrieves the value of the key, marking it as used
+func (l *evictionList) get(key string) (int64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[key]
+	if !ok {
+		return 0, false
+	}
+	if l.touch {
+		l.order.MoveToBack(e)
+	}
+	return e.Value.(*evictionEntry).value, true
+}
+
+// Adds the key and retrieves the keys that no longer fit, which must be removed by the caller
+// A key costing more than the whole list is not added and is retrieved as evicted
+func (l *evictionList) add(key string, value int64, cost int64) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if cost > l.maxCost {
+		return []string{key}
+	}
+	if e, ok := l.entries[key]; ok {
+		entry := e.Value.(*evictionEntry)
+		l.cost += cost - entry.cost
+		entry.value, entry.cost = value, cost
+		l.order.MoveToBack(e)
+	} else {
+		l.entries[key] = l.order.PushBack(&evictionEntry{key: key, value: value, cost: cost})
+		l.cost += cost
+	}
+
+	var evicted []string
+	for l.cost > l.maxCost {
+		e := l.order.Front()
+		entry := e.Value.(*evictionEntry)
+		l.order.Remove(e)
+		delete(l.entries, entry.key)
+		l.cost -= entry.cost
+		evicted = append(evicted, entry.key)
+	}
+	return evicted
+}
+
+func (l *evictionList) remove(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.entries[key]; ok {
+		l.cost -= e.Value.(*evictionEntry).cost
+		l.order.Remove(e)
+		delete(l.entries, key)
+	}
+}
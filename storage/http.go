@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/decentraland/content-service/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// Generic object store reached through plain HTTP: objects are written with PUT, read with GET and HEAD
+// and removed with DELETE on <URL>/<cid>. Works with GCS-compatible and Azure-style blob endpoints
+// as long as authentication can be expressed as a bearer token or as part of the URL
+type HTTPStore struct {
+	URL    string
+	Token  string
+	Client *http.Client
+	Agent  *metrics.Agent
+}
+
+func NewHTTPStore(baseURL string, token string, timeout time.Duration, agent *metrics.Agent) *HTTPStore {
+	sto := new(HTTPStore)
+	sto.URL = baseURL
+	sto.Token = token
+	sto.Client = &http.Client{Timeout: timeout}
+	sto.Agent = agent
+	return sto
+}
+
+func (sto *HTTPStore) GetFile(cid string) string {
+	u, _ := url.Parse(sto.URL)
+	u.Path = path.Join(u.Path, cid)
+	return u.String()
+}
+
+func (sto *HTTPStore) SaveFile(filename string, fileDesc io.Reader, contentType string) (string, error) {
+	t := time.Now()
+	log.Debugf("Uploading file[%s] to %s", filename, sto.URL)
+	req, err := sto.request(context.Background(), http.MethodPut, filename, fileDesc)
+	if err != nil {
+		return "", err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := sto.do(req)
+	sto.Agent.RecordStorageTime(time.Since(t))
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	return sto.GetFile(filename), nil
+}
+
+func (sto *HTTPStore) DownloadFile(cid string, filePath string) error {
+	t := time.Now()
+	obj, err := sto.GetObject(cid, "")
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+
+	dir := filepath.Dir(filePath)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(dir, filepath.Base(filePath)))
+	if err != nil {
+		return InternalError{fmt.Sprintf("failed to create file %q, %v", filePath, err)}
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, obj.Body)
+	sto.Agent.RecordRetrieveTime(time.Since(t))
+	if err != nil {
+		return InternalError{err.Error()}
+	}
+	sto.Agent.RecordBytesRetrieved(n)
+	return f.Close()
+}
+
+func (sto *HTTPStore) FileSize(cid string) (int64, error) {
+	info, err := sto.Stat(context.Background(), cid)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// Retrieves the stored object, byteRange follows the Range header format and is optional
+func (sto *HTTPStore) GetObject(cid string, byteRange string) (*Object, error) {
+	req, err := sto.request(context.Background(), http.MethodGet, cid, nil)
+	if err != nil {
+		return nil, err
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+	res, err := sto.do(req)
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			return nil, RangeNotSatisfiableError{fmt.Sprintf("invalid range %s for %s", byteRange, cid)}
+		}
+		return nil, err
+	}
+	return &Object{
+		Body:          res.Body,
+		ContentType:   res.Header.Get("Content-Type"),
+		ContentLength: res.ContentLength,
+		ContentRange:  res.Header.Get("Content-Range"),
+	}, nil
+}
+
+func (sto *HTTPStore) Open(ctx context.Context, cid string) (io.ReadCloser, *FileInfo, error) {
+	req, err := sto.request(ctx, http.MethodGet, cid, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := sto.do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return res.Body, responseInfo(cid, res), nil
+}
+
+func (sto *HTTPStore) Stat(ctx context.Context, cid string) (*FileInfo, error) {
+	req, err := sto.request(ctx, http.MethodHead, cid, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := sto.do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	return responseInfo(cid, res), nil
+}
+
+func (sto *HTTPStore) Exists(ctx context.Context, cid string) (bool, error) {
+	return exists(ctx, sto, cid)
+}
+
+func (sto *HTTPStore) Delete(ctx context.Context, cid string) error {
+	req, err := sto.request(ctx, http.MethodDelete, cid, nil)
+	if err != nil {
+		return err
+	}
+	res, err := sto.do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// Plain HTTP has no way to list the objects
+func (sto *HTTPStore) List(ctx context.Context, prefix string, cursor string, limit int) (*ListPage, error) {
+	return nil, InternalError{"the HTTP storage can not list files"}
+}
+
+func responseInfo(cid string, res *http.Response) *FileInfo {
+	return &FileInfo{Cid: cid, Size: res.ContentLength, ContentType: res.Header.Get("Content-Type")}
+}
+
+func (sto *HTTPStore) request(ctx context.Context, method string, cid string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, sto.GetFile(cid), body)
+	if err != nil {
+		return nil, InternalError{err.Error()}
+	}
+	req = req.WithContext(ctx)
+	if sto.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+sto.Token)
+	}
+	return req, nil
+}
+
+// Sends the request, any status other than 2xx is turned into an error
+// The response is also retrieved on error statuses, with its body already closed
+func (sto *HTTPStore) do(req *http.Request) (*http.Response, error) {
+	res, err := sto.Client.Do(req)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, InternalError{err.Error()}
+	}
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return res, NotFoundError{"file not found"}
+	}
+	log.Errorf("%s %s: unexpected status %d", req.Method, req.URL, res.StatusCode)
+	return res, InternalError{fmt.Sprintf("unexpected status %d", res.StatusCode)}
+}
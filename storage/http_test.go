@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/decentraland/content-service/config"
+	"github.com/decentraland/content-service/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// Local stand-in of an object store, keeps the objects in memory
+type objectStoreStub struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+	auth    []string
+}
+
+func (s *objectStoreStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auth = append(s.auth, r.Header.Get("Authorization"))
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	switch r.Method {
+	case http.MethodPut:
+		b, _ := ioutil.ReadAll(r.Body)
+		s.objects[key] = b
+		s.types[key] = r.Header.Get("Content-Type")
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet, http.MethodHead:
+		b, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", s.types[key])
+		http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(b))
+	case http.MethodDelete:
+		if _, ok := s.objects[key]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHTTPStore(t *testing.T) {
+	stub := &objectStoreStub{objects: map[string][]byte{}, types: map[string]string{}}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	agent, _ := metrics.Make(config.Metrics{AppName: "", Enabled: false, AnalyticsKey: ""})
+	sto := NewHTTPStore(server.URL+"/bucket", "secret", time.Second, agent)
+
+	location, err := sto.SaveFile("QmFile", bytes.NewReader([]byte("0123456789")), "model/gltf-binary")
+	assert.Nil(t, err)
+	assert.Equal(t, server.URL+"/bucket/QmFile", location)
+	assert.Equal(t, "model/gltf-binary", stub.types["QmFile"])
+	assert.Equal(t, "Bearer secret", stub.auth[0])
+
+	size, err := sto.FileSize("QmFile")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+	_, err = sto.FileSize("QmMissing")
+	assert.IsType(t, NotFoundError{}, err)
+
+	obj, err := sto.GetObject("QmFile", "bytes=2-5")
+	assert.Nil(t, err)
+	b, _ := ioutil.ReadAll(obj.Body)
+	obj.Body.Close()
+	assert.Equal(t, "2345", string(b))
+	assert.Equal(t, "bytes 2-5/10", obj.ContentRange)
+	assert.Equal(t, "model/gltf-binary", obj.ContentType)
+
+	_, err = sto.GetObject("QmFile", "bytes=100-200")
+	assert.IsType(t, RangeNotSatisfiableError{}, err)
+
+	dir, _ := ioutil.TempDir("", "http-store")
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "file")
+	assert.Nil(t, sto.DownloadFile("QmFile", out))
+	b, _ = ioutil.ReadFile(out)
+	assert.Equal(t, "0123456789", string(b))
+	assert.IsType(t, NotFoundError{}, sto.DownloadFile("QmMissing", out))
+
+	assert.Nil(t, sto.Delete(context.Background(), "QmFile"))
+	assert.IsType(t, NotFoundError{}, sto.Delete(context.Background(), "QmFile"))
+}
+
+func TestDrivers(t *testing.T) {
+	assert.Equal(t, []string{"HTTP", "LOCAL", "REMOTE", "REPLICATED", "SHARDED"}, Drivers())
+	assert.Panics(t, func() { Register("LOCAL", func(*config.Storage, *metrics.Agent) (Storage, error) { return nil, nil }) })
+
+	sto := NewStorage(&config.Storage{StorageType: "http", HTTPConfig: config.HTTPStorage{URL: "http://store"}}, nil)
+	assert.IsType(t, &HTTPStore{}, sto)
+}
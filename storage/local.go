@@ -1,48 +1,185 @@
 package storage
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+// Directory, inside the storage one, where the corrupted files are moved
+const quarantineDir = ".quarantine"
+
+// The content type of each file is stored next to it, in a file with this suffix
+const contentTypeSuffix = ".content-type"
+
+// Directory, inside the storage one, where the files are written before being moved into place
+const tmpDir = ".tmp"
+
+// Amount of verified files remembered, each one is only hashed again once it is modified
+const verifiedFiles = 100000
+
 type Local struct {
 	Dir string
+	// Files are stored under prefix directories, Qm/ab/Qmab..., instead of all of them in Dir
+	Sharded bool
+	// Modification time of the files that matched their CID
+	verified *evictionList
 }
 
 func NewLocal(dir string) *Local {
 	sto := new(Local)
 	sto.Dir = dir
+	sto.verified = newEvictionList(LRU, verifiedFiles)
 	return sto
 }
 
+func NewShardedLocal(dir string) *Local {
+	sto := NewLocal(dir)
+	sto.Sharded = true
+	return sto
+}
+
+// Path of the stored file
+func (sto *Local) path(cid string) string {
+	if !sto.Sharded || len(cid) < 4 {
+		return filepath.Join(sto.Dir, cid)
+	}
+	return filepath.Join(sto.Dir, cid[:2], cid[2:4], cid)
+}
+
 func (sto *Local) CreateLocalDir() error {
 	return os.MkdirAll(sto.Dir, os.ModePerm)
 }
 
 func (sto *Local) GetFile(cid string) string {
-	return sto.Dir + cid
+	return sto.path(cid)
 }
 
+// The file is written to a temporary one and moved into place once it is complete and synced, so an
+// interrupted write never leaves a partial file under the CID. Files named after a CID are hashed while
+// written and rejected with CidMismatchError if the content does not match it
 func (sto *Local) SaveFile(filename string, fileDesc io.Reader, contentType string) (string, error) {
-	path := filepath.Join(sto.Dir, filename)
-	dst, err := os.Create(path)
+	path := sto.path(filename)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return "", err
+	}
+	tmp, err := sto.tempFile()
 	if err != nil {
 		return "", err
 	}
-	defer dst.Close()
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
 
-	_, err = io.Copy(dst, fileDesc)
+	if isHashable(filename) {
+		err = copyVerified(tmp, fileDesc, filename)
+	} else {
+		_, err = io.Copy(tmp, fileDesc)
+	}
 	if err != nil {
 		return "", err
 	}
+	if err := tmp.Sync(); err != nil {
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
 
+	if contentType != "" {
+		if err := sto.writeFileAtomic(path+contentTypeSuffix, []byte(contentType)); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	syncDir(filepath.Dir(path))
 	return path, nil
 }
 
+// Copies the content while hashing it, CidMismatchError if it does not match the CID
+func copyVerified(dst io.Writer, src io.Reader, cid string) error {
+	pr, pw := io.Pipe()
+	hashed := make(chan string, 1)
+	go func() {
+		c, _, err := HashFile(pr)
+		// Unblocks the copy if hashing stops before the end of the content
+		pr.CloseWithError(err)
+		if err != nil {
+			hashed <- ""
+			return
+		}
+		hashed <- c.String()
+	}()
+
+	_, err := io.Copy(io.MultiWriter(dst, pw), src)
+	pw.CloseWithError(err)
+	actual := <-hashed
+	if err != nil {
+		return err
+	}
+	if actual != cid {
+		return CidMismatchError{fmt.Sprintf("content of %s hashes to %s", cid, actual)}
+	}
+	return nil
+}
+
+func (sto *Local) tempFile() (*os.File, error) {
+	dir := filepath.Join(sto.Dir, tmpDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return ioutil.TempFile(dir, "upload-")
+}
+
+// Each write gets its own temporary file, so concurrent writes of the same file do not mix their content
+func (sto *Local) writeFileAtomic(path string, data []byte) error {
+	tmp, err := sto.tempFile()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Persists the renames done in the directory. Not every platform supports it, so failures are ignored
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	d.Close()
+}
+
+// Retrieves the content type the file was saved with, empty if unknown
+func (sto *Local) ContentType(cid string) string {
+	b, err := ioutil.ReadFile(sto.path(cid) + contentTypeSuffix)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
 func (sto *Local) DownloadFile(cid string, fileName string) error {
-	path := filepath.Join(sto.Dir, cid)
+	path := sto.path(cid)
 	in, err := os.Open(path)
 	if err != nil {
 		return NotFoundError{fmt.Sprintf("Not found: %s", cid)}
@@ -71,7 +208,7 @@ func (sto *Local) DownloadFile(cid string, fileName string) error {
 }
 
 func (sto *Local) FileSize(cid string) (int64, error) {
-	path := filepath.Join(sto.Dir, cid)
+	path := sto.path(cid)
 	i, err := os.Stat(path)
 
 	if err != nil && os.IsNotExist(err) {
@@ -82,3 +219,346 @@ func (sto *Local) FileSize(cid string) (int64, error) {
 
 	return i.Size(), nil
 }
+
+func (sto *Local) Open(ctx context.Context, cid string) (io.ReadCloser, *FileInfo, error) {
+	info, err := sto.Stat(ctx, cid)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(sto.path(cid))
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil, NotFoundError{fmt.Sprintf("Not found: %s", cid)}
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return &contextReader{ctx, f}, info, nil
+}
+
+// Retrieves the file, or a single range of it in the Range header format, so it can be served like a remote object
+func (sto *Local) GetObject(cid string, byteRange string) (*Object, error) {
+	info, err := sto.Stat(context.Background(), cid)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(sto.path(cid))
+	if err != nil && os.IsNotExist(err) {
+		return nil, NotFoundError{fmt.Sprintf("Not found: %s", cid)}
+	} else if err != nil {
+		return nil, err
+	}
+	obj := &Object{Body: f, ContentType: info.ContentType, ContentLength: info.Size}
+	if byteRange == "" {
+		return obj, nil
+	}
+	start, end, err := parseRange(byteRange, info.Size)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	obj.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, end-start+1), f}
+	obj.ContentLength = end - start + 1
+	obj.ContentRange = fmt.Sprintf("bytes %d-%d/%d", start, end, info.Size)
+	return obj, nil
+}
+
+// Retrieves the first and last byte of a single range of the Range header
+func parseRange(byteRange string, size int64) (int64, int64, error) {
+	unsatisfiable := RangeNotSatisfiableError{fmt.Sprintf("range %s not satisfiable for %d bytes", byteRange, size)}
+	spec := strings.TrimPrefix(byteRange, "bytes=")
+	i := strings.Index(spec, "-")
+	if spec == byteRange || i < 0 || strings.Contains(spec, ",") || size == 0 {
+		return 0, 0, unsatisfiable
+	}
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, unsatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, unsatisfiable
+	}
+	end := size - 1
+	if last != "" {
+		e, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || e < start {
+			return 0, 0, unsatisfiable
+		}
+		if e < end {
+			end = e
+		}
+	}
+	return start, end, nil
+}
+
+func (sto *Local) Stat(ctx context.Context, cid string) (*FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	size, err := sto.FileSize(cid)
+	if err != nil {
+		return nil, err
+	}
+	return &FileInfo{Cid: cid, Size: size, ContentType: sto.ContentType(cid)}, nil
+}
+
+func (sto *Local) Exists(ctx context.Context, cid string) (bool, error) {
+	return exists(ctx, sto, cid)
+}
+
+func (sto *Local) Delete(ctx context.Context, cid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path := sto.path(cid)
+	sto.forget(cid)
+	err := os.Remove(path)
+	if err != nil && os.IsNotExist(err) {
+		return NotFoundError{fmt.Sprintf("Not found: %s", cid)}
+	} else if err != nil {
+		return err
+	}
+	if err := os.Remove(path + contentTypeSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (sto *Local) List(ctx context.Context, prefix string, cursor string, limit int) (*ListPage, error) {
+	limit = listLimit(limit)
+	page := &ListPage{Files: []FileInfo{}}
+	// One more file is looked up to know if there is a next page
+	var files []FileInfo
+	err := filepath.Walk(sto.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() {
+			if path == filepath.Clean(sto.Dir) {
+				return nil
+			}
+			if !sto.Sharded || strings.HasPrefix(name, ".") || !sto.mayContain(path, prefix, cursor) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Sidecar and hidden files
+		if strings.Contains(name, ".") || !strings.HasPrefix(name, prefix) || name <= cursor {
+			return nil
+		}
+		files = append(files, FileInfo{Cid: name, Size: info.Size()})
+		if len(files) > limit {
+			return errListFull
+		}
+		return nil
+	})
+	if err != nil && err != errListFull {
+		return nil, err
+	}
+
+	// The walk retrieves shards and the files in the storage root separately
+	sort.Slice(files, func(i, j int) bool { return files[i].Cid < files[j].Cid })
+	if len(files) > limit {
+		files = files[:limit]
+		page.Next = files[limit-1].Cid
+	}
+	for i := range files {
+		files[i].ContentType = sto.ContentType(files[i].Cid)
+	}
+	page.Files = append(page.Files, files...)
+	return page, nil
+}
+
+var errListFull = fmt.Errorf("list full")
+
+// Checks if the shard directory may hold files matching the prefix and after the cursor
+func (sto *Local) mayContain(dir string, prefix string, cursor string) bool {
+	rel, err := filepath.Rel(sto.Dir, dir)
+	if err != nil {
+		return true
+	}
+	shard := strings.Replace(rel, string(filepath.Separator), "", -1)
+	n := len(shard)
+	if len(prefix) < n {
+		n = len(prefix)
+	}
+	if shard[:n] != prefix[:n] {
+		return false
+	}
+	if len(cursor) >= len(shard) && shard < cursor[:len(shard)] {
+		return false
+	}
+	return true
+}
+
+// Moves a corrupted file out of the storage, it is kept for inspection
+func (sto *Local) Quarantine(cid string) error {
+	dir := filepath.Join(sto.Dir, quarantineDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	path := sto.path(cid)
+	sto.forget(cid)
+	if err := os.Rename(path, filepath.Join(dir, cid)); err != nil {
+		return err
+	}
+	if err := os.Rename(path+contentTypeSuffix, filepath.Join(dir, cid)+contentTypeSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Retrieves whether the stored file, or its variant with the given encoding, still matches the CID
+// Variants are decompressed before hashing, an undecodable one does not match
+func (sto *Local) Verify(cid string, e *Encoding) (bool, error) {
+	key := cid
+	if e != nil {
+		key = VariantKey(cid, *e)
+	}
+	path := sto.path(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	modTime := info.ModTime().UnixNano()
+	if sto.verified != nil {
+		if t, ok := sto.verified.get(key); ok && t == modTime {
+			return true, nil
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	var r io.Reader = bufio.NewReader(f)
+	if e != nil {
+		d, err := e.NewReader(r)
+		if err != nil {
+			return false, nil
+		}
+		defer d.Close()
+		r = d
+	}
+	c, _, err := HashFile(r)
+	if err != nil {
+		if e != nil {
+			return false, nil
+		}
+		return false, err
+	}
+	if c.String() != cid {
+		return false, nil
+	}
+	if sto.verified != nil {
+		sto.verified.add(key, modTime, 1)
+	}
+	return true, nil
+}
+
+func (sto *Local) forget(key string) {
+	if sto.verified != nil {
+		sto.verified.remove(key)
+	}
+}
+
+type ScanReport struct {
+	// Files hashed
+	Checked int
+	// Files not matching their CID, already removed
+	Removed []string
+	// Unfinished writes and content types left without their file
+	Leftovers int
+}
+
+// Removes what interrupted writes left behind. With verify, every file named after a CID is also hashed
+// and removed if its content does not match it, as files written before writes were atomic may be partial
+func (sto *Local) Scan(ctx context.Context, verify bool) (*ScanReport, error) {
+	report := &ScanReport{Removed: []string{}}
+	unfinished, _ := ioutil.ReadDir(filepath.Join(sto.Dir, tmpDir))
+	report.Leftovers = len(unfinished)
+	if err := os.RemoveAll(filepath.Join(sto.Dir, tmpDir)); err != nil {
+		return nil, err
+	}
+	err := filepath.Walk(sto.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() {
+			if path != filepath.Clean(sto.Dir) && (!sto.Sharded || strings.HasPrefix(name, ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		switch {
+		case strings.HasSuffix(name, ".tmp"):
+			report.Leftovers++
+			return os.Remove(path)
+		case strings.HasSuffix(name, contentTypeSuffix):
+			if _, err := os.Stat(strings.TrimSuffix(path, contentTypeSuffix)); os.IsNotExist(err) {
+				report.Leftovers++
+				return os.Remove(path)
+			}
+		case verify && isHashable(name):
+			report.Checked++
+			ok, err := matchesCid(path, name)
+			if err != nil || ok {
+				return err
+			}
+			report.Removed = append(report.Removed, name)
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			if err := os.Remove(path + contentTypeSuffix); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func matchesCid(path string, cid string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	c, _, err := HashFile(bufio.NewReader(f))
+	if err != nil {
+		return false, err
+	}
+	return c.String() == cid, nil
+}
@@ -0,0 +1,422 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalPath(t *testing.T) {
+	for _, tc := range localPathTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.result, tc.local.GetFile(tc.cid))
+		})
+	}
+}
+
+type localPathData struct {
+	name   string
+	local  *Local
+	cid    string
+	result string
+}
+
+var localPathTestCases = []localPathData{
+	{
+		name:   "Flat",
+		local:  NewLocal("tmp/"),
+		cid:    "QmabcdefBqGjgzZPKg3mDJyPZbZd6BsZx8GPK6uzxWxGzx",
+		result: "tmp/QmabcdefBqGjgzZPKg3mDJyPZbZd6BsZx8GPK6uzxWxGzx",
+	}, {
+		name:   "Sharded",
+		local:  NewShardedLocal("tmp/"),
+		cid:    "QmabcdefBqGjgzZPKg3mDJyPZbZd6BsZx8GPK6uzxWxGzx",
+		result: "tmp/Qm/ab/QmabcdefBqGjgzZPKg3mDJyPZbZd6BsZx8GPK6uzxWxGzx",
+	}, {
+		name:   "Sharded short name",
+		local:  NewShardedLocal("tmp"),
+		cid:    "Qm",
+		result: "tmp/Qm",
+	},
+}
+
+func TestShardedLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sharded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sto := NewShardedLocal(dir)
+	// CID of "content"
+	cid := "QmbSnCcHziqhjNRyaunfcCvxPiV3fNL3fWL8nUrp5yqwD5"
+	_, err = sto.SaveFile(cid, bytes.NewReader([]byte("content")), "text/plain")
+	assert.Nil(t, err)
+
+	_, err = os.Stat(filepath.Join(dir, "Qm", "bS", cid))
+	assert.Nil(t, err, "the file is stored under its prefix directories")
+	size, err := sto.FileSize(cid)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), size)
+	assert.Equal(t, "text/plain", sto.ContentType(cid))
+
+	out := filepath.Join(dir, "out", "file")
+	assert.Nil(t, sto.DownloadFile(cid, out))
+	b, _ := ioutil.ReadFile(out)
+	assert.Equal(t, "content", string(b))
+
+	assert.Nil(t, sto.Delete(context.Background(), cid))
+	assert.IsType(t, NotFoundError{}, sto.Delete(context.Background(), cid))
+	assert.Equal(t, "", sto.ContentType(cid))
+}
+
+func TestLocalList(t *testing.T) {
+	for _, tc := range localListTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "list")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			sto := NewLocal(dir)
+			sto.Sharded = tc.sharded
+			for _, cid := range []string{"QmC", "QmAb", "QmAa", "QmB", "QxA"} {
+				_, _ = sto.SaveFile(cid, bytes.NewReader([]byte(cid)), "text/plain")
+			}
+			_ = sto.Quarantine("QxA")
+
+			var pages [][]string
+			cursor := ""
+			for {
+				page, err := sto.List(context.Background(), tc.prefix, cursor, 2)
+				assert.Nil(t, err)
+				var cids []string
+				for _, f := range page.Files {
+					cids = append(cids, f.Cid)
+					assert.Equal(t, int64(len(f.Cid)), f.Size)
+					assert.Equal(t, "text/plain", f.ContentType)
+				}
+				pages = append(pages, cids)
+				if page.Next == "" {
+					break
+				}
+				cursor = page.Next
+			}
+			assert.Equal(t, tc.pages, pages)
+		})
+	}
+}
+
+func TestLocalListWithoutLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sto := NewLocal(dir)
+	for _, cid := range []string{"QmA", "QmB"} {
+		_, _ = sto.SaveFile(cid, bytes.NewReader([]byte(cid)), "text/plain")
+	}
+
+	for _, limit := range []int{0, -1} {
+		page, err := sto.List(context.Background(), "", "", limit)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(page.Files))
+		assert.Empty(t, page.Next)
+	}
+}
+
+type localListCase struct {
+	name    string
+	sharded bool
+	prefix  string
+	pages   [][]string
+}
+
+var localListTestCases = []localListCase{
+	{name: "Flat", pages: [][]string{{"QmAa", "QmAb"}, {"QmB", "QmC"}}},
+	{name: "Sharded", sharded: true, pages: [][]string{{"QmAa", "QmAb"}, {"QmB", "QmC"}}},
+	{name: "Prefix", prefix: "QmA", pages: [][]string{{"QmAa", "QmAb"}}},
+	{name: "Sharded prefix", sharded: true, prefix: "QmA", pages: [][]string{{"QmAa", "QmAb"}}},
+}
+
+func TestLocalOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sto := NewShardedLocal(dir)
+	_, _ = sto.SaveFile("QmFile", bytes.NewReader([]byte("content")), "text/plain")
+
+	r, info, err := sto.Open(context.Background(), "QmFile")
+	assert.Nil(t, err)
+	b, _ := ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "content", string(b))
+	assert.Equal(t, &FileInfo{Cid: "QmFile", Size: 7, ContentType: "text/plain"}, info)
+
+	_, _, err = sto.Open(context.Background(), "QmMissing")
+	assert.IsType(t, NotFoundError{}, err)
+	ok, err := sto.Exists(context.Background(), "QmFile")
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	ok, err = sto.Exists(context.Background(), "QmMissing")
+	assert.False(t, ok)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, _, _ = sto.Open(ctx, "QmFile")
+	cancel()
+	_, err = ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, context.Canceled, err)
+	_, err = sto.Stat(ctx, "QmFile")
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestLocalGetObject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "object")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sto := NewShardedLocal(dir)
+	_, _ = sto.SaveFile("QmFile", bytes.NewReader([]byte("0123456789")), "text/plain")
+
+	for _, tc := range localObjectTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			obj, err := sto.GetObject("QmFile", tc.byteRange)
+			if tc.err != nil {
+				assert.IsType(t, tc.err, err)
+				return
+			}
+			assert.Nil(t, err)
+			b, _ := ioutil.ReadAll(obj.Body)
+			obj.Body.Close()
+			assert.Equal(t, tc.body, string(b))
+			assert.Equal(t, int64(len(tc.body)), obj.ContentLength)
+			assert.Equal(t, tc.contentRange, obj.ContentRange)
+			assert.Equal(t, "text/plain", obj.ContentType)
+		})
+	}
+
+	_, err = sto.GetObject("QmMissing", "")
+	assert.IsType(t, NotFoundError{}, err)
+}
+
+type localObjectCase struct {
+	name         string
+	byteRange    string
+	body         string
+	contentRange string
+	err          error
+}
+
+var localObjectTestCases = []localObjectCase{
+	{name: "Whole file", body: "0123456789"},
+	{name: "Range", byteRange: "bytes=2-5", body: "2345", contentRange: "bytes 2-5/10"},
+	{name: "Open range", byteRange: "bytes=7-", body: "789", contentRange: "bytes 7-9/10"},
+	{name: "Suffix range", byteRange: "bytes=-3", body: "789", contentRange: "bytes 7-9/10"},
+	{name: "Range past the end", byteRange: "bytes=8-20", body: "89", contentRange: "bytes 8-9/10"},
+	{name: "Not satisfiable", byteRange: "bytes=10-20", err: RangeNotSatisfiableError{}},
+	{name: "Malformed", byteRange: "bytes=5-2", err: RangeNotSatisfiableError{}},
+}
+
+func TestRedirectable(t *testing.T) {
+	local := NewLocal("/tmp")
+	remote := NewHTTPStore("http://localhost", "", 0, nil)
+	replicated, _ := NewReplicated([]Storage{local, remote}, 1, "")
+	assert.False(t, Redirectable(local))
+	assert.False(t, Redirectable(replicated))
+	assert.False(t, Redirectable(&Tiered{Remote: replicated}))
+	assert.True(t, Redirectable(remote))
+	assert.True(t, Redirectable(&Tiered{Remote: remote}))
+	replicated, _ = NewReplicated([]Storage{remote, local}, 1, "")
+	assert.False(t, Redirectable(replicated), "clients may be sent to any replica")
+
+	s3 := &S3{URL: "https://s3bucket.com"}
+	assert.True(t, RedirectsVariants(s3))
+	assert.True(t, RedirectsVariants(&Tiered{Remote: s3}))
+	assert.False(t, RedirectsVariants(remote), "the HTTP storage may not send the encoding")
+	assert.False(t, RedirectsVariants(local))
+}
+
+// CID of "content"
+const contentCid = "QmbSnCcHziqhjNRyaunfcCvxPiV3fNL3fWL8nUrp5yqwD5"
+
+func TestLocalSaveAtomic(t *testing.T) {
+	for _, tc := range localSaveTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "save")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			sto := NewShardedLocal(dir)
+
+			_, err = sto.SaveFile(tc.cid, tc.reader(), "text/plain")
+			if tc.err == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.IsType(t, tc.err, err)
+			}
+			_, err = os.Stat(sto.GetFile(tc.cid))
+			assert.Equal(t, tc.stored, err == nil)
+			tmp, _ := ioutil.ReadDir(filepath.Join(dir, tmpDir))
+			assert.Empty(t, tmp, "no temporary file is left behind")
+		})
+	}
+}
+
+func TestLocalSaveConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "save")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sto := NewShardedLocal(dir)
+
+	types := []string{"text/plain", "application/octet-stream"}
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(contentType string) {
+			defer wg.Done()
+			_, err := sto.SaveFile(contentCid, bytes.NewReader([]byte("content")), contentType)
+			errs <- err
+		}(types[i%2])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.Nil(t, err)
+	}
+	assert.Contains(t, types, sto.ContentType(contentCid))
+	tmp, _ := ioutil.ReadDir(filepath.Join(dir, tmpDir))
+	assert.Empty(t, tmp, "no temporary file is left behind")
+}
+
+type localSaveCase struct {
+	name   string
+	cid    string
+	reader func() io.Reader
+	err    error
+	stored bool
+}
+
+var localSaveTestCases = []localSaveCase{
+	{
+		name:   "Matching CID",
+		cid:    contentCid,
+		reader: func() io.Reader { return bytes.NewReader([]byte("content")) },
+		stored: true,
+	}, {
+		name:   "Mismatching CID",
+		cid:    contentCid,
+		reader: func() io.Reader { return bytes.NewReader([]byte("other content")) },
+		err:    CidMismatchError{},
+	}, {
+		name:   "Interrupted write",
+		cid:    contentCid,
+		reader: func() io.Reader { return io.MultiReader(bytes.NewReader([]byte("cont")), &failingReader{}) },
+		err:    InternalError{},
+	}, {
+		name:   "Not a CID",
+		cid:    contentCid + ".gz",
+		reader: func() io.Reader { return bytes.NewReader([]byte("anything")) },
+		stored: true,
+	},
+}
+
+type failingReader struct{}
+
+func (*failingReader) Read(p []byte) (int, error) {
+	return 0, InternalError{"connection reset"}
+}
+
+func TestLocalScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sto := NewShardedLocal(dir)
+	_, _ = sto.SaveFile(contentCid, bytes.NewReader([]byte("content")), "text/plain")
+
+	// Truncated by a write done before they were atomic
+	partial := "QmdmQXB2mzChmMeKY47C43LxUdg1NDJ5MWcKMKxDu7RgQm"
+	_ = os.MkdirAll(filepath.Dir(sto.GetFile(partial)), os.ModePerm)
+	_ = ioutil.WriteFile(sto.GetFile(partial), []byte("trunc"), 0644)
+	_ = ioutil.WriteFile(sto.GetFile(partial)+contentTypeSuffix, []byte("text/plain"), 0644)
+	// Unfinished writes
+	_ = os.MkdirAll(filepath.Join(dir, tmpDir), os.ModePerm)
+	_ = ioutil.WriteFile(filepath.Join(dir, tmpDir, "upload-1"), []byte("cont"), 0644)
+	_ = os.MkdirAll(filepath.Dir(sto.GetFile("QmOrphan")), os.ModePerm)
+	_ = ioutil.WriteFile(sto.GetFile("QmOrphan")+contentTypeSuffix, []byte("text/plain"), 0644)
+
+	report, err := sto.Scan(context.Background(), false)
+	assert.Nil(t, err)
+	assert.Equal(t, &ScanReport{Removed: []string{}, Leftovers: 2}, report)
+	_, err = os.Stat(filepath.Join(dir, tmpDir))
+	assert.True(t, os.IsNotExist(err))
+
+	report, err = sto.Scan(context.Background(), true)
+	assert.Nil(t, err)
+	assert.Equal(t, &ScanReport{Checked: 2, Removed: []string{partial}}, report)
+	_, err = os.Stat(sto.GetFile(partial))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(sto.GetFile(partial) + contentTypeSuffix)
+	assert.True(t, os.IsNotExist(err))
+	ok, _ := sto.Exists(context.Background(), contentCid)
+	assert.True(t, ok)
+}
+
+func TestLocalVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sto := NewShardedLocal(dir)
+	_, _ = sto.SaveFile(contentCid, bytes.NewReader([]byte("content")), "text/plain")
+
+	gz := Encodings[0]
+	var buf bytes.Buffer
+	w, _ := gz.NewWriter(&buf)
+	_, _ = w.Write([]byte("content"))
+	_ = w.Close()
+	_, _ = sto.SaveFile(VariantKey(contentCid, gz), &buf, "text/plain")
+
+	ok, err := sto.Verify(contentCid, nil)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = sto.Verify(contentCid, &gz)
+	assert.Nil(t, err)
+	assert.True(t, ok, "the variant is decompressed before hashing")
+
+	// Modified after being verified
+	path := sto.GetFile(contentCid)
+	_ = ioutil.WriteFile(path, []byte("other"), 0644)
+	later := time.Now().Add(time.Minute)
+	_ = os.Chtimes(path, later, later)
+	ok, err = sto.Verify(contentCid, nil)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	path = sto.GetFile(VariantKey(contentCid, gz))
+	_ = ioutil.WriteFile(path, []byte("not gzip"), 0644)
+	_ = os.Chtimes(path, later, later)
+	ok, err = sto.Verify(contentCid, &gz)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/decentraland/content-service/config"
+	"github.com/decentraland/content-service/metrics"
+)
+
+// Builds a storage backend from the configuration
+type Driver func(conf *config.Storage, agent *metrics.Agent) (Storage, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+// Makes a storage backend available by name, the name is the storageType set in the configuration
+// Registering the same name twice panics
+func Register(name string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("storage: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic(fmt.Sprintf("storage: Register called twice for driver %s", name))
+	}
+	drivers[name] = driver
+}
+
+// Retrieves the sorted names of the registered drivers
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,529 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Storage keeping a copy of every file in each one of the replicas
+// A write succeeds once WriteQuorum replicas stored the file, reads fall back across the replicas and
+// the copies missing in a replica are restored by Repair
+type Replicated struct {
+	Replicas    []Storage
+	WriteQuorum int
+	// Directory of the files buffered while they are copied, the system one when empty
+	Workdir string
+	mu      sync.Mutex
+	// Files known to be missing in some replica
+	pending map[string]struct{}
+	// Files being written, with the amount of writes in progress. Some replicas may not have them yet
+	writing map[string]int
+}
+
+// A quorum of 0 means a majority of the replicas
+func NewReplicated(replicas []Storage, writeQuorum int, workdir string) (*Replicated, error) {
+	if len(replicas) == 0 {
+		return nil, fmt.Errorf("no replicas")
+	}
+	if writeQuorum == 0 {
+		writeQuorum = len(replicas)/2 + 1
+	}
+	if writeQuorum < 1 || writeQuorum > len(replicas) {
+		return nil, fmt.Errorf("invalid write quorum %d for %d replicas", writeQuorum, len(replicas))
+	}
+	return &Replicated{
+		Replicas:    replicas,
+		WriteQuorum: writeQuorum,
+		Workdir:     workdir,
+		pending:     make(map[string]struct{}),
+		writing:     make(map[string]int),
+	}, nil
+}
+
+// The location in the first replica, unless the file may be missing in it. Then the location in the first
+// replica known to have it
+func (sto *Replicated) GetFile(cid string) string {
+	if !sto.isOutOfSync(cid) {
+		return sto.Replicas[0].GetFile(cid)
+	}
+	for _, r := range sto.Replicas {
+		if ok, err := r.Exists(context.Background(), cid); err == nil && ok {
+			return r.GetFile(cid)
+		}
+	}
+	return sto.Replicas[0].GetFile(cid)
+}
+
+// The file is buffered so every replica reads it at its own pace
+// The location retrieved is the one of the first replica storing it
+func (sto *Replicated) SaveFile(filename string, fileDesc io.Reader, contentType string) (string, error) {
+	tmp, err := ioutil.TempFile(sto.Workdir, "replica-")
+	if err != nil {
+		return "", InternalError{err.Error()}
+	}
+	_, err = io.Copy(tmp, fileDesc)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	type result struct {
+		location string
+		err      error
+	}
+	results := make(chan result, len(sto.Replicas))
+	sto.startWriting(filename)
+	for _, r := range sto.Replicas {
+		go func(r Storage) {
+			location, err := saveFromFile(r, filename, tmp.Name(), contentType)
+			if err != nil {
+				log.WithError(err).Errorf("fail to store CID[%s] in replica %T", filename, r)
+				sto.markPending(filename)
+			}
+			results <- result{location, err}
+		}(r)
+	}
+
+	// The remaining replicas keep writing in the background, the buffered file is removed once they finish
+	received := 0
+	defer func() {
+		go func(remaining int) {
+			for i := 0; i < remaining; i++ {
+				<-results
+			}
+			os.Remove(tmp.Name())
+			sto.finishWriting(filename)
+		}(len(sto.Replicas) - received)
+	}()
+
+	var location string
+	var lastErr error
+	acks, fails := 0, 0
+	for received < len(sto.Replicas) {
+		res := <-results
+		received++
+		if res.err != nil {
+			lastErr = res.err
+			fails++
+			if fails > len(sto.Replicas)-sto.WriteQuorum {
+				return "", lastErr
+			}
+			continue
+		}
+		if acks == 0 {
+			location = res.location
+		}
+		acks++
+		if acks == sto.WriteQuorum {
+			return location, nil
+		}
+	}
+	return "", lastErr
+}
+
+func (sto *Replicated) DownloadFile(cid string, fileName string) error {
+	return sto.fallback(cid, func(r Storage) error {
+		return r.DownloadFile(cid, fileName)
+	})
+}
+
+func (sto *Replicated) FileSize(cid string) (int64, error) {
+	var size int64
+	err := sto.fallback(cid, func(r Storage) error {
+		s, err := r.FileSize(cid)
+		size = s
+		return err
+	})
+	return size, err
+}
+
+// Ranges are retrieved from the first replica having the file
+func (sto *Replicated) GetObject(cid string, byteRange string) (*Object, error) {
+	var obj *Object
+	err := sto.fallback(cid, func(r Storage) error {
+		getter, ok := r.(objectGetter)
+		if !ok {
+			return InternalError{fmt.Sprintf("%T can not retrieve objects", r)}
+		}
+		o, err := getter.GetObject(cid, byteRange)
+		if _, ok := err.(RangeNotSatisfiableError); ok {
+			return &stopFallback{err}
+		}
+		obj = o
+		return err
+	})
+	if stop, ok := err.(*stopFallback); ok {
+		return nil, stop.err
+	}
+	return obj, err
+}
+
+func (sto *Replicated) Open(ctx context.Context, cid string) (io.ReadCloser, *FileInfo, error) {
+	var reader io.ReadCloser
+	var info *FileInfo
+	err := sto.fallback(cid, func(r Storage) error {
+		var err error
+		reader, info, err = r.Open(ctx, cid)
+		return err
+	})
+	return reader, info, err
+}
+
+func (sto *Replicated) Stat(ctx context.Context, cid string) (*FileInfo, error) {
+	var info *FileInfo
+	err := sto.fallback(cid, func(r Storage) error {
+		var err error
+		info, err = r.Stat(ctx, cid)
+		return err
+	})
+	return info, err
+}
+
+func (sto *Replicated) Exists(ctx context.Context, cid string) (bool, error) {
+	return exists(ctx, sto, cid)
+}
+
+// Removes the file from every replica, NotFoundError if no replica has it
+func (sto *Replicated) Delete(ctx context.Context, cid string) error {
+	deleted := false
+	var lastErr error
+	for _, r := range sto.Replicas {
+		err := r.Delete(ctx, cid)
+		switch err.(type) {
+		case nil:
+			deleted = true
+		case NotFoundError:
+		default:
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+	if !deleted {
+		return NotFoundError{fmt.Sprintf("Not found: %s", cid)}
+	}
+	sto.mu.Lock()
+	delete(sto.pending, cid)
+	sto.mu.Unlock()
+	return nil
+}
+
+// Merges the pages of every replica, so a file is listed as long as one replica has it
+func (sto *Replicated) List(ctx context.Context, prefix string, cursor string, limit int) (*ListPage, error) {
+	limit = listLimit(limit)
+	files := make(map[string]FileInfo)
+	more := false
+	var lastErr error
+	listed := 0
+	for _, r := range sto.Replicas {
+		page, err := r.List(ctx, prefix, cursor, limit)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		listed++
+		for _, f := range page.Files {
+			if _, ok := files[f.Cid]; !ok {
+				files[f.Cid] = f
+			}
+		}
+		more = more || page.Next != ""
+	}
+	if listed == 0 {
+		return nil, lastErr
+	}
+
+	page := &ListPage{Files: make([]FileInfo, 0, len(files))}
+	for _, f := range files {
+		page.Files = append(page.Files, f)
+	}
+	sort.Slice(page.Files, func(i, j int) bool { return page.Files[i].Cid < page.Files[j].Cid })
+	if len(page.Files) > limit {
+		page.Files = page.Files[:limit]
+		more = true
+	}
+	if more && len(page.Files) > 0 {
+		page.Next = page.Files[len(page.Files)-1].Cid
+	}
+	return page, nil
+}
+
+// Error ending the fallback, no other replica is tried
+type stopFallback struct {
+	err error
+}
+
+func (e *stopFallback) Error() string {
+	return e.err.Error()
+}
+
+// Runs the read in each replica until one succeeds
+// A replica missing the file is recorded so the next repair restores it
+func (sto *Replicated) fallback(cid string, read func(r Storage) error) error {
+	var lastErr error
+	missing := false
+	for _, r := range sto.Replicas {
+		err := read(r)
+		if err == nil {
+			if missing {
+				sto.markPending(cid)
+			}
+			return nil
+		}
+		if _, ok := err.(*stopFallback); ok {
+			return err
+		}
+		if _, ok := err.(NotFoundError); ok {
+			missing = true
+		} else {
+			log.WithError(err).Errorf("fail to read CID[%s] from replica %T", cid, r)
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+	return NotFoundError{fmt.Sprintf("Not found: %s", cid)}
+}
+
+func (sto *Replicated) startWriting(cid string) {
+	sto.mu.Lock()
+	defer sto.mu.Unlock()
+	sto.writing[cid]++
+}
+
+func (sto *Replicated) finishWriting(cid string) {
+	sto.mu.Lock()
+	defer sto.mu.Unlock()
+	if sto.writing[cid] <= 1 {
+		delete(sto.writing, cid)
+		return
+	}
+	sto.writing[cid]--
+}
+
+// Retrieves whether some replica may be missing the file, either being written or known to be missing
+func (sto *Replicated) isOutOfSync(cid string) bool {
+	sto.mu.Lock()
+	defer sto.mu.Unlock()
+	_, pending := sto.pending[cid]
+	return pending || sto.writing[cid] > 0
+}
+
+func (sto *Replicated) markPending(cid string) {
+	sto.mu.Lock()
+	defer sto.mu.Unlock()
+	sto.pending[cid] = struct{}{}
+}
+
+// Files known to be missing in some replica
+func (sto *Replicated) Pending() []string {
+	sto.mu.Lock()
+	defer sto.mu.Unlock()
+	ret := make([]string, 0, len(sto.pending))
+	for cid := range sto.pending {
+		ret = append(ret, cid)
+	}
+	return ret
+}
+
+type RepairReport struct {
+	Checked int `json:"checked"`
+	// Copies restored
+	Repaired int `json:"repaired"`
+	// Files that could not be checked or restored, they are retried in the next repair
+	Failed int `json:"failed"`
+	// Files missing in every replica
+	Lost []string `json:"lost"`
+}
+
+// Copies the given files, along with the pending ones, to the replicas missing them
+func (sto *Replicated) Repair(cids []string) *RepairReport {
+	report := &RepairReport{Lost: []string{}}
+	seen := make(map[string]bool)
+	for _, cid := range append(sto.Pending(), cids...) {
+		if seen[cid] {
+			continue
+		}
+		seen[cid] = true
+		report.Checked++
+
+		repaired, err := sto.repair(cid)
+		report.Repaired += repaired
+		switch err.(type) {
+		case nil:
+			sto.mu.Lock()
+			delete(sto.pending, cid)
+			sto.mu.Unlock()
+			report.Repaired += sto.repairVariants(cid)
+		case NotFoundError:
+			report.Lost = append(report.Lost, cid)
+			sto.mu.Lock()
+			delete(sto.pending, cid)
+			sto.mu.Unlock()
+		default:
+			log.WithError(err).Errorf("fail to repair CID[%s]", cid)
+			report.Failed++
+			sto.markPending(cid)
+		}
+	}
+	return report
+}
+
+// Variants are optional, one no replica has is not lost. Retrieves the amount of copies made
+func (sto *Replicated) repairVariants(cid string) int {
+	if _, ok := variantEncoding(cid); ok {
+		return 0
+	}
+	repaired := 0
+	for _, e := range Encodings {
+		key := VariantKey(cid, e)
+		n, err := sto.repair(key)
+		repaired += n
+		switch err.(type) {
+		case nil, NotFoundError:
+		default:
+			log.WithError(err).Errorf("fail to repair %s", key)
+			sto.markPending(key)
+		}
+	}
+	return repaired
+}
+
+// Retrieves the amount of replicas the file was copied to
+func (sto *Replicated) repair(cid string) (int, error) {
+	var source Storage
+	var missing []Storage
+	for _, r := range sto.Replicas {
+		_, err := r.FileSize(cid)
+		switch err.(type) {
+		case nil:
+			if source == nil {
+				source = r
+			}
+		case NotFoundError:
+			missing = append(missing, r)
+		default:
+			// The replica is not reachable, it is checked again in the next repair
+			return 0, err
+		}
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+	if source == nil {
+		return 0, NotFoundError{fmt.Sprintf("Not found: %s", cid)}
+	}
+
+	tmp, err := ioutil.TempFile(sto.Workdir, "repair-")
+	if err != nil {
+		return 0, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	contentType, err := fetch(context.Background(), source, cid, tmp.Name())
+	if err != nil {
+		return 0, err
+	}
+
+	repaired := 0
+	for _, r := range missing {
+		if _, err := saveFromFile(r, cid, tmp.Name(), contentType); err != nil {
+			return repaired, err
+		}
+		repaired++
+	}
+	return repaired, nil
+}
+
+// Repairs the pending files every interval, along with the next batch of the files retrieved by list
+// Every stored file is eventually checked, without checking all of them each time. A batch of 0 only
+// repairs the pending files
+func (sto *Replicated) StartRepair(interval time.Duration, batch int, list func() ([]string, error)) chan<- struct{} {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		cursor := ""
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				var cids []string
+				if batch > 0 {
+					all, err := list()
+					if err != nil {
+						log.WithError(err).Error("repair: fail to list the stored files")
+					}
+					cids, cursor = nextBatch(all, cursor, batch)
+				}
+				report := sto.Repair(cids)
+				log.WithFields(log.Fields{
+					"checked":  report.Checked,
+					"repaired": report.Repaired,
+					"failed":   report.Failed,
+					"lost":     len(report.Lost),
+				}).Info("repair: finished")
+			}
+		}
+	}()
+	return stop
+}
+
+// Retrieves up to batch files following the cursor, and the cursor of the next batch
+// Once the last file is reached it starts again from the first one
+func nextBatch(cids []string, cursor string, batch int) ([]string, string) {
+	sort.Strings(cids)
+	start := sort.Search(len(cids), func(i int) bool { return cids[i] > cursor })
+	if start == len(cids) {
+		start = 0
+	}
+	end := start + batch
+	if end > len(cids) {
+		end = len(cids)
+	}
+	if start == end {
+		return nil, ""
+	}
+	return cids[start:end], cids[end-1]
+}
+
+func saveFromFile(sto Storage, cid string, path string, contentType string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", InternalError{err.Error()}
+	}
+	defer f.Close()
+	return sto.SaveFile(cid, f, contentType)
+}
+
+// Downloads the file keeping its content type
+func fetch(ctx context.Context, sto Storage, cid string, path string) (string, error) {
+	r, info, err := sto.Open(ctx, cid)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	f, err := os.Create(path)
+	if err != nil {
+		return "", InternalError{err.Error()}
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return "", InternalError{err.Error()}
+	}
+	return info.ContentType, f.Close()
+}
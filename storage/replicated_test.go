@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Replica that is not reachable
+type failingStorage struct {
+	Storage
+}
+
+func (failingStorage) SaveFile(string, io.Reader, string) (string, error) {
+	return "", InternalError{"unreachable"}
+}
+
+func (failingStorage) FileSize(string) (int64, error) {
+	return 0, InternalError{"unreachable"}
+}
+
+func (failingStorage) DownloadFile(string, string) error {
+	return InternalError{"unreachable"}
+}
+
+func (failingStorage) Stat(context.Context, string) (*FileInfo, error) {
+	return nil, InternalError{"unreachable"}
+}
+
+func (failingStorage) List(context.Context, string, string, int) (*ListPage, error) {
+	return nil, InternalError{"unreachable"}
+}
+
+// Replica rejecting the writes, what it already has is still readable
+type readOnlyStorage struct {
+	Storage
+}
+
+func (readOnlyStorage) SaveFile(string, io.Reader, string) (string, error) {
+	return "", InternalError{"read only"}
+}
+
+func newReplicas(t *testing.T, n int) ([]Storage, func()) {
+	dir, err := ioutil.TempDir("", "replicas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicas := make([]Storage, n)
+	for i := range replicas {
+		replicas[i] = NewLocal(filepath.Join(dir, string('a'+rune(i))))
+		_ = replicas[i].(*Local).CreateLocalDir()
+	}
+	return replicas, func() { os.RemoveAll(dir) }
+}
+
+func TestReplicatedQuorum(t *testing.T) {
+	for _, tc := range quorumTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			replicas, cleanup := newReplicas(t, 3)
+			defer cleanup()
+			for i := 0; i < tc.failing; i++ {
+				replicas[i] = failingStorage{}
+			}
+			sto, err := NewReplicated(replicas, tc.quorum, "")
+			assert.Nil(t, err)
+
+			_, err = sto.SaveFile("QmFile", bytes.NewReader([]byte("content")), "text/plain")
+			if tc.fails {
+				assert.NotNil(t, err)
+				assert.Equal(t, []string{"QmFile"}, sto.Pending())
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+
+	_, err := NewReplicated(make([]Storage, 2), 3, "")
+	assert.NotNil(t, err)
+	sto, _ := NewReplicated(make([]Storage, 3), 0, "")
+	assert.Equal(t, 2, sto.WriteQuorum, "a majority by default")
+}
+
+type quorumCase struct {
+	name    string
+	quorum  int
+	failing int
+	fails   bool
+}
+
+var quorumTestCases = []quorumCase{
+	{name: "All replicas", quorum: 3},
+	{name: "Quorum reached", quorum: 2, failing: 1},
+	{name: "Quorum not reached", quorum: 2, failing: 2, fails: true},
+	{name: "Single replica", quorum: 1, failing: 2},
+}
+
+func TestReplicatedReadFallback(t *testing.T) {
+	replicas, cleanup := newReplicas(t, 3)
+	defer cleanup()
+	_, _ = replicas[2].SaveFile("QmFile", bytes.NewReader([]byte("content")), "")
+	replicas[0] = failingStorage{}
+	sto, _ := NewReplicated(replicas, 1, "")
+
+	size, err := sto.FileSize("QmFile")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), size)
+	assert.Equal(t, []string{"QmFile"}, sto.Pending(), "the replica missing it is repaired later")
+
+	out := filepath.Join(replicas[1].(*Local).Dir, "..", "out")
+	assert.Nil(t, sto.DownloadFile("QmFile", out))
+	b, _ := ioutil.ReadFile(out)
+	assert.Equal(t, "content", string(b))
+
+	_, err = sto.FileSize("QmMissing")
+	assert.IsType(t, InternalError{}, err, "an unreachable replica may have it")
+
+	sto.Replicas = sto.Replicas[1:]
+	_, err = sto.FileSize("QmMissing")
+	assert.IsType(t, NotFoundError{}, err)
+}
+
+func TestReplicatedRepair(t *testing.T) {
+	replicas, cleanup := newReplicas(t, 3)
+	defer cleanup()
+	_, _ = replicas[1].SaveFile("QmFile", bytes.NewReader([]byte("content")), "text/plain")
+	_, _ = replicas[0].SaveFile("QmOther", bytes.NewReader([]byte("other")), "")
+	_, _ = replicas[1].SaveFile("QmOther", bytes.NewReader([]byte("other")), "")
+	_, _ = replicas[2].SaveFile("QmOther", bytes.NewReader([]byte("other")), "")
+	_, _ = replicas[2].SaveFile("QmOther.gz", bytes.NewReader([]byte("gzipped")), "")
+	sto, _ := NewReplicated(replicas, 2, "")
+
+	report := sto.Repair([]string{"QmFile", "QmOther", "QmLost"})
+	assert.Equal(t, 3, report.Checked)
+	assert.Equal(t, 4, report.Repaired, "variants are repaired along with their file")
+	assert.Equal(t, 0, report.Failed)
+	assert.Equal(t, []string{"QmLost"}, report.Lost)
+	for _, r := range replicas {
+		size, err := r.FileSize("QmFile")
+		assert.Nil(t, err)
+		assert.Equal(t, int64(7), size)
+		assert.Equal(t, "text/plain", r.(*Local).ContentType("QmFile"))
+		ok, _ := r.Exists(context.Background(), "QmOther.gz")
+		assert.True(t, ok)
+	}
+
+	// An unreachable replica is retried in the next repair
+	sto.Replicas = append(sto.Replicas, failingStorage{})
+	report = sto.Repair([]string{"QmFile"})
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, []string{"QmFile"}, sto.Pending())
+
+	sto.Replicas = replicas
+	assert.Nil(t, sto.Delete(context.Background(), "QmFile"))
+	assert.IsType(t, NotFoundError{}, sto.Delete(context.Background(), "QmFile"))
+	pending := sto.Pending()
+	sort.Strings(pending)
+	assert.Empty(t, pending)
+}
+
+func TestReplicatedGetFile(t *testing.T) {
+	replicas, cleanup := newReplicas(t, 2)
+	defer cleanup()
+	_, _ = replicas[0].SaveFile("QmOther", bytes.NewReader([]byte("other")), "")
+	workdir := filepath.Join(replicas[0].(*Local).Dir, "..", "work")
+	sto, _ := NewReplicated([]Storage{readOnlyStorage{replicas[0]}, replicas[1]}, 1, workdir)
+
+	_, err := sto.SaveFile("QmFile", bytes.NewReader([]byte("content")), "")
+	assert.NotNil(t, err, "the files are buffered in the workdir")
+
+	_ = os.MkdirAll(workdir, os.ModePerm)
+	_, err = sto.SaveFile("QmFile", bytes.NewReader([]byte("content")), "")
+	assert.Nil(t, err)
+	assert.Equal(t, replicas[1].GetFile("QmFile"), sto.GetFile("QmFile"), "the first replica missed the write")
+	assert.Equal(t, replicas[0].GetFile("QmOther"), sto.GetFile("QmOther"))
+
+	sto.Replicas[0] = replicas[0]
+	sto.Repair([]string{"QmFile"})
+	assert.Equal(t, replicas[0].GetFile("QmFile"), sto.GetFile("QmFile"), "back in sync once repaired")
+}
+
+func TestNextBatch(t *testing.T) {
+	cids := []string{"QmD", "QmA", "QmC", "QmB"}
+	batch, cursor := nextBatch(cids, "", 3)
+	assert.Equal(t, []string{"QmA", "QmB", "QmC"}, batch)
+	batch, cursor = nextBatch(cids, cursor, 3)
+	assert.Equal(t, []string{"QmD"}, batch)
+	batch, cursor = nextBatch(cids, cursor, 3)
+	assert.Equal(t, []string{"QmA", "QmB", "QmC"}, batch, "starts again once every file was checked")
+	assert.Equal(t, "QmC", cursor)
+
+	batch, cursor = nextBatch(nil, cursor, 3)
+	assert.Empty(t, batch)
+	assert.Equal(t, "", cursor)
+}
+
+func TestReplicatedList(t *testing.T) {
+	replicas, cleanup := newReplicas(t, 2)
+	defer cleanup()
+	for _, cid := range []string{"QmA", "QmC"} {
+		_, _ = replicas[0].SaveFile(cid, bytes.NewReader([]byte(cid)), "")
+	}
+	for _, cid := range []string{"QmA", "QmB", "QmD"} {
+		_, _ = replicas[1].SaveFile(cid, bytes.NewReader([]byte(cid)), "")
+	}
+	sto, _ := NewReplicated(append(replicas, failingStorage{}), 1, "")
+
+	page, err := sto.List(context.Background(), "Qm", "", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []FileInfo{{Cid: "QmA", Size: 3}, {Cid: "QmB", Size: 3}, {Cid: "QmC", Size: 3}}, page.Files)
+	assert.Equal(t, "QmC", page.Next)
+	page, err = sto.List(context.Background(), "Qm", page.Next, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []FileInfo{{Cid: "QmD", Size: 3}}, page.Files)
+	assert.Equal(t, "", page.Next)
+}
@@ -1,8 +1,10 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -12,6 +14,7 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/decentraland/content-service/config"
 	"github.com/decentraland/content-service/metrics"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -25,15 +28,66 @@ type S3 struct {
 	ACL    *string
 	URL    string
 	Agent  *metrics.Agent
+	// Shared by every request, so credentials are resolved once and connections are reused
+	client     *s3.S3
+	uploader   *s3manager.Uploader
+	downloader *s3manager.Downloader
 }
 
-func NewS3(bucket, acl, url string, agent *metrics.Agent) *S3 {
+func NewS3(conf config.RemoteStorage, agent *metrics.Agent) (*S3, error) {
+	sess, err := session.NewSession(s3Config(conf))
+	if err != nil {
+		return nil, err
+	}
+
 	sto := new(S3)
-	sto.Bucket = aws.String(bucket)
-	sto.ACL = aws.String(acl)
-	sto.URL = url
+	sto.Bucket = aws.String(conf.Bucket)
+	if conf.ACL != "" {
+		sto.ACL = aws.String(conf.ACL)
+	}
+	sto.URL = conf.URL
 	sto.Agent = agent
-	return sto
+	sto.client = s3.New(sess)
+	sto.uploader = s3manager.NewUploaderWithClient(sto.client, func(u *s3manager.Uploader) {
+		if conf.PartSize > 0 {
+			u.PartSize = conf.PartSize
+		}
+	})
+	sto.downloader = s3manager.NewDownloaderWithClient(sto.client, func(d *s3manager.Downloader) {
+		if conf.PartSize > 0 {
+			d.PartSize = conf.PartSize
+		}
+	})
+	return sto, nil
+}
+
+func s3Config(conf config.RemoteStorage) *aws.Config {
+	timeout := time.Duration(conf.Timeout) * time.Second
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext,
+		MaxIdleConnsPerHost:   100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		ExpectContinueTimeout: time.Second,
+		// Variants are stored with their Content-Encoding, they must be retrieved as they are
+		DisableCompression: true,
+	}
+
+	c := aws.NewConfig().
+		WithHTTPClient(&http.Client{Transport: transport}).
+		WithS3ForcePathStyle(conf.PathStyle)
+	if conf.Region != "" {
+		c = c.WithRegion(conf.Region)
+	}
+	if conf.Endpoint != "" {
+		c = c.WithEndpoint(conf.Endpoint)
+	}
+	if conf.MaxRetries > 0 {
+		c = c.WithMaxRetries(conf.MaxRetries)
+	}
+	return c
 }
 
 func (sto *S3) GetFile(cid string) string {
@@ -46,17 +100,18 @@ func (sto *S3) GetFile(cid string) string {
 func (sto *S3) SaveFile(filename string, fileDesc io.Reader, contentType string) (string, error) {
 	t := time.Now()
 	log.Debugf("Uploading file[%s] to S3", filename)
-	sess := session.Must(session.NewSession())
-
-	uploader := s3manager.NewUploader(sess)
-
-	result, err := uploader.Upload(&s3manager.UploadInput{
+	input := &s3manager.UploadInput{
 		Bucket:      sto.Bucket,
 		Key:         aws.String(filename),
 		ACL:         sto.ACL,
 		Body:        fileDesc,
 		ContentType: aws.String(contentType),
-	})
+	}
+	// Clients redirected to a variant decode it as they would a compressed response
+	if e, ok := variantEncoding(filename); ok {
+		input.ContentEncoding = aws.String(e.Name)
+	}
+	result, err := sto.uploader.Upload(input)
 	sto.Agent.RecordStorageTime(time.Since(t))
 	if err != nil {
 		return "", handleS3Error(err)
@@ -77,24 +132,29 @@ func (sto *S3) DownloadFile(cid string, filePath string) error {
 		return err
 	}
 
-	s := session.Must(session.NewSession())
-	downloader := s3manager.NewDownloader(s)
-
 	f, err := os.Create(fp)
 	if err != nil {
 		log.Errorf("Failed to create file %q, %v", fp, err)
 		return InternalError{fmt.Sprintf("failed to create file %q, %v", fp, err)}
 	}
+	defer f.Close()
 
-	n, err := downloader.Download(f, &s3.GetObjectInput{
+	n, err := sto.downloader.Download(f, &s3.GetObjectInput{
 		Bucket: sto.Bucket,
 		Key:    &cid,
 	})
 	sto.Agent.RecordRetrieveTime(time.Since(t))
 
 	if err != nil {
+		// Leaves no partial file behind
+		f.Close()
+		os.Remove(fp)
 		return handleS3Error(err)
 	}
+	if err := f.Close(); err != nil {
+		os.Remove(fp)
+		return InternalError{fmt.Sprintf("failed to write file %q, %v", fp, err)}
+	}
 	sto.Agent.RecordBytesRetrieved(n)
 	log.Debugf("CID[%s] found. %d bytes downloaded from S3 to %s", cid, n, filePath)
 
@@ -102,15 +162,12 @@ func (sto *S3) DownloadFile(cid string, filePath string) error {
 }
 
 func (sto *S3) FileSize(cid string) (int64, error) {
-	s := session.Must(session.NewSession())
-	client := s3.New(s)
-
 	hi := &s3.HeadObjectInput{
 		Bucket: sto.Bucket,
 		Key:    aws.String(cid),
 	}
 
-	res, err := client.HeadObject(hi)
+	res, err := sto.client.HeadObject(hi)
 	if err != nil {
 		return 0, handleS3Error(err)
 	}
@@ -118,15 +175,121 @@ func (sto *S3) FileSize(cid string) (int64, error) {
 	return *res.ContentLength, nil
 }
 
+// Retrieves the stored object, byteRange follows the Range header format and is optional
+func (sto *S3) GetObject(cid string, byteRange string) (*Object, error) {
+	t := time.Now()
+	input := &s3.GetObjectInput{
+		Bucket: sto.Bucket,
+		Key:    aws.String(cid),
+	}
+	if byteRange != "" {
+		input.Range = aws.String(byteRange)
+	}
+	res, err := sto.client.GetObject(input)
+	sto.Agent.RecordRetrieveTime(time.Since(t))
+	if err != nil {
+		if e, ok := err.(awserr.RequestFailure); ok && e.StatusCode() == http.StatusRequestedRangeNotSatisfiable {
+			return nil, RangeNotSatisfiableError{fmt.Sprintf("invalid range %s for %s", byteRange, cid)}
+		}
+		return nil, handleS3Error(err)
+	}
+
+	return &Object{
+		Body:          res.Body,
+		ContentType:   aws.StringValue(res.ContentType),
+		ContentLength: aws.Int64Value(res.ContentLength),
+		ContentRange:  aws.StringValue(res.ContentRange),
+	}, nil
+}
+
+func (sto *S3) Open(ctx context.Context, cid string) (io.ReadCloser, *FileInfo, error) {
+	res, err := sto.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: sto.Bucket,
+		Key:    aws.String(cid),
+	})
+	if err != nil {
+		return nil, nil, handleS3Error(err)
+	}
+	info := &FileInfo{
+		Cid:         cid,
+		Size:        aws.Int64Value(res.ContentLength),
+		ContentType: aws.StringValue(res.ContentType),
+	}
+	return res.Body, info, nil
+}
+
+func (sto *S3) Stat(ctx context.Context, cid string) (*FileInfo, error) {
+	res, err := sto.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: sto.Bucket,
+		Key:    aws.String(cid),
+	})
+	if err != nil {
+		return nil, handleS3Error(err)
+	}
+	return &FileInfo{
+		Cid:         cid,
+		Size:        aws.Int64Value(res.ContentLength),
+		ContentType: aws.StringValue(res.ContentType),
+	}, nil
+}
+
+func (sto *S3) Exists(ctx context.Context, cid string) (bool, error) {
+	return exists(ctx, sto, cid)
+}
+
+func (sto *S3) Delete(ctx context.Context, cid string) error {
+	// S3 does not fail when deleting a missing key
+	if _, err := sto.Stat(ctx, cid); err != nil {
+		return err
+	}
+
+	_, err := sto.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: sto.Bucket,
+		Key:    aws.String(cid),
+	})
+	if err != nil {
+		return handleS3Error(err)
+	}
+	return nil
+}
+
+// The content type is not part of the listing, it is left empty
+func (sto *S3) List(ctx context.Context, prefix string, cursor string, limit int) (*ListPage, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:  sto.Bucket,
+		MaxKeys: aws.Int64(int64(listLimit(limit))),
+	}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+	if cursor != "" {
+		input.StartAfter = aws.String(cursor)
+	}
+	res, err := sto.client.ListObjectsV2WithContext(ctx, input)
+	if err != nil {
+		return nil, handleS3Error(err)
+	}
+
+	page := &ListPage{Files: make([]FileInfo, 0, len(res.Contents))}
+	for _, o := range res.Contents {
+		page.Files = append(page.Files, FileInfo{Cid: aws.StringValue(o.Key), Size: aws.Int64Value(o.Size)})
+	}
+	if aws.BoolValue(res.IsTruncated) && len(page.Files) > 0 {
+		page.Next = page.Files[len(page.Files)-1].Cid
+	}
+	return page, nil
+}
+
 func handleS3Error(err error) error {
-	log.Error(err.Error())
 	switch e := err.(type) {
 	case awserr.RequestFailure:
 		if e.StatusCode() == http.StatusNotFound {
 			return NotFoundError{"file not found"}
 		}
+		log.Error(err.Error())
 		return err
 	default:
+		log.Error(err.Error())
 		return InternalError{err.Error()}
 	}
 }
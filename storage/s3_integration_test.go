@@ -0,0 +1,338 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/decentraland/content-service/config"
+	"github.com/decentraland/content-service/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// S3-compatible stand-in, objects are reached with path-style urls: /<bucket>/<key>
+type s3Stub struct {
+	mu        sync.Mutex
+	objects   map[string][]byte
+	types     map[string]string
+	encodings map[string]string
+	uploads   map[string]*s3Upload
+	requests  int
+	// Parts uploaded and ranges requested
+	parts  int
+	ranges int
+}
+
+// Multipart upload in progress
+type s3Upload struct {
+	key         string
+	contentType string
+	encoding    string
+	parts       map[int][]byte
+}
+
+func newS3Stub() *s3Stub {
+	return &s3Stub{
+		objects:   map[string][]byte{},
+		types:     map[string]string{},
+		encodings: map[string]string{},
+		uploads:   map[string]*s3Upload{},
+	}
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	key := r.URL.Path
+	q := r.URL.Query()
+	switch r.Method {
+	case http.MethodPost:
+		s.multipart(w, r)
+	case http.MethodPut:
+		b, _ := ioutil.ReadAll(r.Body)
+		if u, ok := s.uploads[q.Get("uploadId")]; ok {
+			n, _ := strconv.Atoi(q.Get("partNumber"))
+			u.parts[n] = b
+			s.parts++
+			w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, n))
+			return
+		}
+		s.objects[key] = b
+		s.types[key] = r.Header.Get("Content-Type")
+		s.encodings[key] = r.Header.Get("Content-Encoding")
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet, http.MethodHead:
+		if q.Get("list-type") == "2" {
+			s.list(w, r)
+			return
+		}
+		b, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>missing</Message></Error>`)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", s.types[key])
+		if e := s.encodings[key]; e != "" {
+			w.Header().Set("Content-Encoding", e)
+		}
+		if r.Header.Get("Range") != "" {
+			s.ranges++
+		}
+		http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(b))
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// Creates (?uploads) and completes (?uploadId) the multipart uploads
+func (s *s3Stub) multipart(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if _, ok := q["uploads"]; ok {
+		id := strconv.Itoa(len(s.uploads) + 1)
+		s.uploads[id] = &s3Upload{
+			key:         r.URL.Path,
+			contentType: r.Header.Get("Content-Type"),
+			encoding:    r.Header.Get("Content-Encoding"),
+			parts:       map[int][]byte{},
+		}
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, id)
+		return
+	}
+	u, ok := s.uploads[q.Get("uploadId")]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchUpload</Code><Message>missing</Message></Error>`)
+		return
+	}
+	delete(s.uploads, q.Get("uploadId"))
+	var numbers []int
+	for n := range u.parts {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	var b []byte
+	for _, n := range numbers {
+		b = append(b, u.parts[n]...)
+	}
+	s.objects[u.key] = b
+	s.types[u.key] = u.contentType
+	s.encodings[u.key] = u.encoding
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><CompleteMultipartUploadResult><ETag>"etag"</ETag></CompleteMultipartUploadResult>`)
+}
+
+// ListObjectsV2 of the keys in the requested bucket
+func (s *s3Stub) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	bucket := strings.TrimSuffix(r.URL.Path, "/") + "/"
+	max, _ := strconv.Atoi(q.Get("max-keys"))
+	var keys []string
+	for k := range s.objects {
+		key := strings.TrimPrefix(k, bucket)
+		if strings.HasPrefix(k, bucket) && strings.HasPrefix(key, q.Get("prefix")) && key > q.Get("start-after") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	truncated := len(keys) > max
+	if truncated {
+		keys = keys[:max]
+	}
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><IsTruncated>%t</IsTruncated>`, truncated)
+	for _, k := range keys {
+		fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>%d</Size></Contents>`, k, len(s.objects[bucket+k]))
+	}
+	fmt.Fprint(w, `</ListBucketResult>`)
+}
+
+// Runs against the local stand-in. To run it against an S3-compatible server, e.g. MinIO, set RUN_IT=true,
+// S3_TEST_ENDPOINT and S3_TEST_BUCKET along with the AWS credentials
+func TestS3(t *testing.T) {
+	conf := config.RemoteStorage{
+		Region:     "us-east-1",
+		PathStyle:  true,
+		MaxRetries: 1,
+		Timeout:    5,
+	}
+	var stub *s3Stub
+	connections := 0
+	if os.Getenv("RUN_IT") == "true" && os.Getenv("S3_TEST_ENDPOINT") != "" {
+		conf.Endpoint = os.Getenv("S3_TEST_ENDPOINT")
+		conf.Bucket = os.Getenv("S3_TEST_BUCKET")
+	} else {
+		stub = newS3Stub()
+		server := httptest.NewUnstartedServer(stub)
+		server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				connections++
+			}
+		}
+		server.Start()
+		defer server.Close()
+		conf.Endpoint = server.URL
+		conf.Bucket = "content"
+		defer setEnv("AWS_ACCESS_KEY_ID", "key")()
+		defer setEnv("AWS_SECRET_ACCESS_KEY", "secret")()
+	}
+	conf.URL = strings.TrimSuffix(conf.Endpoint, "/") + "/" + conf.Bucket
+
+	agent, _ := metrics.Make(config.Metrics{AppName: "", Enabled: false, AnalyticsKey: ""})
+	sto, err := NewS3(conf, agent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cid := fmt.Sprintf("QmS3Test%d", time.Now().UnixNano())
+
+	_, err = sto.SaveFile(cid, bytes.NewReader([]byte("0123456789")), "model/gltf-binary")
+	assert.Nil(t, err)
+	if stub != nil {
+		assert.Equal(t, []byte("0123456789"), stub.objects["/content/"+cid], "the bucket is in the path")
+	}
+
+	size, err := sto.FileSize(cid)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+
+	dir, _ := ioutil.TempDir("", "s3")
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "file")
+	assert.Nil(t, sto.DownloadFile(cid, out))
+	b, _ := ioutil.ReadFile(out)
+	assert.Equal(t, "0123456789", string(b))
+
+	obj, err := sto.GetObject(cid, "bytes=2-5")
+	assert.Nil(t, err)
+	b, _ = ioutil.ReadAll(obj.Body)
+	obj.Body.Close()
+	assert.Equal(t, "2345", string(b))
+	assert.Equal(t, "model/gltf-binary", obj.ContentType)
+	_, err = sto.GetObject(cid, "bytes=100-200")
+	assert.IsType(t, RangeNotSatisfiableError{}, err)
+
+	r, info, err := sto.Open(context.Background(), cid)
+	assert.Nil(t, err)
+	b, _ = ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "0123456789", string(b))
+	assert.Equal(t, &FileInfo{Cid: cid, Size: 10, ContentType: "model/gltf-binary"}, info)
+	info, err = sto.Stat(context.Background(), cid)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), info.Size)
+	ok, err := sto.Exists(context.Background(), cid+"missing")
+	assert.False(t, ok)
+	assert.Nil(t, err)
+
+	// Variants are stored with their encoding and retrieved as they are
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	_, _ = gz.Write([]byte("0123456789"))
+	_ = gz.Close()
+	_, err = sto.SaveFile(cid+".gz", bytes.NewReader(gzipped.Bytes()), "model/gltf-binary")
+	assert.Nil(t, err)
+	if stub != nil {
+		assert.Equal(t, "gzip", stub.encodings["/content/"+cid+".gz"])
+	}
+	r, _, err = sto.Open(context.Background(), cid+".gz")
+	assert.Nil(t, err)
+	b, _ = ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, gzipped.Bytes(), b)
+	assert.Nil(t, sto.Delete(context.Background(), cid+".gz"))
+
+	_, err = sto.SaveFile(cid+"b", bytes.NewReader([]byte("other")), "")
+	assert.Nil(t, err)
+	page, err := sto.List(context.Background(), cid, "", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []FileInfo{{Cid: cid, Size: 10}}, page.Files)
+	assert.Equal(t, cid, page.Next)
+	page, err = sto.List(context.Background(), cid, page.Next, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []FileInfo{{Cid: cid + "b", Size: 5}}, page.Files)
+	assert.Equal(t, "", page.Next)
+	assert.Nil(t, sto.Delete(context.Background(), cid+"b"))
+
+	_, err = sto.FileSize(cid + "missing")
+	assert.IsType(t, NotFoundError{}, err)
+	assert.IsType(t, NotFoundError{}, sto.DownloadFile(cid+"missing", out))
+	_, err = os.Stat(out)
+	assert.True(t, os.IsNotExist(err), "no partial file is left behind")
+
+	assert.Nil(t, sto.Delete(context.Background(), cid))
+	assert.IsType(t, NotFoundError{}, sto.Delete(context.Background(), cid))
+
+	if stub != nil {
+		assert.Equal(t, 1, connections, "the connection is reused across requests")
+	}
+}
+
+// Files larger than the part size are uploaded in parts and downloaded in ranges
+func TestS3Multipart(t *testing.T) {
+	stub := newS3Stub()
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	defer setEnv("AWS_ACCESS_KEY_ID", "key")()
+	defer setEnv("AWS_SECRET_ACCESS_KEY", "secret")()
+
+	agent, _ := metrics.Make(config.Metrics{AppName: "", Enabled: false, AnalyticsKey: ""})
+	sto, err := NewS3(config.RemoteStorage{
+		Region:     "us-east-1",
+		Endpoint:   server.URL,
+		Bucket:     "content",
+		PathStyle:  true,
+		MaxRetries: 1,
+		Timeout:    5,
+		PartSize:   s3manager.MinUploadPartSize,
+	}, agent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := bytes.Repeat([]byte("0123456789"), int(2*s3manager.MinUploadPartSize/10+1))
+	_, err = sto.SaveFile("QmLarge", bytes.NewReader(content), "model/gltf-binary")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, stub.parts)
+	assert.Empty(t, stub.uploads, "the upload is completed")
+	assert.Equal(t, content, stub.objects["/content/QmLarge"])
+	assert.Equal(t, "model/gltf-binary", stub.types["/content/QmLarge"])
+
+	dir, _ := ioutil.TempDir("", "s3")
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "file")
+	assert.Nil(t, sto.DownloadFile("QmLarge", out))
+	b, _ := ioutil.ReadFile(out)
+	assert.Equal(t, content, b)
+	assert.Equal(t, 3, stub.ranges)
+}
+
+// Retrieves the function restoring the previous value
+func setEnv(key string, value string) func() {
+	old, ok := os.LookupEnv(key)
+	_ = os.Setenv(key, value)
+	return func() {
+		if ok {
+			_ = os.Setenv(key, old)
+		} else {
+			_ = os.Unsetenv(key)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package storage
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/decentraland/content-service/metrics"
 
@@ -15,28 +18,176 @@ type Storage interface {
 	SaveFile(filename string, fileDesc io.Reader, contentType string) (string, error)
 	DownloadFile(cid string, fileName string) error
 	FileSize(cid string) (int64, error)
+	// Streams the file, NotFoundError if it does not exist. The caller must close the reader
+	Open(ctx context.Context, cid string) (io.ReadCloser, *FileInfo, error)
+	// NotFoundError if the file does not exist
+	Stat(ctx context.Context, cid string) (*FileInfo, error)
+	Exists(ctx context.Context, cid string) (bool, error)
+	// Removes the file, NotFoundError if it does not exist
+	Delete(ctx context.Context, cid string) error
+	// Files whose CID starts with prefix, sorted by CID and starting after the cursor one
+	// An empty cursor starts from the first file, a non-positive limit lists up to defaultListLimit files
+	List(ctx context.Context, prefix string, cursor string, limit int) (*ListPage, error)
+}
+
+// Files in a page when no limit is given, the same S3 uses
+const defaultListLimit = 1000
+
+func listLimit(limit int) int {
+	if limit <= 0 {
+		return defaultListLimit
+	}
+	return limit
+}
+
+type FileInfo struct {
+	Cid         string
+	Size        int64
+	ContentType string
+}
+
+type ListPage struct {
+	Files []FileInfo
+	// Cursor of the next page, empty in the last one
+	Next string
 }
 
 func NewStorage(conf *config.Storage, agent *metrics.Agent) Storage {
 	log.Infof("Storage mode: %s", conf.StorageType)
-	switch config.StorageType(strings.ToUpper(conf.StorageType)) {
-	case config.LOCAL:
-		return buildLocalStorage(conf)
-	case config.REMOTE:
-		return NewS3(conf.RemoteConfig.Bucket, conf.RemoteConfig.ACL, conf.RemoteConfig.URL, agent)
+	sto, err := build(conf, agent)
+	if err != nil {
+		log.Fatalf("%s. Available storage types: %s", err.Error(), strings.Join(Drivers(), ", "))
+	}
+	if _, local := sto.(*Local); conf.Cache.Enabled && local {
+		log.Warn("Storage cache ignored, the files are already local")
+	} else if conf.Cache.Enabled {
+		log.Infof("Storage cache: %s, %d bytes, %s", conf.Cache.Dir, conf.Cache.MaxSize, conf.Cache.Eviction)
+		sto, err = NewTiered(sto, conf.Cache.Dir, conf.Cache.MaxSize, conf.Cache.Eviction, agent)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	return sto
+}
+
+// Builds the storage with the driver registered for its type
+func build(conf *config.Storage, agent *metrics.Agent) (Storage, error) {
+	driversMu.RLock()
+	driver, ok := drivers[strings.ToUpper(conf.StorageType)]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("invalid storage type: %s", conf.StorageType)
+	}
+	return driver(conf, agent)
+}
+
+func init() {
+	Register(string(config.LOCAL), func(conf *config.Storage, agent *metrics.Agent) (Storage, error) {
+		sto := NewLocal(conf.LocalPath)
+		return sto, sto.CreateLocalDir()
+	})
+	Register(string(config.SHARDED), func(conf *config.Storage, agent *metrics.Agent) (Storage, error) {
+		sto := NewShardedLocal(conf.LocalPath)
+		return sto, sto.CreateLocalDir()
+	})
+	Register(string(config.REMOTE), func(conf *config.Storage, agent *metrics.Agent) (Storage, error) {
+		return NewS3(conf.RemoteConfig, agent)
+	})
+	Register(string(config.HTTP), func(conf *config.Storage, agent *metrics.Agent) (Storage, error) {
+		if conf.HTTPConfig.URL == "" {
+			return nil, fmt.Errorf("missing HTTP storage url")
+		}
+		timeout := time.Duration(conf.HTTPConfig.Timeout) * time.Second
+		return NewHTTPStore(conf.HTTPConfig.URL, conf.HTTPConfig.Token, timeout, agent), nil
+	})
+	Register(string(config.REPLICATED), func(conf *config.Storage, agent *metrics.Agent) (Storage, error) {
+		replicas := make([]Storage, 0, len(conf.Replication.Replicas))
+		for i := range conf.Replication.Replicas {
+			r, err := build(&conf.Replication.Replicas[i], agent)
+			if err != nil {
+				return nil, fmt.Errorf("replica %d: %s", i, err.Error())
+			}
+			replicas = append(replicas, r)
+		}
+		return NewReplicated(replicas, conf.Replication.WriteQuorum, conf.Replication.Workdir)
+	})
+}
+
+// Retrieves whether GetFile is a location clients can be redirected to
+// Local files, even behind a cache or replicated, are only readable by the service
+func Redirectable(sto Storage) bool {
+	switch s := sto.(type) {
+	case *Local:
+		return false
+	case *Tiered:
+		return Redirectable(s.Remote)
+	case *Replicated:
+		// Clients may be sent to any of them
+		for _, r := range s.Replicas {
+			if !Redirectable(r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Retrieves whether clients redirected to the location of a variant receive it with its Content-Encoding
+func RedirectsVariants(sto Storage) bool {
+	switch s := sto.(type) {
+	case *S3:
+		return true
+	case *Tiered:
+		return RedirectsVariants(s.Remote)
+	case *Replicated:
+		for _, r := range s.Replicas {
+			if !RedirectsVariants(r) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Storages able to retrieve the content along with its metadata
+type objectGetter interface {
+	GetObject(cid string, byteRange string) (*Object, error)
+}
+
+// Content of a stored file
+type Object struct {
+	Body          io.ReadCloser
+	ContentType   string
+	ContentLength int64
+	// Set when only a range of the content is retrieved, in the Content-Range header format
+	ContentRange string
+}
+
+// Exists implementation for the storages able to Stat a file
+func exists(ctx context.Context, sto Storage, cid string) (bool, error) {
+	_, err := sto.Stat(ctx, cid)
+	switch err.(type) {
+	case nil:
+		return true, nil
+	case NotFoundError:
+		return false, nil
 	default:
-		log.Fatalf("Invalid Storage Type: %s", conf.StorageType)
+		return false, err
 	}
-	return nil
 }
 
-func buildLocalStorage(conf *config.Storage) Storage {
-	sto := NewLocal(conf.LocalPath)
-	err := sto.CreateLocalDir()
-	if err != nil {
-		log.Fatal(err)
+// Reader that fails as soon as the context is done
+type contextReader struct {
+	ctx context.Context
+	io.ReadCloser
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
 	}
-	return sto
+	return r.ReadCloser.Read(p)
 }
 
 type NotFoundError struct {
@@ -54,3 +205,20 @@ type InternalError struct {
 func (e InternalError) Error() string {
 	return e.Cause
 }
+
+type RangeNotSatisfiableError struct {
+	Cause string
+}
+
+func (e RangeNotSatisfiableError) Error() string {
+	return e.Cause
+}
+
+// The content written does not hash to the CID it was stored under
+type CidMismatchError struct {
+	Cause string
+}
+
+func (e CidMismatchError) Error() string {
+	return e.Cause
+}
@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/decentraland/content-service/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// Storage with a bounded disk cache in front of another one, usually a remote one
+// Reads are served from the cache when possible and fill it on a miss, writes go to both layers
+// The content of a CID never changes, but the file may be deleted, also by another instance sharing the
+// remote storage, so existence and sizes are always checked against it
+type Tiered struct {
+	Remote Storage
+	Cache  *Local
+	Agent  *metrics.Agent
+	files  *evictionList
+}
+
+// maxSize is the amount of bytes the cache can hold, policy is either LRU or FIFO
+func NewTiered(remote Storage, dir string, maxSize int64, policy string, agent *metrics.Agent) (*Tiered, error) {
+	if policy == "" {
+		policy = LRU
+	}
+	if p := strings.ToUpper(policy); p != LRU && p != FIFO {
+		return nil, fmt.Errorf("invalid eviction policy: %s", policy)
+	}
+	sto := &Tiered{
+		Remote: remote,
+		Cache:  NewShardedLocal(dir),
+		Agent:  agent,
+		files:  newEvictionList(policy, maxSize),
+	}
+	if err := sto.load(); err != nil {
+		return nil, err
+	}
+	return sto, nil
+}
+
+// Adds the files already in the cache directory, oldest first, and removes the unfinished ones
+func (sto *Tiered) load() error {
+	if err := sto.Cache.CreateLocalDir(); err != nil {
+		return err
+	}
+	tmp := filepath.Join(sto.Cache.Dir, tmpDir)
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmp, os.ModePerm); err != nil {
+		return err
+	}
+
+	var files []os.FileInfo
+	err := filepath.Walk(sto.Cache.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != sto.Cache.Dir && filepath.Base(path)[0] == '.' {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, contentTypeSuffix) {
+			files = append(files, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, f := range files {
+		sto.add(f.Name(), f.Size())
+	}
+	return nil
+}
+
+func (sto *Tiered) GetFile(cid string) string {
+	return sto.Remote.GetFile(cid)
+}
+
+func (sto *Tiered) SaveFile(filename string, fileDesc io.Reader, contentType string) (string, error) {
+	tmp, err := sto.tempFile()
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	location, err := sto.Remote.SaveFile(filename, io.TeeReader(fileDesc, tmp), contentType)
+	if err != nil {
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		log.WithError(err).Errorf("fail to cache CID[%s]", filename)
+		return location, nil
+	}
+	sto.commit(filename, tmp.Name(), contentType)
+	return location, nil
+}
+
+func (sto *Tiered) DownloadFile(cid string, fileName string) error {
+	if _, ok := sto.files.get(cid); ok {
+		err := sto.Cache.DownloadFile(cid, fileName)
+		if err == nil {
+			sto.Agent.RecordStorageCacheHit()
+			return nil
+		}
+		// Evicted meanwhile
+		sto.files.remove(cid)
+	}
+	sto.Agent.RecordStorageCacheMiss()
+
+	tmp, err := sto.tempFile()
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	contentType, err := fetch(context.Background(), sto.Remote, cid, tmp.Name())
+	if err != nil {
+		sto.forgetMissing(cid, err)
+		return err
+	}
+	// Copied before caching it, a file bigger than the cache is evicted right away
+	if err := NewLocal(filepath.Dir(tmp.Name())).DownloadFile(filepath.Base(tmp.Name()), fileName); err != nil {
+		return err
+	}
+	sto.commit(cid, tmp.Name(), contentType)
+	return nil
+}
+
+// The remote storage is always asked, a file known to this instance may have been deleted by another one
+func (sto *Tiered) FileSize(cid string) (int64, error) {
+	size, err := sto.Remote.FileSize(cid)
+	if err != nil {
+		sto.forgetMissing(cid, err)
+		return 0, err
+	}
+	return size, nil
+}
+
+// A miss is streamed from the remote storage without filling the cache, as the reader may not be consumed
+func (sto *Tiered) Open(ctx context.Context, cid string) (io.ReadCloser, *FileInfo, error) {
+	if _, ok := sto.files.get(cid); ok {
+		r, info, err := sto.Cache.Open(ctx, cid)
+		if err == nil {
+			sto.Agent.RecordStorageCacheHit()
+			return r, info, nil
+		}
+		sto.files.remove(cid)
+	}
+	sto.Agent.RecordStorageCacheMiss()
+	r, info, err := sto.Remote.Open(ctx, cid)
+	sto.forgetMissing(cid, err)
+	return r, info, err
+}
+
+func (sto *Tiered) Stat(ctx context.Context, cid string) (*FileInfo, error) {
+	if _, ok := sto.files.get(cid); ok {
+		info, err := sto.Cache.Stat(ctx, cid)
+		if err == nil {
+			sto.Agent.RecordStorageCacheHit()
+			return info, nil
+		}
+		sto.files.remove(cid)
+	}
+	sto.Agent.RecordStorageCacheMiss()
+	info, err := sto.Remote.Stat(ctx, cid)
+	sto.forgetMissing(cid, err)
+	return info, err
+}
+
+// The remote storage is always asked, a file known to this instance may have been deleted by another one
+func (sto *Tiered) Exists(ctx context.Context, cid string) (bool, error) {
+	ok, err := sto.Remote.Exists(ctx, cid)
+	if err == nil && !ok {
+		sto.forget(cid)
+	}
+	return ok, err
+}
+
+func (sto *Tiered) Delete(ctx context.Context, cid string) error {
+	sto.forget(cid)
+	return sto.Remote.Delete(ctx, cid)
+}
+
+// Drops the cached copy of a file no longer stored
+func (sto *Tiered) forget(cid string) {
+	sto.files.remove(cid)
+	if err := sto.Cache.Delete(context.Background(), cid); err != nil {
+		if _, ok := err.(NotFoundError); !ok {
+			log.WithError(err).Errorf("fail to remove CID[%s] from the cache", cid)
+		}
+	}
+}
+
+func (sto *Tiered) forgetMissing(cid string, err error) {
+	if _, ok := err.(NotFoundError); ok {
+		sto.forget(cid)
+	}
+}
+
+func (sto *Tiered) List(ctx context.Context, prefix string, cursor string, limit int) (*ListPage, error) {
+	return sto.Remote.List(ctx, prefix, cursor, limit)
+}
+
+// Ranges are not kept in the cache, the object is always retrieved from the remote storage
+func (sto *Tiered) GetObject(cid string, byteRange string) (*Object, error) {
+	getter, ok := sto.Remote.(objectGetter)
+	if !ok {
+		return nil, InternalError{fmt.Sprintf("%T can not retrieve objects", sto.Remote)}
+	}
+	return getter.GetObject(cid, byteRange)
+}
+
+func (sto *Tiered) tempFile() (*os.File, error) {
+	return ioutil.TempFile(filepath.Join(sto.Cache.Dir, tmpDir), "fill-")
+}
+
+// Moves the complete file into the cache
+func (sto *Tiered) commit(cid string, tmp string, contentType string) {
+	info, err := os.Stat(tmp)
+	if err != nil {
+		log.WithError(err).Errorf("fail to cache CID[%s]", cid)
+		return
+	}
+	path := sto.Cache.GetFile(cid)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		log.WithError(err).Errorf("fail to cache CID[%s]", cid)
+		return
+	}
+	if contentType != "" {
+		if err := sto.Cache.writeFileAtomic(path+contentTypeSuffix, []byte(contentType)); err != nil {
+			log.WithError(err).Errorf("fail to cache CID[%s]", cid)
+			return
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.WithError(err).Errorf("fail to cache CID[%s]", cid)
+		return
+	}
+	sto.add(cid, info.Size())
+}
+
+func (sto *Tiered) add(cid string, size int64) {
+	for _, evicted := range sto.files.add(cid, size, size) {
+		if err := sto.Cache.Delete(context.Background(), evicted); err != nil {
+			if _, ok := err.(NotFoundError); ok {
+				continue
+			}
+			log.WithError(err).Errorf("fail to evict CID[%s] from the cache", evicted)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/decentraland/content-service/config"
+	"github.com/decentraland/content-service/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvictionList(t *testing.T) {
+	for _, tc := range evictionTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newEvictionList(tc.policy, 10)
+			l.add("a", 4, 4)
+			l.add("b", 4, 4)
+			l.get("a")
+			assert.Equal(t, tc.evicted, l.add("c", 4, 4))
+			_, ok := l.get(tc.evicted[0])
+			assert.False(t, ok)
+		})
+	}
+
+	l := newEvictionList(LRU, 10)
+	assert.Equal(t, []string{"big"}, l.add("big", 11, 11))
+	assert.Equal(t, int64(0), l.cost)
+}
+
+type evictionCase struct {
+	name    string
+	policy  string
+	evicted []string
+}
+
+var evictionTestCases = []evictionCase{
+	{name: "LRU", policy: LRU, evicted: []string{"b"}},
+	{name: "FIFO", policy: FIFO, evicted: []string{"a"}},
+}
+
+func TestTiered(t *testing.T) {
+	stub := &objectStoreStub{objects: map[string][]byte{}, types: map[string]string{}}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "tiered")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	agent, _ := metrics.Make(config.Metrics{AppName: "", Enabled: false, AnalyticsKey: ""})
+	remote := NewHTTPStore(server.URL+"/bucket", "", time.Second, agent)
+	stub.objects["QmRemote"] = []byte("remote")
+	stub.objects["QmHuge"] = []byte("a file bigger than the cache")
+
+	sto, err := NewTiered(remote, filepath.Join(dir, "cache"), 20, LRU, agent)
+	assert.Nil(t, err)
+	requests := func() int {
+		n := len(stub.auth)
+		stub.auth = nil
+		return n
+	}
+
+	_, err = sto.SaveFile("QmSaved", bytes.NewReader([]byte("saved")), "text/plain")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("saved"), stub.objects["QmSaved"], "written through the remote storage")
+	assert.Equal(t, 1, requests())
+
+	size, err := sto.FileSize("QmSaved")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), size)
+	assert.Equal(t, 1, requests(), "the size is checked against the remote storage")
+	out := filepath.Join(dir, "out")
+	assert.Nil(t, sto.DownloadFile("QmSaved", out))
+	assert.Equal(t, 0, requests(), "served from the cache")
+
+	assert.Nil(t, sto.DownloadFile("QmRemote", out))
+	b, _ := ioutil.ReadFile(out)
+	assert.Equal(t, "remote", string(b))
+	assert.Nil(t, sto.DownloadFile("QmRemote", out))
+	assert.Equal(t, 1, requests(), "filled on a miss")
+
+	assert.Nil(t, sto.DownloadFile("QmHuge", out))
+	b, _ = ioutil.ReadFile(out)
+	assert.Equal(t, "a file bigger than the cache", string(b))
+	_, err = os.Stat(sto.Cache.GetFile("QmHuge"))
+	assert.True(t, os.IsNotExist(err), "not kept in the cache")
+	size, err = sto.FileSize("QmHuge")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(28), size)
+
+	_, err = sto.FileSize("QmMissing")
+	assert.IsType(t, NotFoundError{}, err)
+	assert.IsType(t, NotFoundError{}, sto.DownloadFile("QmMissing", out))
+
+	// The cache is restored on restart
+	restarted, err := NewTiered(remote, filepath.Join(dir, "cache"), 20, LRU, agent)
+	assert.Nil(t, err)
+	requests()
+	assert.Nil(t, restarted.DownloadFile("QmRemote", out))
+	assert.Equal(t, 0, requests())
+
+	assert.Nil(t, restarted.Delete(context.Background(), "QmRemote"))
+	_, ok := stub.objects["QmRemote"]
+	assert.False(t, ok)
+	_, err = os.Stat(restarted.Cache.GetFile("QmRemote"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Deleted by another instance sharing the remote storage
+	ok, err = sto.Exists(context.Background(), "QmRemote")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, err = sto.FileSize("QmRemote")
+	assert.IsType(t, NotFoundError{}, err, "the size is no longer known")
+
+	// The cached copy is dropped once the remote storage no longer has it
+	delete(stub.objects, "QmSaved")
+	_, err = sto.FileSize("QmSaved")
+	assert.IsType(t, NotFoundError{}, err)
+	_, err = os.Stat(sto.Cache.GetFile("QmSaved"))
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = NewTiered(remote, filepath.Join(dir, "cache"), 20, "random", agent)
+	assert.NotNil(t, err)
+}
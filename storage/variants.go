@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Content-Encoding a file can be stored with, next to the original one
+// The original file is the one identified by the CID, the variants are only a cheaper way of serving it
+type Encoding struct {
+	// Name used in the Accept-Encoding and Content-Encoding headers
+	Name string
+	// Appended to the CID to get the key of the variant
+	Suffix string
+	// Compresses what is written into w
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+	// Decompresses what is read from r
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// Encodings stored for compressible files, in order of preference
+var Encodings = []Encoding{
+	{
+		Name:   "br",
+		Suffix: ".br",
+		// The best level is too slow for the upload path, it barely improves the ratio
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return brotli.NewWriterLevel(w, 9), nil
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(brotli.NewReader(r)), nil
+		},
+	}, {
+		Name:   "gzip",
+		Suffix: ".gz",
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, gzip.BestCompression)
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+}
+
+// Key of the file with the given encoding
+func VariantKey(cid string, e Encoding) string {
+	return cid + e.Suffix
+}
+
+// Retrieves the encoding of the variant stored under the key, false for the original files
+func variantEncoding(key string) (Encoding, bool) {
+	for _, e := range Encodings {
+		if strings.HasSuffix(key, e.Suffix) {
+			return e, true
+		}
+	}
+	return Encoding{}, false
+}